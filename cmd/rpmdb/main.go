@@ -6,8 +6,6 @@ import (
 
 	multierror "github.com/hashicorp/go-multierror"
 	rpmdb "github.com/jfrog/go-rpmdb/pkg"
-
-	_ "github.com/glebarez/go-sqlite"
 )
 
 func main() {
@@ -38,6 +36,16 @@ func run() error {
 		pkg.FileFlags = nil
 		pkg.UserNames = nil
 		pkg.GroupNames = nil
+		pkg.FileSignatures = nil
+		pkg.VeritySignatures = nil
+		pkg.FileAltDigests = nil
+		pkg.FileMTimes = nil
+		pkg.FileLinkTos = nil
+		pkg.FileInodes = nil
+		pkg.LongFileSizes = nil
+		pkg.FileStates = nil
+		pkg.FileRdevs = nil
+		pkg.FileCaps = nil
 
 		fmt.Printf("\t%+v\n", *pkg)
 	}
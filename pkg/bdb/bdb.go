@@ -3,7 +3,6 @@ package bdb
 import (
 	"context"
 	"io"
-	"os"
 
 	dbi "github.com/jfrog/go-rpmdb/pkg/db"
 	"golang.org/x/xerrors"
@@ -21,26 +20,32 @@ var validPageSizes = map[uint32]struct{}{
 }
 
 type BerkeleyDB struct {
-	file         *os.File
+	file         dbi.File
 	HashMetadata *HashMetadataPage
+
+	headers headerPages
 }
 
 func Open(path string) (*BerkeleyDB, error) {
-	file, err := os.Open(path)
+	file, err := dbi.OpenFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	// read just a bit in to parse at least the metadata...
-	metadataBuff := make([]byte, 512)
-	_, err = file.Read(metadataBuff)
+	db, err := OpenFile(file)
 	if err != nil {
-		return nil, xerrors.Errorf("failed to read metadata: %w", err)
+		file.Close()
+		return nil, err
 	}
+	return db, nil
+}
 
-	_, err = file.Seek(0, io.SeekStart)
+// OpenFile opens the Packages database in an opened file, e.g. one mapped by dbi.OpenMmap.
+// The file is closed by Close.
+func OpenFile(file dbi.File) (*BerkeleyDB, error) {
+	metadataBuff, err := readMetadata(file)
 	if err != nil {
-		return nil, xerrors.Errorf("failed to seek db file: %w", err)
+		return nil, err
 	}
 
 	hashMetadata, err := ParseHashMetadataPage(metadataBuff)
@@ -49,7 +54,7 @@ func Open(path string) (*BerkeleyDB, error) {
 	}
 
 	if _, ok := validPageSizes[hashMetadata.PageSize]; !ok {
-		return nil, xerrors.Errorf("unexpected page size: %+v", hashMetadata.PageSize)
+		return nil, xerrors.Errorf("unexpected page size %+v: %w", hashMetadata.PageSize, dbi.ErrNotRPMDB)
 	}
 
 	return &BerkeleyDB{
@@ -58,26 +63,28 @@ func Open(path string) (*BerkeleyDB, error) {
 	}, nil
 }
 
+// Read returns the headers in the order of the pages. It doesn't change any state of the
+// database, so that several goroutines can read it at once.
 func (db *BerkeleyDB) Read(ctx context.Context) <-chan dbi.Entry {
 	entries := make(chan dbi.Entry)
 
 	go func() {
 		defer close(entries)
 
+		pageSize := int64(db.HashMetadata.PageSize)
+		pageData := make([]byte, pageSize)
 		for pageNum := uint32(0); pageNum <= db.HashMetadata.LastPageNo; pageNum++ {
-			pageData, err := slice(db.file, int(db.HashMetadata.PageSize))
-			if err != nil {
+			if ctx.Err() != nil {
 				entries <- dbi.Entry{
-					Err: err,
+					Err: ctx.Err(),
 				}
 				return
 			}
 
-			// keep track of the start of the next page for the next iteration...
-			endOfPageOffset, err := db.file.Seek(0, io.SeekCurrent)
+			err := slice(db.file, int64(pageNum)*pageSize, pageData)
 			if err != nil {
 				entries <- dbi.Entry{
-					Err: err,
+					Err: corruptPage(pageNum, err),
 				}
 				return
 			}
@@ -85,7 +92,7 @@ func (db *BerkeleyDB) Read(ctx context.Context) <-chan dbi.Entry {
 			hashPageHeader, err := ParseHashPage(pageData, db.HashMetadata.Swapped)
 			if err != nil {
 				entries <- dbi.Entry{
-					Err: err,
+					Err: corruptPage(pageNum, err),
 				}
 				return
 			}
@@ -96,52 +103,104 @@ func (db *BerkeleyDB) Read(ctx context.Context) <-chan dbi.Entry {
 				continue
 			}
 
-			hashPageIndexes, err := HashPageValueIndexes(pageData, hashPageHeader.NumEntries, db.HashMetadata.Swapped)
+			pairs, err := hashPageItems(pageData, pageNum, hashPageHeader.NumEntries, byteOrder(db.HashMetadata.Swapped))
 			if err != nil {
 				entries <- dbi.Entry{
-					Err: err,
+					Err: corruptPage(pageNum, err),
 				}
 				return
 			}
 
-			for _, hashPageIndex := range hashPageIndexes {
-				// the first byte is the page type, so we can peek at it first before parsing further...
-				valuePageType := pageData[hashPageIndex]
-
-				// Only Overflow pages contain package data, skip anything else.
-				if valuePageType != HashOffIndexPageType {
-					continue
-				}
-
-				// Traverse the page to concatenate the data that may span multiple pages.
-				valueContent, err := HashPageValueContent(ctx,
-					db.file,
-					pageData,
-					hashPageIndex,
-					db.HashMetadata.PageSize,
-					db.HashMetadata.Swapped,
-				)
-
-				entries <- dbi.Entry{
-					Value: valueContent,
-					Err:   err,
+			for _, pair := range pairs {
+				// the keys are the header instance numbers
+				headerNum := db.headerNum(pair[0])
+				valueItem := pair[1]
+
+				var values [][]byte
+				if valueItem[0] == HashOffIndexPageType {
+					// Traverse the page to concatenate the data that may span multiple pages.
+					valueContent, err := itemValue(ctx, db.file, valueItem, db.HashMetadata.PageSize, db.HashMetadata.Swapped)
+					if err != nil {
+						entries <- dbi.Entry{
+							HeaderNum: headerNum,
+							Err:       pageError(ctx, pageNum, err),
+						}
+						return
+					}
+					values = [][]byte{valueContent}
+				} else {
+					// hnum 0 records the next free instance number, it's not a header
+					if headerNum == 0 {
+						continue
+					}
+					// small headers are stored on the hash page, or as duplicates
+					values, err = db.database().hashItemValues(ctx, valueItem)
+					if err != nil {
+						entries <- dbi.Entry{
+							HeaderNum: headerNum,
+							Err:       pageError(ctx, pageNum, xerrors.Errorf("failed to read value of hnum %d: %w", headerNum, err)),
+						}
+						return
+					}
 				}
 
-				if err != nil {
-					return
+				for _, value := range values {
+					entries <- dbi.Entry{
+						Value:     value,
+						HeaderNum: headerNum,
+					}
 				}
 			}
-
-			// go back to the start of the next page for reading...
-			_, err = db.file.Seek(endOfPageOffset, io.SeekStart)
-			if err != nil {
-				entries <- dbi.Entry{
-					Err: err,
-				}
-				return
-			}
 		}
 	}()
 
 	return entries
 }
+
+// readMetadata reads the metadata page, which is at least 512 bytes long in every
+// Berkeley DB file
+func readMetadata(file dbi.File) ([]byte, error) {
+	metadataBuff := make([]byte, 512)
+	if _, err := file.ReadAt(metadataBuff, 0); err != nil {
+		if xerrors.Is(err, io.EOF) {
+			return nil, xerrors.Errorf("file too short for metadata: %w", dbi.ErrNotRPMDB)
+		}
+		return nil, xerrors.Errorf("failed to read metadata: %w", err)
+	}
+	return metadataBuff, nil
+}
+
+// corruptPage attributes err to pageNo, unless it already names a page, e.g. an overflow page
+func corruptPage(pageNo uint32, err error) error {
+	var pageErr *dbi.ErrCorruptPage
+	if xerrors.As(err, &pageErr) {
+		return err
+	}
+	return &dbi.ErrCorruptPage{PageNo: pageNo, Err: err}
+}
+
+// pageError returns the cancellation of ctx as is, any other error is attributed to pageNo
+func pageError(ctx context.Context, pageNo uint32, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && xerrors.Is(err, ctxErr) {
+		return err
+	}
+	return corruptPage(pageNo, err)
+}
+
+// database returns the generic view of the Packages database
+func (db *BerkeleyDB) database() *Database {
+	return &Database{
+		file:       db.file,
+		pageSize:   db.HashMetadata.PageSize,
+		lastPageNo: db.HashMetadata.LastPageNo,
+		swapped:    db.HashMetadata.Swapped,
+	}
+}
+
+// headerNum returns the header instance number stored in a Packages key item
+func (db *BerkeleyDB) headerNum(keyItem []byte) uint32 {
+	if len(keyItem) != 5 || keyItem[0] != HashKeyDataType {
+		return 0
+	}
+	return byteOrder(db.HashMetadata.Swapped).Uint32(keyItem[1:])
+}
@@ -0,0 +1,178 @@
+package bdb
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"golang.org/x/xerrors"
+)
+
+const (
+	BtreeMagicNumber   = 0x00053162
+	BtreeMagicNumberBE = 0x62310500
+
+	// btree page types
+	// https://github.com/berkeleydb/libdb/blob/v5.3.28/src/dbinc/db_page.h#L35-L53
+	BtreeInternalPageType      PageType = 3  // aka P_IBTREE
+	RecnoInternalPageType      PageType = 4  // aka P_IRECNO, internal pages of off-page duplicate trees
+	BtreeLeafPageType          PageType = 5  // aka P_LBTREE
+	BtreeMetadataPageType      PageType = 9  // aka P_BTREEMETA
+	BtreeDuplicateLeafPageType PageType = 12 // aka P_LDUP
+
+	// btree item types
+	// https://github.com/berkeleydb/libdb/blob/v5.3.28/src/dbinc/db_page.h
+	BtreeKeyDataType   uint8 = 1 // aka B_KEYDATA
+	BtreeDuplicateType uint8 = 2 // aka B_DUPLICATE
+	BtreeOverflowType  uint8 = 3 // aka B_OVERFLOW
+	btreeDeletedFlag   uint8 = 0x80
+
+	// the size (in bytes) of the fixed part of the items
+	btreeKeyDataHeaderSize  = 3  // BKEYDATA
+	btreeOverflowSize       = 12 // BOVERFLOW
+	btreeInternalHeaderSize = 12 // BINTERNAL
+	recnoInternalSize       = 8  // RINTERNAL
+
+	btreeMetadataRootOffset = 88
+)
+
+// source: https://github.com/berkeleydb/libdb/blob/v5.3.28/src/dbinc/db_page.h
+type BtreeMetadataPage struct {
+	GenericMetadataPage
+	Root    uint32 /* 88-91: Root page. */
+	Swapped bool
+}
+
+func ParseBtreeMetadataPage(data []byte) (*BtreeMetadataPage, error) {
+	var pageMetadata BtreeMetadataPage
+
+	if len(data) < btreeMetadataRootOffset+4 {
+		return nil, xerrors.Errorf("short BtreeMetadataPage: %d", len(data))
+	}
+
+	err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &pageMetadata.GenericMetadataPage)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to unpack BtreeMetadataPage: %w", err)
+	}
+
+	if pageMetadata.Magic == BtreeMagicNumberBE {
+		pageMetadata.Swapped = true
+		err := binary.Read(bytes.NewReader(data), binary.BigEndian, &pageMetadata.GenericMetadataPage)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to unpack BtreeMetadataPage: %w", err)
+		}
+	}
+	pageMetadata.Root = byteOrder(pageMetadata.Swapped).Uint32(data[btreeMetadataRootOffset:])
+
+	return &pageMetadata, pageMetadata.validate()
+}
+
+func (p *BtreeMetadataPage) validate() error {
+	err := p.GenericMetadataPage.validate()
+	if err != nil {
+		return err
+	}
+
+	if p.Magic != BtreeMagicNumber {
+		return xerrors.Errorf("unexpected DB magic number %+v: %w", p.Magic, dbi.ErrNotRPMDB)
+	}
+
+	if p.PageType != BtreeMetadataPageType {
+		return xerrors.Errorf("unexpected page type %+v: %w", p.PageType, dbi.ErrNotRPMDB)
+	}
+
+	return nil
+}
+
+// btreeItem is an item on a btree leaf page
+// ref. BKEYDATA and BOVERFLOW in https://github.com/berkeleydb/libdb/blob/v5.3.28/src/dbinc/db_page.h
+type btreeItem struct {
+	Type uint8
+	// Data is the content of B_KEYDATA items
+	Data []byte
+	// PageNo is the first overflow page of B_OVERFLOW items, or the root of the
+	// off-page duplicate tree of B_DUPLICATE items
+	PageNo uint32
+}
+
+// btreePageOffsets returns the item offsets of a btree page
+func btreePageOffsets(pageData []byte, page *HashPage, order binary.ByteOrder) ([]int, error) {
+	if PageHeaderSize+int(page.NumEntries)*HashIndexEntrySize > len(pageData) {
+		return nil, xerrors.Errorf("too many entries on page=%d: %d", page.PageNo, page.NumEntries)
+	}
+
+	offsets := make([]int, page.NumEntries)
+	for i := range offsets {
+		offsets[i] = int(order.Uint16(pageData[PageHeaderSize+i*HashIndexEntrySize:]))
+		if offsets[i] < PageHeaderSize || offsets[i] >= len(pageData) {
+			return nil, xerrors.Errorf("invalid item offset on page=%d: %d", page.PageNo, offsets[i])
+		}
+	}
+	return offsets, nil
+}
+
+// parseBtreeItem parses the leaf item at offset, reporting whether it's deleted
+func parseBtreeItem(pageData []byte, offset int, order binary.ByteOrder) (btreeItem, bool, error) {
+	if offset+btreeKeyDataHeaderSize > len(pageData) {
+		return btreeItem{}, false, xerrors.Errorf("item exceeds page: %d", offset)
+	}
+
+	itemType := pageData[offset+2]
+	deleted := itemType&btreeDeletedFlag != 0
+	item := btreeItem{Type: itemType &^ btreeDeletedFlag}
+
+	switch item.Type {
+	case BtreeKeyDataType:
+		length := int(order.Uint16(pageData[offset:]))
+		start := offset + btreeKeyDataHeaderSize
+		if start+length > len(pageData) {
+			return btreeItem{}, false, xerrors.Errorf("item exceeds page: %d+%d", offset, length)
+		}
+		item.Data = pageData[start : start+length]
+	case BtreeDuplicateType, BtreeOverflowType:
+		if offset+btreeOverflowSize > len(pageData) {
+			return btreeItem{}, false, xerrors.Errorf("item exceeds page: %d", offset)
+		}
+		item.PageNo = order.Uint32(pageData[offset+4:])
+	default:
+		return btreeItem{}, false, xerrors.Errorf("unsupported btree item type: %d", item.Type)
+	}
+	return item, deleted, nil
+}
+
+// parseInternalItem parses an item of an internal page, returning the child page and the
+// separator key, which is a B_KEYDATA or B_OVERFLOW item
+// ref. BINTERNAL and RINTERNAL in https://github.com/berkeleydb/libdb/blob/v5.3.28/src/dbinc/db_page.h
+func parseInternalItem(pageData []byte, pageType PageType, offset int, order binary.ByteOrder) (uint32, btreeItem, error) {
+	if pageType == RecnoInternalPageType {
+		if offset+recnoInternalSize > len(pageData) {
+			return 0, btreeItem{}, xerrors.Errorf("item exceeds page: %d", offset)
+		}
+		return order.Uint32(pageData[offset:]), btreeItem{}, nil
+	}
+
+	if offset+btreeInternalHeaderSize > len(pageData) {
+		return 0, btreeItem{}, xerrors.Errorf("item exceeds page: %d", offset)
+	}
+	length := int(order.Uint16(pageData[offset:]))
+	itemType := pageData[offset+2] &^ btreeDeletedFlag
+	pageNo := order.Uint32(pageData[offset+4:])
+	start := offset + btreeInternalHeaderSize
+	if start+length > len(pageData) {
+		return 0, btreeItem{}, xerrors.Errorf("item exceeds page: %d+%d", offset, length)
+	}
+
+	key := btreeItem{Type: itemType}
+	switch itemType {
+	case BtreeKeyDataType:
+		key.Data = pageData[start : start+length]
+	case BtreeOverflowType:
+		if length < btreeOverflowSize {
+			return 0, btreeItem{}, xerrors.Errorf("short overflow key: %d", length)
+		}
+		key.PageNo = order.Uint32(pageData[start+4:])
+	default:
+		return 0, btreeItem{}, xerrors.Errorf("unsupported btree item type: %d", itemType)
+	}
+	return pageNo, key, nil
+}
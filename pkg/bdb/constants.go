@@ -19,9 +19,13 @@ const (
 	HashPageType         PageType = 13 // Sorted hash page.
 
 	// https://github.com/berkeleydb/libdb/blob/v5.3.28/src/dbinc/db_page.h#L569-L573
+	HashKeyDataType      PageType = 1 // aka H_KEYDATA
+	HashDuplicateType    PageType = 2 // aka H_DUPLICATE
 	HashOffIndexPageType PageType = 3 // aka HOFFPAGE
+	HashOffDupType       PageType = 4 // aka H_OFFDUP
 
 	HashOffPageSize = 12 // (in bytes)
+	HashOffDupSize  = 8  // (in bytes)
 )
 
 type PageType = uint8
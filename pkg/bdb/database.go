@@ -0,0 +1,593 @@
+package bdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/bits"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"golang.org/x/xerrors"
+)
+
+// the maximum depth of btrees, to stop on cycles in corrupted databases
+const maxBtreeDepth = 64
+
+// Database is a read-only Berkeley DB hash or btree database with any kind of keys,
+// e.g. one of rpm's index files.
+type Database struct {
+	file       dbi.File
+	pageSize   uint32
+	lastPageNo uint32
+	swapped    bool
+	btree      bool
+	// root is the root page of btree databases
+	root uint32
+
+	// the buckets of hash databases, see hashGet
+	maxBucket, highMask, lowMask uint32
+	spares                       [hashSpares]uint32
+	// defaultHash is set when the keys are hashed with the default hash function of libdb
+	defaultHash bool
+}
+
+// OpenDatabase opens a hash or btree database
+func OpenDatabase(path string) (*Database, error) {
+	file, err := dbi.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataBuff, err := readMetadata(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	db, err := parseDatabaseMetadata(metadataBuff)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	db.file = file
+	return db, nil
+}
+
+func parseDatabaseMetadata(data []byte) (*Database, error) {
+	generic, err := ParseGenericMetadataPage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var db Database
+	switch generic.Magic {
+	case HashMagicNumber, HashMagicNumberBE:
+		meta, err := ParseHashMetadataPage(data)
+		if err != nil {
+			return nil, err
+		}
+		db.pageSize, db.lastPageNo, db.swapped = meta.PageSize, meta.LastPageNo, meta.Swapped
+		if len(data) < hashSparesOffset+4*hashSpares {
+			return nil, xerrors.Errorf("short HashMetadataPage: %d", len(data))
+		}
+		order := byteOrder(meta.Swapped)
+		db.maxBucket, db.highMask, db.lowMask = order.Uint32(data[72:]), order.Uint32(data[76:]), order.Uint32(data[80:])
+		for i := range db.spares {
+			db.spares[i] = order.Uint32(data[hashSparesOffset+4*i:])
+		}
+		// the terminating NUL of CHARKEY is hashed as well
+		db.defaultHash = order.Uint32(data[92:]) == hashKey([]byte(hashCharKey+"\x00"))
+	case BtreeMagicNumber, BtreeMagicNumberBE:
+		meta, err := ParseBtreeMetadataPage(data)
+		if err != nil {
+			return nil, err
+		}
+		db.pageSize, db.lastPageNo, db.swapped = meta.PageSize, meta.LastPageNo, meta.Swapped
+		db.btree, db.root = true, meta.Root
+	default:
+		return nil, xerrors.Errorf("unexpected DB magic number %+v: %w", generic.Magic, dbi.ErrNotRPMDB)
+	}
+
+	if _, ok := validPageSizes[db.pageSize]; !ok {
+		return nil, xerrors.Errorf("unexpected page size %+v: %w", db.pageSize, dbi.ErrNotRPMDB)
+	}
+	return &db, nil
+}
+
+// IsBtree reports whether the database is a btree rather than a hash database
+func (db *Database) IsBtree() bool {
+	return db.btree
+}
+
+// ByteOrder returns the byte order of the machine which created the database
+func (db *Database) ByteOrder() binary.ByteOrder {
+	return byteOrder(db.swapped)
+}
+
+// Close closes the database file
+func (db *Database) Close() error {
+	return db.file.Close()
+}
+
+// Cursor returns a cursor over all key/value pairs. Btree databases are iterated in key order,
+// hash databases in page order. Duplicate keys are returned once per value.
+func (db *Database) Cursor(ctx context.Context) *Cursor {
+	return &Cursor{ctx: ctx, db: db}
+}
+
+// Get returns all values of a key. Btree databases are searched from the root page and hash
+// databases in the bucket of the key. Keys are compared byte-wise like libdb does by default,
+// hash databases of applications with their own hash function are scanned.
+func (db *Database) Get(ctx context.Context, key []byte) ([][]byte, error) {
+	switch {
+	case db.btree:
+		return db.btreeGet(ctx, key)
+	case db.defaultHash:
+		return db.hashGet(ctx, key)
+	}
+
+	var values [][]byte
+	c := db.Cursor(ctx)
+	for c.Next() {
+		if bytes.Equal(c.Key(), key) {
+			values = append(values, c.Value())
+		}
+	}
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// btreeGet iterates over the pairs from the leaf page of the key until a greater key
+func (db *Database) btreeGet(ctx context.Context, key []byte) ([][]byte, error) {
+	pageNo, err := db.searchLeaf(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var values [][]byte
+	c := &Cursor{ctx: ctx, db: db, started: true, pageNo: pageNo}
+	for c.Next() {
+		switch cmp := bytes.Compare(c.Key(), key); {
+		case cmp == 0:
+			values = append(values, c.Value())
+		case cmp > 0:
+			return values, nil
+		}
+	}
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// searchLeaf descends from the root to the leaf page which holds key if it exists. The
+// separator key of a child is greater than the keys of the children before it, the first
+// one of a page is never compared.
+// ref. __bam_search() in https://github.com/berkeleydb/libdb/blob/v5.3.28/src/btree/bt_search.c
+func (db *Database) searchLeaf(ctx context.Context, key []byte) (uint32, error) {
+	order := db.ByteOrder()
+	pageNo := db.root
+	for depth := 0; depth < maxBtreeDepth; depth++ {
+		pageData, page, err := db.readPage(pageNo)
+		if err != nil {
+			return 0, err
+		}
+
+		switch page.PageType {
+		case BtreeLeafPageType:
+			return pageNo, nil
+		case BtreeInternalPageType:
+		default:
+			return 0, xerrors.Errorf("unexpected page type on btree page=%d: %d", pageNo, page.PageType)
+		}
+
+		offsets, err := btreePageOffsets(pageData, page, order)
+		if err != nil {
+			return 0, err
+		}
+		if len(offsets) == 0 {
+			return 0, xerrors.Errorf("empty internal page=%d", pageNo)
+		}
+
+		// binary search for the first separator greater than key
+		lo, hi := 1, len(offsets)
+		for lo < hi {
+			mid := (lo + hi) / 2
+			_, separator, err := parseInternalItem(pageData, page.PageType, offsets[mid], order)
+			if err != nil {
+				return 0, xerrors.Errorf("invalid internal page=%d: %w", pageNo, err)
+			}
+			separatorKey, err := db.btreeItemValue(ctx, separator)
+			if err != nil {
+				return 0, xerrors.Errorf("failed to read key on page=%d: %w", pageNo, err)
+			}
+			if bytes.Compare(separatorKey, key) > 0 {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		if pageNo, _, err = parseInternalItem(pageData, page.PageType, offsets[lo-1], order); err != nil {
+			return 0, xerrors.Errorf("invalid internal page=%d: %w", pageNo, err)
+		}
+	}
+	return 0, xerrors.Errorf("btree exceeds depth %d", maxBtreeDepth)
+}
+
+// hashGet returns the values of key from the pages of its bucket
+// ref. __ham_call_hash() in https://github.com/berkeleydb/libdb/blob/v5.3.28/src/hash/hash.c
+func (db *Database) hashGet(ctx context.Context, key []byte) ([][]byte, error) {
+	bucket := hashKey(key) & db.highMask
+	if bucket > db.maxBucket {
+		bucket &= db.lowMask
+	}
+	// BUCKET_TO_PAGE, the spares hold the page offsets of the buckets doubling the table
+	pageNo := bucket + db.spares[bits.Len32(bucket)]
+
+	var values [][]byte
+	for visited := uint32(0); pageNo != 0; visited++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if visited > db.lastPageNo {
+			return nil, xerrors.Errorf("cycle in bucket %d at page=%d", bucket, pageNo)
+		}
+		pageData, page, err := db.readPage(pageNo)
+		if err != nil {
+			return nil, err
+		}
+		if page.PageType != HashUnsortedPageType && page.PageType != HashPageType {
+			return nil, corruptPage(pageNo, xerrors.Errorf("unexpected page type in bucket %d: %d", bucket, page.PageType))
+		}
+
+		pairs, err := hashPageItems(pageData, pageNo, page.NumEntries, db.ByteOrder())
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs {
+			k, err := itemValue(ctx, db.file, pair[0], db.pageSize, db.swapped)
+			if err != nil {
+				return nil, xerrors.Errorf("failed to read key on page=%d: %w", pageNo, err)
+			}
+			if !bytes.Equal(k, key) {
+				continue
+			}
+			v, err := db.hashItemValues(ctx, pair[1])
+			if err != nil {
+				return nil, xerrors.Errorf("failed to read value on page=%d: %w", pageNo, err)
+			}
+			values = append(values, v...)
+		}
+		pageNo = page.NextPageNo
+	}
+	return values, nil
+}
+
+// hashKey is the default hash function of libdb, a 32 bit FNV-1 with a zero offset basis
+// ref. __ham_func5() in https://github.com/berkeleydb/libdb/blob/v5.3.28/src/hash/hash_func.c
+func hashKey(key []byte) uint32 {
+	var h uint32
+	for _, b := range key {
+		h *= 16777619
+		h ^= uint32(b)
+	}
+	return h
+}
+
+func (db *Database) readPage(pageNo uint32) ([]byte, *HashPage, error) {
+	if pageNo > db.lastPageNo {
+		return nil, nil, corruptPage(pageNo, xerrors.Errorf("exceeds last page=%d", db.lastPageNo))
+	}
+	pageData := make([]byte, db.pageSize)
+	if err := slice(db.file, int64(pageNo)*int64(db.pageSize), pageData); err != nil {
+		return nil, nil, xerrors.Errorf("failed to read page=%d: %w", pageNo, err)
+	}
+	page, err := ParseHashPage(pageData, db.swapped)
+	if err != nil {
+		return nil, nil, corruptPage(pageNo, xerrors.Errorf("failed to parse page: %w", err))
+	}
+	return pageData, page, nil
+}
+
+// leftmostLeaf descends from pageNo to the first leaf page of a btree
+func (db *Database) leftmostLeaf(pageNo uint32) (uint32, error) {
+	order := db.ByteOrder()
+	for depth := 0; depth < maxBtreeDepth; depth++ {
+		pageData, page, err := db.readPage(pageNo)
+		if err != nil {
+			return 0, err
+		}
+
+		switch page.PageType {
+		case BtreeLeafPageType, BtreeDuplicateLeafPageType:
+			return pageNo, nil
+		case BtreeInternalPageType, RecnoInternalPageType:
+			offsets, err := btreePageOffsets(pageData, page, order)
+			if err != nil {
+				return 0, err
+			}
+			if len(offsets) == 0 {
+				return 0, xerrors.Errorf("empty internal page=%d", pageNo)
+			}
+			if pageNo, _, err = parseInternalItem(pageData, page.PageType, offsets[0], order); err != nil {
+				return 0, xerrors.Errorf("invalid internal page=%d: %w", page.PageNo, err)
+			}
+		default:
+			return 0, xerrors.Errorf("unexpected page type on btree page=%d: %d", pageNo, page.PageType)
+		}
+	}
+	return 0, xerrors.Errorf("btree exceeds depth %d", maxBtreeDepth)
+}
+
+// btreeItemValue returns the content of a B_KEYDATA or B_OVERFLOW item
+func (db *Database) btreeItemValue(ctx context.Context, item btreeItem) ([]byte, error) {
+	switch item.Type {
+	case BtreeKeyDataType:
+		return append([]byte{}, item.Data...), nil
+	case BtreeOverflowType:
+		return readOverflowPages(ctx, db.file, item.PageNo, db.pageSize, db.swapped)
+	}
+	return nil, xerrors.Errorf("unsupported btree item type: %d", item.Type)
+}
+
+// hashItemValues returns the values of a data item of a hash page. Duplicate items hold
+// more than one value.
+// ref. https://github.com/berkeleydb/libdb/blob/v5.3.28/src/dbinc/db_page.h
+func (db *Database) hashItemValues(ctx context.Context, item []byte) ([][]byte, error) {
+	if len(item) == 0 {
+		return nil, xerrors.New("empty hash item")
+	}
+
+	switch item[0] {
+	case HashKeyDataType, HashOffIndexPageType:
+		value, err := itemValue(ctx, db.file, item, db.pageSize, db.swapped)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{append([]byte{}, value...)}, nil
+	case HashDuplicateType:
+		// each duplicate is framed by its length: len, data, len
+		order := db.ByteOrder()
+		var values [][]byte
+		for p := item[1:]; len(p) > 0; {
+			if len(p) < 2*HashIndexEntrySize {
+				return nil, xerrors.Errorf("short duplicate: %d", len(p))
+			}
+			length := int(order.Uint16(p))
+			if len(p) < length+2*HashIndexEntrySize || int(order.Uint16(p[HashIndexEntrySize+length:])) != length {
+				return nil, xerrors.Errorf("invalid duplicate length: %d", length)
+			}
+			values = append(values, append([]byte{}, p[HashIndexEntrySize:HashIndexEntrySize+length]...))
+			p = p[length+2*HashIndexEntrySize:]
+		}
+		return values, nil
+	case HashOffDupType:
+		if len(item) < HashOffDupSize {
+			return nil, xerrors.Errorf("short H_OFFDUP item: %d", len(item))
+		}
+		return db.duplicates(ctx, db.ByteOrder().Uint32(item[4:]))
+	}
+	return nil, xerrors.Errorf("unsupported item type: %d", item[0])
+}
+
+// duplicates returns the values of an off-page duplicate tree
+func (db *Database) duplicates(ctx context.Context, root uint32) ([][]byte, error) {
+	pageNo, err := db.leftmostLeaf(root)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid duplicate tree %d: %w", root, err)
+	}
+
+	order := db.ByteOrder()
+	var values [][]byte
+	for visited := uint32(0); pageNo != 0; visited++ {
+		if visited > db.lastPageNo {
+			return nil, xerrors.Errorf("cycle in duplicate tree %d", root)
+		}
+		pageData, page, err := db.readPage(pageNo)
+		if err != nil {
+			return nil, err
+		}
+		if page.PageType != BtreeDuplicateLeafPageType {
+			return nil, xerrors.Errorf("unexpected page type on duplicate page=%d: %d", pageNo, page.PageType)
+		}
+		offsets, err := btreePageOffsets(pageData, page, order)
+		if err != nil {
+			return nil, err
+		}
+		for _, offset := range offsets {
+			item, deleted, err := parseBtreeItem(pageData, offset, order)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid duplicate page=%d: %w", pageNo, err)
+			}
+			if deleted {
+				continue
+			}
+			value, err := db.btreeItemValue(ctx, item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		pageNo = page.NextPageNo
+	}
+	return values, nil
+}
+
+type keyValue struct {
+	key, value []byte
+}
+
+// Cursor iterates over the key/value pairs of a Database a page at a time
+type Cursor struct {
+	ctx context.Context
+	db  *Database
+
+	started bool
+	done    bool
+	pageNo  uint32
+	visited uint32
+
+	pending []keyValue
+	current keyValue
+	err     error
+}
+
+// Next advances to the next pair, returning false at the end or on errors
+func (c *Cursor) Next() bool {
+	for len(c.pending) == 0 {
+		if c.done || c.err != nil {
+			return false
+		}
+		if err := c.ctx.Err(); err != nil {
+			c.err = err
+			return false
+		}
+
+		var err error
+		if c.db.btree {
+			c.pending, err = c.nextBtreePage()
+		} else {
+			c.pending, err = c.nextHashPage()
+		}
+		if err != nil {
+			c.err = err
+			return false
+		}
+	}
+
+	c.current, c.pending = c.pending[0], c.pending[1:]
+	return true
+}
+
+// Key returns the key of the current pair
+func (c *Cursor) Key() []byte {
+	return c.current.key
+}
+
+// Value returns the value of the current pair
+func (c *Cursor) Value() []byte {
+	return c.current.value
+}
+
+// Err returns the error which stopped the iteration
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+func (c *Cursor) nextHashPage() ([]keyValue, error) {
+	if !c.started {
+		c.started, c.pageNo = true, 1
+	}
+	if c.pageNo > c.db.lastPageNo {
+		c.done = true
+		return nil, nil
+	}
+
+	pageNo := c.pageNo
+	c.pageNo++
+	pageData, page, err := c.db.readPage(pageNo)
+	if err != nil {
+		return nil, err
+	}
+	if page.PageType != HashUnsortedPageType && page.PageType != HashPageType {
+		return nil, nil
+	}
+
+	pairs, err := hashPageItems(pageData, pageNo, page.NumEntries, c.db.ByteOrder())
+	if err != nil {
+		return nil, err
+	}
+	var kvs []keyValue
+	for _, pair := range pairs {
+		key, err := itemValue(c.ctx, c.db.file, pair[0], c.db.pageSize, c.db.swapped)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read key on page=%d: %w", pageNo, err)
+		}
+		values, err := c.db.hashItemValues(c.ctx, pair[1])
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read value on page=%d: %w", pageNo, err)
+		}
+		key = append([]byte{}, key...)
+		for _, value := range values {
+			kvs = append(kvs, keyValue{key: key, value: value})
+		}
+	}
+	return kvs, nil
+}
+
+func (c *Cursor) nextBtreePage() ([]keyValue, error) {
+	if !c.started {
+		c.started = true
+		pageNo, err := c.db.leftmostLeaf(c.db.root)
+		if err != nil {
+			return nil, err
+		}
+		c.pageNo = pageNo
+	}
+	if c.pageNo == 0 {
+		c.done = true
+		return nil, nil
+	}
+	if c.visited > c.db.lastPageNo {
+		return nil, xerrors.Errorf("cycle in btree leaf pages at page=%d", c.pageNo)
+	}
+	c.visited++
+
+	pageNo := c.pageNo
+	pageData, page, err := c.db.readPage(pageNo)
+	if err != nil {
+		return nil, err
+	}
+	if page.PageType != BtreeLeafPageType {
+		return nil, xerrors.Errorf("unexpected page type on leaf page=%d: %d", pageNo, page.PageType)
+	}
+	c.pageNo = page.NextPageNo
+
+	order := c.db.ByteOrder()
+	offsets, err := btreePageOffsets(pageData, page, order)
+	if err != nil {
+		return nil, err
+	}
+	if len(offsets)%2 != 0 {
+		return nil, xerrors.Errorf("invalid leaf page=%d: entries should only come in pairs (%+v)", pageNo, len(offsets))
+	}
+
+	// on-page duplicates share the offset of their key
+	var kvs []keyValue
+	for i := 0; i < len(offsets); i += 2 {
+		keyItem, keyDeleted, err := parseBtreeItem(pageData, offsets[i], order)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid key on page=%d: %w", pageNo, err)
+		}
+		dataItem, dataDeleted, err := parseBtreeItem(pageData, offsets[i+1], order)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid data on page=%d: %w", pageNo, err)
+		}
+		if keyDeleted || dataDeleted {
+			continue
+		}
+
+		key, err := c.db.btreeItemValue(c.ctx, keyItem)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read key on page=%d: %w", pageNo, err)
+		}
+		if dataItem.Type == BtreeDuplicateType {
+			values, err := c.db.duplicates(c.ctx, dataItem.PageNo)
+			if err != nil {
+				return nil, err
+			}
+			for _, value := range values {
+				kvs = append(kvs, keyValue{key: key, value: value})
+			}
+			continue
+		}
+		value, err := c.db.btreeItemValue(c.ctx, dataItem)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read value on page=%d: %w", pageNo, err)
+		}
+		kvs = append(kvs, keyValue{key: key, value: value})
+	}
+	return kvs, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
 	"golang.org/x/xerrors"
 )
 
@@ -39,8 +40,15 @@ func ParseGenericMetadataPage(data []byte) (*GenericMetadataPage, error) {
 }
 
 func (p *GenericMetadataPage) validate() error {
+	switch p.Magic {
+	case HashMagicNumber, HashMagicNumberBE, BtreeMagicNumber, BtreeMagicNumberBE:
+	default:
+		// the encryption algorithm means nothing in other files
+		return xerrors.Errorf("unexpected DB magic number %+v: %w", p.Magic, dbi.ErrNotRPMDB)
+	}
+
 	if p.EncryptionAlg != NoEncryptionAlgorithm {
-		return xerrors.Errorf("unexpected encryption algorithm: %+v", p.EncryptionAlg)
+		return xerrors.Errorf("unexpected encryption algorithm %+v: %w", p.EncryptionAlg, dbi.ErrEncrypted)
 	}
 
 	return nil
@@ -4,9 +4,19 @@ import (
 	"bytes"
 	"encoding/binary"
 
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
 	"golang.org/x/xerrors"
 )
 
+const (
+	// 96-223: the spares of the buckets following CharKeyHash
+	hashSparesOffset = 96
+	hashSpares       = 32
+	// CharKeyHash is the hash of this key, to detect the hash function of a database
+	// ref. CHARKEY in https://github.com/berkeleydb/libdb/blob/v5.3.28/src/dbinc/hash.h
+	hashCharKey = "%$sniglet^&"
+)
+
 // source: https://github.com/berkeleydb/libdb/blob/5b7b02ae052442626af54c176335b67ecc613a30/src/dbinc/db_page.h#L130
 type HashMetadata struct {
 	GenericMetadataPage
@@ -55,11 +65,11 @@ func (p *HashMetadata) validate() error {
 	}
 
 	if p.Magic != HashMagicNumber {
-		return xerrors.Errorf("unexpected DB magic number: %+v", p.Magic)
+		return xerrors.Errorf("unexpected DB magic number %+v: %w", p.Magic, dbi.ErrNotRPMDB)
 	}
 
 	if p.PageType != HashMetadataPageType {
-		return xerrors.Errorf("unexpected page type: %+v", p.PageType)
+		return xerrors.Errorf("unexpected page type %+v: %w", p.PageType, dbi.ErrNotRPMDB)
 	}
 
 	return nil
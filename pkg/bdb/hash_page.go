@@ -5,7 +5,6 @@ import (
 	"context"
 	"encoding/binary"
 	"io"
-	"os"
 
 	"golang.org/x/xerrors"
 )
@@ -32,63 +31,19 @@ func ParseHashPage(data []byte, swapped bool) (*HashPage, error) {
 	return &hashPage, nil
 }
 
-func HashPageValueContent(ctx context.Context, db *os.File, pageData []byte, hashPageIndex uint16, pageSize uint32, swapped bool) ([]byte, error) {
-	// the first byte is the page type, so we can peek at it first before parsing further...
-	valuePageType := pageData[hashPageIndex]
-
-	// only HOFFPAGE page types have data of interest
-	if valuePageType != HashOffIndexPageType {
-		return nil, xerrors.Errorf("only HOFFPAGE types supported (%+v)", valuePageType)
-	}
-
-	hashOffPageEntryBuff := pageData[hashPageIndex : hashPageIndex+HashOffPageSize]
-
-	entry, err := ParseHashOffPageEntry(hashOffPageEntryBuff, swapped)
-	if err != nil {
-		return nil, err
+// HashPageValueContent returns the content of the HOFFPAGE item at hashPageIndex of a hash page,
+// which is stored on a chain of overflow pages
+func HashPageValueContent(ctx context.Context, db io.ReaderAt, pageData []byte, hashPageIndex uint16, pageSize uint32, swapped bool) ([]byte, error) {
+	if int(hashPageIndex)+HashOffPageSize > len(pageData) {
+		return nil, xerrors.Errorf("HOFFPAGE item out of page: %d", hashPageIndex)
 	}
+	item := pageData[hashPageIndex : int(hashPageIndex)+HashOffPageSize]
 
-	var hashValue []byte
-
-	for currentPageNo := entry.PageNo; currentPageNo != 0; {
-		select {
-		case <-ctx.Done():
-			return nil, xerrors.Errorf("timeout for parse page")
-		default:
-			pageStart := pageSize * currentPageNo
-
-			_, err := db.Seek(int64(pageStart), io.SeekStart)
-			if err != nil {
-				return nil, xerrors.Errorf("failed to seek to HashPageValueContent (page=%d): %w", currentPageNo, err)
-			}
-
-			currentPageBuff, err := slice(db, int(pageSize))
-			if err != nil {
-				return nil, xerrors.Errorf("failed to read page=%d: %w", currentPageNo, err)
-			}
-
-			currentPage, err := ParseHashPage(currentPageBuff, swapped)
-			if err != nil {
-				return nil, xerrors.Errorf("failed to parse page=%d: %w", currentPageNo, err)
-			}
-			if currentPage.PageType != OverflowPageType {
-				currentPageNo = currentPage.NextPageNo
-				continue
-			}
-			var hashValueBytes []byte
-			if currentPage.NextPageNo == 0 {
-				// this is the last page, the whole page contains content
-				hashValueBytes = currentPageBuff[PageHeaderSize : PageHeaderSize+currentPage.FreeAreaOffset]
-			} else {
-				hashValueBytes = currentPageBuff[PageHeaderSize:]
-			}
-			hashValue = append(hashValue, hashValueBytes...)
-
-			currentPageNo = currentPage.NextPageNo
-		}
+	// only HOFFPAGE page types have data of interest
+	if item[0] != HashOffIndexPageType {
+		return nil, xerrors.Errorf("only HOFFPAGE types supported (%+v)", item[0])
 	}
-
-	return hashValue, nil
+	return itemValue(ctx, db, item, pageSize, swapped)
 }
 
 func HashPageValueIndexes(data []byte, entries uint16, swapped bool) ([]uint16, error) {
@@ -115,14 +70,14 @@ func HashPageValueIndexes(data []byte, entries uint16, swapped bool) ([]uint16,
 	return hashIndexValues, nil
 }
 
-func slice(reader io.Reader, n int) ([]byte, error) {
-	newBuff := make([]byte, n)
-	numRead, err := reader.Read(newBuff)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to read page: %w", err)
+// slice reads a whole page at offset into buff
+func slice(reader io.ReaderAt, offset int64, buff []byte) error {
+	numRead, err := reader.ReadAt(buff, offset)
+	if numRead == len(buff) {
+		return nil
 	}
-	if numRead != n {
-		return nil, xerrors.Errorf("short page size: %d!=%d", n, numRead)
+	if err != nil {
+		return xerrors.Errorf("failed to read page: %w", err)
 	}
-	return newBuff, nil
+	return xerrors.Errorf("short page size: %d!=%d", len(buff), numRead)
 }
@@ -0,0 +1,267 @@
+package bdb
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"golang.org/x/xerrors"
+)
+
+/*
+   rpm keeps every secondary index in its own Berkeley DB file next to Packages,
+   named after the index (Name, Providename, Basenames, ...). Older releases created
+   hash databases, newer ones btree databases with duplicates. The key is the tag
+   value and the data is an array of dbiIndexItem in the native byte order of the
+   database:
+
+     uint32 hdrNum  header instance, i.e. the key of the header in Packages
+     uint32 tagNum  index of the entry within the tag
+
+   ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/backend/dbi.h
+*/
+
+const indexItemSize = 8
+
+// hashItemHandler receives the raw key and data items of a hash page, including the type byte
+type hashItemHandler func(key, data []byte) error
+
+// walkHashItems calls fn for every key/data pair on the hash pages of a database
+func walkHashItems(ctx context.Context, r io.ReaderAt, meta *HashMetadataPage, fn hashItemHandler) error {
+	pageSize := meta.PageSize
+	order := byteOrder(meta.Swapped)
+	pageData := make([]byte, pageSize)
+
+	for pageNum := uint32(0); pageNum <= meta.LastPageNo; pageNum++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if _, err := r.ReadAt(pageData, int64(pageNum)*int64(pageSize)); err != nil {
+			return xerrors.Errorf("failed to read page=%d: %w", pageNum, err)
+		}
+		page, err := ParseHashPage(pageData, meta.Swapped)
+		if err != nil {
+			return xerrors.Errorf("failed to parse page=%d: %w", pageNum, err)
+		}
+		if page.PageType != HashUnsortedPageType && page.PageType != HashPageType {
+			continue
+		}
+		pairs, err := hashPageItems(pageData, pageNum, page.NumEntries, order)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			if err = fn(pair[0], pair[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hashPageItems returns the raw key and data items of a hash page
+func hashPageItems(pageData []byte, pageNum uint32, entries uint16, order binary.ByteOrder) ([][2][]byte, error) {
+	if entries%2 != 0 {
+		return nil, xerrors.Errorf("invalid hash index on page=%d: entries should only come in pairs (%+v)", pageNum, entries)
+	}
+	if PageHeaderSize+int(entries)*HashIndexEntrySize > len(pageData) {
+		return nil, xerrors.Errorf("too many entries on page=%d: %d", pageNum, entries)
+	}
+
+	// items are stored from the end of the page, so the length of an item is the
+	// distance to the offset of the previous one
+	// ref. LEN_HITEM in https://github.com/berkeleydb/libdb/blob/v5.3.28/src/dbinc/hash.h
+	item := func(i int) ([]byte, error) {
+		offset := int(order.Uint16(pageData[PageHeaderSize+i*HashIndexEntrySize:]))
+		end := len(pageData)
+		if i > 0 {
+			end = int(order.Uint16(pageData[PageHeaderSize+(i-1)*HashIndexEntrySize:]))
+		}
+		if offset >= end || end > len(pageData) {
+			return nil, xerrors.Errorf("invalid item offset on page=%d: %d", pageNum, offset)
+		}
+		return pageData[offset:end], nil
+	}
+
+	pairs := make([][2][]byte, 0, entries/2)
+	for i := 0; i < int(entries); i += 2 {
+		key, err := item(i)
+		if err != nil {
+			return nil, err
+		}
+		data, err := item(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, [2][]byte{key, data})
+	}
+	return pairs, nil
+}
+
+// itemValue returns the content of an H_KEYDATA or HOFFPAGE item, use hashItemValues for data items
+func itemValue(ctx context.Context, r io.ReaderAt, item []byte, pageSize uint32, swapped bool) ([]byte, error) {
+	switch item[0] {
+	case HashKeyDataType:
+		return item[1:], nil
+	case HashOffIndexPageType:
+		if len(item) < HashOffPageSize {
+			return nil, xerrors.Errorf("short HOFFPAGE item: %d", len(item))
+		}
+		entry, err := ParseHashOffPageEntry(item[:HashOffPageSize], swapped)
+		if err != nil {
+			return nil, err
+		}
+		return readOverflowPages(ctx, r, entry.PageNo, pageSize, swapped)
+	}
+	return nil, xerrors.Errorf("unsupported item type: %d", item[0])
+}
+
+// readOverflowPages concatenates the content of a chain of overflow pages
+func readOverflowPages(ctx context.Context, r io.ReaderAt, pageNo uint32, pageSize uint32, swapped bool) ([]byte, error) {
+	var value []byte
+	pageData := make([]byte, pageSize)
+
+	for pageNo != 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		if _, err := r.ReadAt(pageData, int64(pageNo)*int64(pageSize)); err != nil {
+			return nil, xerrors.Errorf("failed to read page=%d: %w", pageNo, err)
+		}
+		page, err := ParseHashPage(pageData, swapped)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to parse page=%d: %w", pageNo, err)
+		}
+		if page.PageType != OverflowPageType {
+			return nil, xerrors.Errorf("unexpected page type on overflow page=%d: %d", pageNo, page.PageType)
+		}
+
+		if page.NextPageNo == 0 {
+			// this is the last page, the high free byte offset holds the length of the content
+			end := PageHeaderSize + int(page.FreeAreaOffset)
+			if end > len(pageData) {
+				return nil, xerrors.Errorf("invalid overflow length on page=%d: %d", pageNo, page.FreeAreaOffset)
+			}
+			value = append(value, pageData[PageHeaderSize:end]...)
+		} else {
+			value = append(value, pageData[PageHeaderSize:]...)
+		}
+		pageNo = page.NextPageNo
+	}
+	return value, nil
+}
+
+// headerPages maps header instance numbers to their items in Packages
+type headerPages struct {
+	mu    sync.Mutex
+	items map[uint32][]byte
+}
+
+func (h *headerPages) load(ctx context.Context, db *BerkeleyDB) (map[uint32][]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.items != nil {
+		return h.items, nil
+	}
+
+	meta := db.HashMetadata
+	items := make(map[uint32][]byte)
+	err := walkHashItems(ctx, db.file, meta, func(keyItem, dataItem []byte) error {
+		// hnum 0 records the next free instance number
+		hnum := db.headerNum(keyItem)
+		if hnum == 0 {
+			return nil
+		}
+		items[hnum] = append([]byte{}, dataItem...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.items = items
+	return items, nil
+}
+
+// ReadIndex looks up a key in the index database of the same name next to Packages
+func (db *BerkeleyDB) ReadIndex(ctx context.Context, index dbi.Index, key []byte) ([]dbi.IndexItem, error) {
+	if index.Tag() == 0 {
+		return nil, xerrors.Errorf("unknown index %q: %w", index, dbi.ErrIndexNotFound)
+	}
+
+	indexPath := filepath.Join(filepath.Dir(db.file.Name()), string(index))
+	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
+		return nil, xerrors.Errorf("no %s database: %w", index, dbi.ErrIndexNotFound)
+	}
+
+	indexDB, err := OpenDatabase(indexPath)
+	if err != nil {
+		return nil, xerrors.Errorf("unsupported %s database (%s): %w", index, err, dbi.ErrIndexNotFound)
+	}
+	defer indexDB.Close()
+
+	values, err := indexDB.Get(ctx, key)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %s database: %w", index, err)
+	}
+
+	order := indexDB.ByteOrder()
+	var items []dbi.IndexItem
+	for _, data := range values {
+		if len(data)%indexItemSize != 0 {
+			return nil, xerrors.Errorf("invalid %s data length: %d", index, len(data))
+		}
+		for i := 0; i < len(data); i += indexItemSize {
+			items = append(items, dbi.IndexItem{
+				HeaderNum: order.Uint32(data[i:]),
+				TagNum:    order.Uint32(data[i+4:]),
+			})
+		}
+	}
+	return items, nil
+}
+
+// ReadHeader returns the header blob stored under hnum in Packages
+func (db *BerkeleyDB) ReadHeader(ctx context.Context, hnum uint32) ([]byte, error) {
+	meta := db.HashMetadata
+
+	// the keys of Packages are read once, later lookups only follow the data item
+	items, err := db.headers.load(ctx, db)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read Packages keys: %w", err)
+	}
+
+	item, ok := items[hnum]
+	if !ok {
+		return nil, xerrors.Errorf("no package with hnum %d: %w", hnum, dbi.ErrHeaderNotFound)
+	}
+	return itemValue(ctx, db.file, item, meta.PageSize, meta.Swapped)
+}
+
+// Version returns a hash of the metadata page. Berkeley DB updates its LSN, last page,
+// free list and key count with the modifications of the database.
+func (db *BerkeleyDB) Version() (uint64, error) {
+	metadataBuff := make([]byte, 512)
+	if _, err := db.file.ReadAt(metadataBuff, 0); err != nil {
+		return 0, xerrors.Errorf("failed to read metadata: %w", err)
+	}
+	h := fnv.New64a()
+	h.Write(metadataBuff)
+	return h.Sum64(), nil
+}
+
+// Close closes the Packages database file
+func (db *BerkeleyDB) Close() error {
+	return db.file.Close()
+}
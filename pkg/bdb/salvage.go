@@ -0,0 +1,138 @@
+package bdb
+
+import (
+	"context"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+)
+
+// Salvage reads the headers like Read, but skips the pages and items which can't be read.
+// Afterwards every page is checked for chains of overflow pages which no hash item refers
+// to anymore, and their content is returned with an unknown header number, like db_dump -r.
+func (db *BerkeleyDB) Salvage(ctx context.Context) <-chan dbi.Entry {
+	entries := make(chan dbi.Entry)
+
+	go func() {
+		defer close(entries)
+
+		d := db.database()
+		order := d.ByteOrder()
+		pageSize := int64(d.pageSize)
+		diagnose := func(pageNo uint32, slot int, hnum uint32, offset int64, err error) {
+			entries <- dbi.Entry{
+				HeaderNum: hnum,
+				Err: &dbi.Diagnostic{
+					Backend:   "bdb",
+					PageNo:    pageNo,
+					Slot:      slot,
+					HeaderNum: hnum,
+					Offset:    offset,
+					Err:       err,
+				},
+			}
+		}
+
+		// the first pages of the overflow chains, and whether an item refers to them
+		chains := make(map[uint32]bool)
+		var chainOrder []uint32
+		refer := func(pageNo uint32) {
+			if _, ok := chains[pageNo]; !ok {
+				chainOrder = append(chainOrder, pageNo)
+			}
+			chains[pageNo] = true
+		}
+
+		for pageNo := uint32(1); pageNo <= d.lastPageNo; pageNo++ {
+			if err := ctx.Err(); err != nil {
+				entries <- dbi.Entry{Err: err}
+				return
+			}
+
+			pageOffset := int64(pageNo) * pageSize
+			pageData, page, err := d.readPage(pageNo)
+			if err != nil {
+				diagnose(pageNo, -1, 0, pageOffset, err)
+				continue
+			}
+
+			switch page.PageType {
+			case OverflowPageType:
+				if page.PreviousPageNo == 0 {
+					if _, ok := chains[pageNo]; !ok {
+						chains[pageNo] = false
+						chainOrder = append(chainOrder, pageNo)
+					}
+				}
+				continue
+			case BtreeDuplicateLeafPageType:
+				// off-page duplicates may refer to overflow pages as well
+				offsets, err := btreePageOffsets(pageData, page, order)
+				if err != nil {
+					continue
+				}
+				for _, offset := range offsets {
+					if item, _, err := parseBtreeItem(pageData, offset, order); err == nil && item.Type == BtreeOverflowType {
+						refer(item.PageNo)
+					}
+				}
+				continue
+			case HashUnsortedPageType, HashPageType:
+			default:
+				continue
+			}
+
+			pairs, err := hashPageItems(pageData, pageNo, page.NumEntries, order)
+			if err != nil {
+				diagnose(pageNo, -1, 0, pageOffset, err)
+				continue
+			}
+
+			for i, pair := range pairs {
+				headerNum := db.headerNum(pair[0])
+				valueItem := pair[1]
+				if valueItem[0] == HashOffIndexPageType && len(valueItem) >= HashOffPageSize {
+					refer(order.Uint32(valueItem[4:]))
+				} else if headerNum == 0 {
+					// hnum 0 records the next free instance number, it's not a header
+					continue
+				}
+
+				slot := 2*i + 1
+				values, err := d.hashItemValues(ctx, valueItem)
+				if err != nil {
+					if ctx.Err() != nil {
+						entries <- dbi.Entry{Err: ctx.Err()}
+						return
+					}
+					offset := pageOffset + int64(order.Uint16(pageData[PageHeaderSize+slot*HashIndexEntrySize:]))
+					diagnose(pageNo, slot, headerNum, offset, err)
+					continue
+				}
+				for _, value := range values {
+					entries <- dbi.Entry{
+						Value:     value,
+						HeaderNum: headerNum,
+					}
+				}
+			}
+		}
+
+		for _, pageNo := range chainOrder {
+			if chains[pageNo] {
+				continue
+			}
+			value, err := readOverflowPages(ctx, d.file, pageNo, d.pageSize, d.swapped)
+			if err != nil {
+				if ctx.Err() != nil {
+					entries <- dbi.Entry{Err: ctx.Err()}
+					return
+				}
+				diagnose(pageNo, -1, 0, int64(pageNo)*pageSize, err)
+				continue
+			}
+			entries <- dbi.Entry{Value: value}
+		}
+	}()
+
+	return entries
+}
@@ -0,0 +1,506 @@
+package rpmdb
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/jfrog/go-rpmdb/pkg/bdb"
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+)
+
+type testBDBPair struct {
+	key, value []byte
+	// offPage stores the value on overflow pages like the headers in Packages
+	offPage bool
+	// duplicates are stored on the hash page instead of value, or on a page of
+	// their own with offDup
+	duplicates [][]byte
+	offDup     bool
+}
+
+// createBDBHash writes a Berkeley DB hash database with a single hash page
+func createBDBHash(t testing.TB, path string, pageSize int, pairs ...testBDBPair) {
+	t.Helper()
+
+	page := func(pgno, next uint32, entries, hfOffset uint16, pageType byte) []byte {
+		p := make([]byte, pageSize)
+		binary.LittleEndian.PutUint32(p[8:], pgno)
+		binary.LittleEndian.PutUint32(p[16:], next)
+		binary.LittleEndian.PutUint16(p[20:], entries)
+		binary.LittleEndian.PutUint16(p[22:], hfOffset)
+		p[25] = pageType
+		return p
+	}
+
+	var items [][]byte
+	var overflow [][]byte
+	for _, pair := range pairs {
+		items = append(items, append([]byte{1}, pair.key...))
+		if pair.offDup {
+			// 4 is H_OFFDUP, the duplicates are stored on a P_LDUP page
+			pgno := uint32(2 + len(overflow))
+			item := make([]byte, 8)
+			item[0] = 4
+			binary.LittleEndian.PutUint32(item[4:], pgno)
+			items = append(items, item)
+
+			p := page(pgno, 0, uint16(len(pair.duplicates)), 0, 12)
+			offset := pageSize
+			for i, dup := range pair.duplicates {
+				offset -= len(dup) + 3
+				copy(p[offset:], bKeyData(string(dup), false))
+				binary.LittleEndian.PutUint16(p[26+2*i:], uint16(offset))
+			}
+			overflow = append(overflow, p)
+			continue
+		}
+		if pair.duplicates != nil {
+			// 2 is H_DUPLICATE, every duplicate is framed by its length
+			item := []byte{2}
+			for _, dup := range pair.duplicates {
+				length := []byte{byte(len(dup)), byte(len(dup) >> 8)}
+				item = append(append(append(item, length...), dup...), length...)
+			}
+			items = append(items, item)
+			continue
+		}
+		if !pair.offPage {
+			items = append(items, append([]byte{1}, pair.value...))
+			continue
+		}
+
+		// 3 is HOFFPAGE, the value continues on the pages after the hash page
+		item := make([]byte, 12)
+		item[0] = 3
+		binary.LittleEndian.PutUint32(item[4:], uint32(2+len(overflow)))
+		binary.LittleEndian.PutUint32(item[8:], uint32(len(pair.value)))
+		items = append(items, item)
+
+		chunk := pageSize - 26
+		for off := 0; off < len(pair.value); off += chunk {
+			pgno := uint32(2 + len(overflow))
+			end, next := off+chunk, pgno+1
+			if end >= len(pair.value) {
+				end, next = len(pair.value), 0
+			}
+			p := page(pgno, next, 1, uint16(end-off), 7)
+			if off > 0 {
+				binary.LittleEndian.PutUint32(p[12:], pgno-1)
+			}
+			copy(p[26:], pair.value[off:end])
+			overflow = append(overflow, p)
+		}
+	}
+
+	hashPage := page(1, 0, uint16(len(items)), 0, 13)
+	offset := pageSize
+	for i, item := range items {
+		offset -= len(item)
+		require.Greater(t, offset, 26+2*len(items), "hash page overflow")
+		copy(hashPage[offset:], item)
+		binary.LittleEndian.PutUint16(hashPage[26+2*i:], uint16(offset))
+	}
+
+	meta := page(0, 0, 0, 0, 8)
+	binary.LittleEndian.PutUint32(meta[12:], 0x00061561)
+	binary.LittleEndian.PutUint32(meta[16:], 9)
+	binary.LittleEndian.PutUint32(meta[20:], uint32(pageSize))
+	binary.LittleEndian.PutUint32(meta[32:], uint32(1+len(overflow)))
+
+	data := append(meta, hashPage...)
+	for _, p := range overflow {
+		data = append(data, p...)
+	}
+	require.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+// testBDBPage is a btree page, numbered from 1
+type testBDBPage struct {
+	pageType byte
+	next     uint32
+	// items is nil for overflow pages, a nil item reuses the offset of the item two
+	// entries before like on-page duplicate keys do
+	items [][]byte
+	// data is the content of overflow pages
+	data []byte
+}
+
+func bKeyData(data string, deleted bool) []byte {
+	item := []byte{0, 0, 1}
+	if deleted {
+		item[2] |= 0x80
+	}
+	binary.LittleEndian.PutUint16(item, uint16(len(data)))
+	return append(item, data...)
+}
+
+// bOverflow is a B_OVERFLOW or B_DUPLICATE item
+func bOverflow(itemType byte, pgno uint32) []byte {
+	item := make([]byte, 12)
+	item[2] = itemType
+	binary.LittleEndian.PutUint32(item[4:], pgno)
+	return item
+}
+
+func bInternal(pgno uint32, key string) []byte {
+	item := make([]byte, 12)
+	binary.LittleEndian.PutUint16(item, uint16(len(key)))
+	item[2] = 1
+	binary.LittleEndian.PutUint32(item[4:], pgno)
+	return append(item, key...)
+}
+
+func rInternal(pgno uint32) []byte {
+	item := make([]byte, 8)
+	binary.LittleEndian.PutUint32(item, pgno)
+	return item
+}
+
+// createBDBBtree writes a Berkeley DB btree database
+func createBDBBtree(t *testing.T, path string, pageSize int, root uint32, pages ...testBDBPage) {
+	t.Helper()
+
+	meta := make([]byte, pageSize)
+	binary.LittleEndian.PutUint32(meta[12:], 0x00053162)
+	binary.LittleEndian.PutUint32(meta[16:], 9)
+	binary.LittleEndian.PutUint32(meta[20:], uint32(pageSize))
+	meta[25] = 9
+	binary.LittleEndian.PutUint32(meta[32:], uint32(len(pages)))
+	binary.LittleEndian.PutUint32(meta[88:], root)
+
+	data := meta
+	for i, p := range pages {
+		page := make([]byte, pageSize)
+		binary.LittleEndian.PutUint32(page[8:], uint32(i+1))
+		binary.LittleEndian.PutUint32(page[16:], p.next)
+		page[25] = p.pageType
+
+		if p.items == nil {
+			binary.LittleEndian.PutUint16(page[22:], uint16(len(p.data)))
+			copy(page[26:], p.data)
+		} else {
+			binary.LittleEndian.PutUint16(page[20:], uint16(len(p.items)))
+			offset := pageSize
+			offsets := make([]int, len(p.items))
+			for j, item := range p.items {
+				if item == nil {
+					offsets[j] = offsets[j-2]
+				} else {
+					offset -= len(item)
+					offsets[j] = offset
+					copy(page[offset:], item)
+				}
+				binary.LittleEndian.PutUint16(page[26+2*j:], uint16(offsets[j]))
+			}
+			require.Greater(t, offset, 26+2*len(p.items), "btree page overflow")
+		}
+		data = append(data, page...)
+	}
+	require.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+// testBtree is a two level btree with an overflow key, on-page and off-page duplicates
+func testBtree(t *testing.T, path string, values map[string][]string) {
+	longKey := strings.Repeat("k", 600)
+	createBDBBtree(t, path, 512, 1,
+		// 1: root
+		testBDBPage{pageType: 3, items: [][]byte{bInternal(2, ""), bInternal(3, "m")}},
+		// 2: first leaf
+		testBDBPage{pageType: 5, next: 3, items: [][]byte{
+			bKeyData("bash", false), bKeyData(values["bash"][0], false),
+			bKeyData("deleted", true), bKeyData("", true),
+			bKeyData("kernel", false), bOverflow(2, 4),
+		}},
+		// 3: second leaf
+		testBDBPage{pageType: 5, items: [][]byte{
+			bOverflow(3, 6), bKeyData(values[longKey][0], false),
+			bKeyData("zsh", false), bKeyData(values["zsh"][0], false),
+			nil, bKeyData(values["zsh"][1], false),
+		}},
+		// 4: root of the off-page duplicates
+		testBDBPage{pageType: 4, items: [][]byte{rInternal(5)}},
+		// 5: duplicates
+		testBDBPage{pageType: 12, items: [][]byte{bKeyData(values["kernel"][0], false), bKeyData(values["kernel"][1], false)}},
+		// 6, 7: overflow key
+		testBDBPage{pageType: 7, next: 7, data: []byte(longKey[:512-26])},
+		testBDBPage{pageType: 7, data: []byte(longKey[512-26:])},
+	)
+}
+
+func TestBerkeleyDBCursor(t *testing.T) {
+	longKey := strings.Repeat("k", 600)
+	values := map[string][]string{
+		"bash":   {"b"},
+		"kernel": {"k1", "k2"},
+		longKey:  {"long"},
+		"zsh":    {"z1", "z2"},
+	}
+
+	tests := []struct {
+		name   string
+		create func(t *testing.T, path string)
+		btree  bool
+		want   []string
+	}{
+		{
+			name:   "btree",
+			create: func(t *testing.T, path string) { testBtree(t, path, values) },
+			btree:  true,
+			want:   []string{"bash=b", "kernel=k1", "kernel=k2", longKey + "=long", "zsh=z1", "zsh=z2"},
+		},
+		{
+			name: "hash",
+			create: func(t *testing.T, path string) {
+				createBDBHash(t, path, 512,
+					testBDBPair{key: []byte("bash"), value: []byte("b")},
+					testBDBPair{key: []byte("kernel"), value: []byte(strings.Repeat("k", 1000)), offPage: true},
+				)
+			},
+			want: []string{"bash=b", "kernel=" + strings.Repeat("k", 1000)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "Name")
+			tt.create(t, path)
+
+			db, err := bdb.OpenDatabase(path)
+			require.NoError(t, err)
+			defer db.Close()
+			assert.Equal(t, tt.btree, db.IsBtree())
+
+			var got []string
+			c := db.Cursor(context.Background())
+			for c.Next() {
+				got = append(got, string(c.Key())+"="+string(c.Value()))
+			}
+			require.NoError(t, c.Err())
+			assert.Equal(t, tt.want, got)
+
+			values, err := db.Get(context.Background(), []byte("kernel"))
+			require.NoError(t, err)
+			assert.Len(t, values, strings.Count(strings.Join(tt.want, "\n"), "kernel="))
+		})
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "Name")
+		testBtree(t, path, values)
+
+		db, err := bdb.OpenDatabase(path)
+		require.NoError(t, err)
+		defer db.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		c := db.Cursor(ctx)
+		assert.False(t, c.Next())
+		assert.ErrorIs(t, c.Err(), context.Canceled)
+	})
+}
+
+// testdata/bdb was written by libdb 5.3: Packages is a hash database with the headers 1 to 3,
+// Name a btree of 512 bytes pages with three levels, Providename a hash database of 512 bytes
+// pages with 24 buckets. Both indexes have an overflow key and an overflow value.
+func TestBerkeleyDBLibdb(t *testing.T) {
+	item := func(hnum, tnum uint32) string {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint32(b, hnum)
+		binary.LittleEndian.PutUint32(b[4:], tnum)
+		return string(b)
+	}
+
+	tests := []struct {
+		file   string
+		btree  bool
+		values map[string]string
+	}{
+		{
+			file:  "testdata/bdb/Name",
+			btree: true,
+			values: map[string]string{
+				"kernel":                 item(1, 0) + item(3, 0),
+				"bash":                   item(2, 0),
+				strings.Repeat("k", 600): item(1, 0),
+				"zsh":                    strings.Repeat(item(9, 1), 300),
+				"pkg0123":                item(1123, 0),
+				"pkg0499":                item(1499, 0),
+			},
+		},
+		{
+			file: "testdata/bdb/Providename",
+			values: map[string]string{
+				"kernel":                 item(1, 0) + item(3, 0),
+				"bash":                   item(2, 0),
+				strings.Repeat("p", 600): item(1, 0),
+				"zsh":                    strings.Repeat(item(9, 1), 300),
+				"libfoo.so.123":          item(1123, 0),
+				"libfoo.so.499":          item(1499, 0),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.file), func(t *testing.T) {
+			db, err := bdb.OpenDatabase(tt.file)
+			require.NoError(t, err)
+			defer db.Close()
+			require.Equal(t, tt.btree, db.IsBtree())
+
+			var keys []string
+			c := db.Cursor(context.Background())
+			for c.Next() {
+				keys = append(keys, string(c.Key()))
+			}
+			require.NoError(t, c.Err())
+			require.Len(t, keys, 504)
+			if tt.btree {
+				assert.True(t, sort.StringsAreSorted(keys))
+			}
+
+			for key, want := range tt.values {
+				values, err := db.Get(context.Background(), []byte(key))
+				require.NoError(t, err)
+				assert.Equal(t, [][]byte{[]byte(want)}, values, "key of %d bytes", len(key))
+			}
+			for _, key := range []string{"", "a", "kernel-core", "pkg9999", "libfoo.so.500"} {
+				values, err := db.Get(context.Background(), []byte(key))
+				require.NoError(t, err)
+				assert.Empty(t, values, key)
+			}
+		})
+	}
+
+	rpmdb, err := Open("testdata/bdb/Packages")
+	require.NoError(t, err)
+	defer rpmdb.Close()
+
+	items, err := rpmdb.db.(dbi.IndexReader).ReadIndex(context.Background(), IndexName, []byte("kernel"))
+	require.NoError(t, err)
+	assert.Equal(t, []dbi.IndexItem{{HeaderNum: 1}, {HeaderNum: 3}}, items)
+
+	items, err = rpmdb.db.(dbi.IndexReader).ReadIndex(context.Background(), IndexProvidename, []byte("bash"))
+	require.NoError(t, err)
+	assert.Equal(t, []dbi.IndexItem{{HeaderNum: 2}}, items)
+
+	pkgs, err := rpmdb.PackagesByName(context.Background(), "kernel")
+	require.NoError(t, err)
+	require.Len(t, pkgs, 2)
+	assert.Equal(t, "5.14", pkgs[0].Version)
+	assert.Equal(t, "6.1", pkgs[1].Version)
+}
+
+func TestBerkeleyDBHashItems(t *testing.T) {
+	hnum := func(n uint32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, n)
+		return b
+	}
+	header := func(name string) []byte {
+		return testIndexedHeader(name, "1.0", 1000, name)
+	}
+
+	tests := []struct {
+		name  string
+		pairs []testBDBPair
+		want  map[uint32][]string
+		// the cursor also returns the record of hnum 0
+		wantRecords int
+	}{
+		{
+			name: "H_KEYDATA",
+			pairs: []testBDBPair{
+				{key: hnum(0), value: hnum(3)},
+				{key: hnum(1), value: header("inline")},
+				{key: hnum(2), value: header("offpage"), offPage: true},
+			},
+			want:        map[uint32][]string{1: {"inline"}, 2: {"offpage"}},
+			wantRecords: 3,
+		},
+		{
+			name: "H_DUPLICATE",
+			pairs: []testBDBPair{
+				{key: hnum(1), duplicates: [][]byte{header("dup1"), header("dup2")}},
+			},
+			want:        map[uint32][]string{1: {"dup1", "dup2"}},
+			wantRecords: 2,
+		},
+		{
+			name: "H_OFFDUP",
+			pairs: []testBDBPair{
+				{key: hnum(1), value: header("offpage"), offPage: true},
+				{key: hnum(2), duplicates: [][]byte{header("offdup1"), header("offdup2")}, offDup: true},
+			},
+			want:        map[uint32][]string{1: {"offpage"}, 2: {"offdup1", "offdup2"}},
+			wantRecords: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "Packages")
+			createBDBHash(t, path, 4096, tt.pairs...)
+
+			db, err := Open(path)
+			require.NoError(t, err)
+			defer db.Close()
+
+			pkgs, err := db.ListPackages()
+			require.NoError(t, err)
+			got := make(map[uint32][]string)
+			for _, pkg := range pkgs {
+				got[pkg.DBInstance] = append(got[pkg.DBInstance], pkg.Name)
+			}
+			assert.Equal(t, tt.want, got)
+
+			// the same values through the generic cursor
+			bdbDB, err := bdb.OpenDatabase(path)
+			require.NoError(t, err)
+			defer bdbDB.Close()
+
+			var n int
+			c := bdbDB.Cursor(context.Background())
+			for c.Next() {
+				n++
+			}
+			require.NoError(t, c.Err())
+			assert.Equal(t, tt.wantRecords, n)
+		})
+	}
+}
+
+func TestBerkeleyDBInvalidOverflowLength(t *testing.T) {
+	hnum := func(n uint32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, n)
+		return b
+	}
+	const pageSize = 1024
+	path := filepath.Join(t.TempDir(), "Packages")
+	createBDBHash(t, path, pageSize,
+		testBDBPair{key: hnum(1), value: testIndexedHeader("bash", "5.2", 1000, "bash"), offPage: true},
+	)
+
+	// the length of the content on the last overflow page exceeds the page
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	binary.LittleEndian.PutUint16(data[2*pageSize+22:], 0xffff)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ListPackages()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid overflow length on page=2")
+	var corruptErr *dbi.ErrCorruptPage
+	assert.True(t, xerrors.As(err, &corruptErr))
+}
@@ -0,0 +1,179 @@
+package rpmdb
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+)
+
+// createLargeBDB writes a Packages database with headers of up to a few pages
+func createLargeBDB(t testing.TB, packages int) string {
+	t.Helper()
+
+	var pairs []testBDBPair
+	for i := 1; i <= packages; i++ {
+		key := make([]byte, 4)
+		binary.LittleEndian.PutUint32(key, uint32(i))
+		provides := []string{fmt.Sprintf("pkg%d", i)}
+		for j := 0; j < i%20*20; j++ {
+			provides = append(provides, fmt.Sprintf("pkg%d-capability-%d", i, j))
+		}
+		pairs = append(pairs, testBDBPair{
+			key:     key,
+			value:   testIndexedHeader(fmt.Sprintf("pkg%d", i), "1.0", 1000, provides...),
+			offPage: true,
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "Packages")
+	createBDBHash(t, path, 16384, pairs...)
+	return path
+}
+
+func TestRpmDB_ConcurrentRead(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{
+			name: "BerkeleyDB",
+			file: createLargeBDB(t, 200),
+		},
+		{
+			name: "NDB",
+			file: "testdata/sle15-bci/Packages.db",
+		},
+	}
+	for _, tt := range tests {
+		for _, mmap := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/mmap=%v", tt.name, mmap), func(t *testing.T) {
+				var opts []Option
+				if mmap {
+					opts = append(opts, WithMmap())
+				}
+				db, err := Open(tt.file, opts...)
+				require.NoError(t, err)
+				defer db.Close()
+
+				want, err := db.ListPackages()
+				require.NoError(t, err)
+				require.NotEmpty(t, want)
+
+				var wg sync.WaitGroup
+				results := make([][]*PackageInfo, 8)
+				errs := make([]error, len(results))
+				for i := range results {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						results[i], errs[i] = db.ListPackages()
+					}(i)
+				}
+				wg.Wait()
+
+				for i := range results {
+					require.NoError(t, errs[i])
+					assert.Equal(t, want, results[i])
+				}
+			})
+		}
+	}
+}
+
+// readAll drains the backend, without decoding the headers
+func readAll(db *RpmDB) error {
+	for entry := range db.db.Read(context.Background()) {
+		if entry.Err != nil {
+			return entry.Err
+		}
+	}
+	return nil
+}
+
+func BenchmarkRpmDB_Read(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		file string
+	}{
+		{
+			name: "BerkeleyDB",
+			file: createLargeBDB(b, 500),
+		},
+		{
+			name: "NDB",
+			file: "testdata/sle15-bci/Packages.db",
+		},
+	}
+	for _, bm := range benchmarks {
+		for _, mmap := range []bool{false, true} {
+			var opts []Option
+			if mmap {
+				opts = append(opts, WithMmap())
+			}
+			db, err := Open(bm.file, opts...)
+			require.NoError(b, err)
+
+			b.Run(fmt.Sprintf("%s/mmap=%v", bm.name, mmap), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if err := readAll(db); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("%s/mmap=%v/parallel", bm.name, mmap), func(b *testing.B) {
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						if err := readAll(db); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			})
+			require.NoError(b, db.Close())
+		}
+	}
+}
+
+func TestMmap_CloseWhileReading(t *testing.T) {
+	file, err := dbi.OpenMmap(createLargeBDB(t, 50))
+	require.NoError(t, err)
+	size := file.Size()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 4096)
+			for off := int64(0); ; off = (off + int64(len(buf))) % size {
+				if _, err := file.ReadAt(buf, off); err != nil && !xerrors.Is(err, io.EOF) {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	require.NoError(t, file.Close())
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.True(t, xerrors.Is(err, os.ErrClosed), err)
+	}
+
+	_, err = file.ReadAt(make([]byte, 1), 0)
+	assert.True(t, xerrors.Is(err, os.ErrClosed))
+	assert.Equal(t, size, file.Size())
+}
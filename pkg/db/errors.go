@@ -0,0 +1,65 @@
+package dbi
+
+import (
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	// ErrNotRPMDB is returned when a file is none of the supported rpmdb formats
+	ErrNotRPMDB = xerrors.New("not an rpmdb")
+	// ErrEncrypted is returned for encrypted Berkeley DB databases, which rpm never writes
+	ErrEncrypted = xerrors.New("encrypted database")
+	// ErrPackageNotFound is returned when no package matches a name or an instance number.
+	// ErrHeaderNotFound is also an ErrPackageNotFound.
+	ErrPackageNotFound = xerrors.New("package not found")
+)
+
+// ErrCorruptHeader is returned for header blobs which can't be decoded
+type ErrCorruptHeader struct {
+	// Tag is the rpm tag of the invalid entry, 0 if the structure of the header is invalid
+	Tag int32
+	// Offset is the byte offset of the invalid data in the header blob
+	Offset int64
+	Err    error
+}
+
+func (e *ErrCorruptHeader) Error() string {
+	if e.Tag == 0 {
+		return fmt.Sprintf("corrupt header at offset %d: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("corrupt header at offset %d, tag %d: %v", e.Offset, e.Tag, e.Err)
+}
+
+func (e *ErrCorruptHeader) Unwrap() error {
+	return e.Err
+}
+
+// ErrCorruptPage is returned for pages of a database file which can't be parsed
+type ErrCorruptPage struct {
+	// PageNo is the Berkeley DB or SQLite page, or the NDB slot page
+	PageNo uint32
+	Err    error
+}
+
+func (e *ErrCorruptPage) Error() string {
+	return fmt.Sprintf("corrupt page %d: %v", e.PageNo, e.Err)
+}
+
+func (e *ErrCorruptPage) Unwrap() error {
+	return e.Err
+}
+
+// notFoundError is a sentinel error which is also an ErrPackageNotFound
+type notFoundError struct {
+	msg string
+}
+
+func (e *notFoundError) Error() string {
+	return e.msg
+}
+
+func (e *notFoundError) Is(target error) bool {
+	return target == ErrPackageNotFound
+}
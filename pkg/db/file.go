@@ -0,0 +1,98 @@
+package dbi
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// File is a read-only database file. Reads don't share a file offset, so that
+// several goroutines can read a File at once.
+type File interface {
+	io.ReaderAt
+	io.Closer
+	// Name returns the path of the file
+	Name() string
+	// Size returns the size of the file when it was opened
+	Size() int64
+}
+
+type osFile struct {
+	*os.File
+	size int64
+}
+
+func (f *osFile) Size() int64 {
+	return f.size
+}
+
+// OpenFile opens a database file which is read with pread(2)
+func OpenFile(path string) (File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, xerrors.Errorf("failed to stat %s: %w", path, err)
+	}
+	return &osFile{File: file, size: info.Size()}, nil
+}
+
+// memFile is a File in memory, e.g. a mapped one. Close waits for the reads in progress,
+// since reading unmapped memory faults.
+type memFile struct {
+	name  string
+	size  int64
+	close func() error
+
+	mu     sync.RWMutex
+	data   []byte
+	closed bool
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset: %d", off)
+	}
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Size() int64 {
+	return f.size
+}
+
+func (f *memFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	f.data = nil
+	if f.close == nil {
+		return nil
+	}
+	return f.close()
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package dbi
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+// OpenMmap maps a database file into memory, so that reading pages needs no system calls.
+// The file must not be truncated while it's mapped, use OpenFile for databases which are
+// being modified.
+func OpenMmap(path string) (File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to stat %s: %w", path, err)
+	}
+	// empty files can't be mapped
+	if info.Size() == 0 {
+		return &memFile{name: path}, nil
+	}
+	if int64(int(info.Size())) != info.Size() {
+		return nil, xerrors.Errorf("%s is too large to map: %d", path, info.Size())
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to mmap %s: %w", path, err)
+	}
+	return &memFile{
+		name: path,
+		size: info.Size(),
+		data: data,
+		close: func() error {
+			return syscall.Munmap(data)
+		},
+	}, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package dbi
+
+// OpenMmap opens a database file like OpenFile, mmap is only used on Linux
+func OpenMmap(path string) (File, error) {
+	return OpenFile(path)
+}
@@ -1,12 +1,119 @@
 package dbi
 
-import "context"
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
 
 type Entry struct {
 	Value []byte
-	Err   error
+	// HeaderNum is the header instance number of the package in the database, 0 if unknown
+	HeaderNum uint32
+	Err       error
 }
 
 type RpmDBInterface interface {
 	Read(ctx context.Context) <-chan Entry
 }
+
+// Index is the name of a secondary index database of rpm
+type Index string
+
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.16.0-release/lib/rpmdb.c
+const (
+	IndexName        Index = "Name"
+	IndexProvidename Index = "Providename"
+	IndexRequirename Index = "Requirename"
+	IndexBasenames   Index = "Basenames"
+	IndexDirnames    Index = "Dirnames"
+	IndexSha1header  Index = "Sha1header"
+	IndexSigmd5      Index = "Sigmd5"
+	IndexInstalltid  Index = "Installtid"
+)
+
+// the rpm tags indexed by the index databases
+var indexTags = map[Index]int32{
+	IndexName:        1000, // RPMTAG_NAME
+	IndexProvidename: 1047, // RPMTAG_PROVIDENAME
+	IndexRequirename: 1049, // RPMTAG_REQUIRENAME
+	IndexBasenames:   1117, // RPMTAG_BASENAMES
+	IndexDirnames:    1118, // RPMTAG_DIRNAMES
+	IndexSha1header:  269,  // RPMTAG_SHA1HEADER
+	IndexSigmd5:      261,  // RPMTAG_SIGMD5
+	IndexInstalltid:  1128, // RPMTAG_INSTALLTID
+}
+
+// Tag returns the rpm tag indexed by the index, or 0 for unknown indexes
+func (i Index) Tag() int32 {
+	return indexTags[i]
+}
+
+// Binary reports whether the keys of the index are binary rather than strings
+func (i Index) Binary() bool {
+	return i == IndexSigmd5 || i == IndexInstalltid
+}
+
+// IndexItem refers to an entry of a tag in a header of the Packages database
+type IndexItem struct {
+	// HeaderNum is the header instance number
+	HeaderNum uint32
+	// TagNum is the index of the matching entry within the tag, e.g. the file index for Basenames
+	TagNum uint32
+}
+
+var (
+	// ErrIndexNotFound is returned when the rpmdb has no usable index database
+	ErrIndexNotFound = xerrors.New("index not found")
+	// ErrHeaderNotFound is returned when there is no header with the given instance number
+	ErrHeaderNotFound error = &notFoundError{msg: "header not found"}
+)
+
+// IndexReader is implemented by backends which can read rpm's secondary indexes
+// and fetch single headers without reading the whole database.
+type IndexReader interface {
+	// ReadIndex returns the items of a key, ErrIndexNotFound if the index database doesn't exist
+	ReadIndex(ctx context.Context, index Index, key []byte) ([]IndexItem, error)
+	// ReadHeader returns the header blob with the instance number, ErrHeaderNotFound if it doesn't exist
+	ReadHeader(ctx context.Context, hnum uint32) ([]byte, error)
+}
+
+// Diagnostic describes a record which was skipped because it couldn't be read.
+// It's returned as Entry.Err by Salvager.
+type Diagnostic struct {
+	// Backend is "bdb", "ndb", "lmdb" or "sqlite"
+	Backend string
+	// PageNo is the Berkeley DB or SQLite page of the record
+	PageNo uint32
+	// Slot is the item index on a Berkeley DB page, the NDB slot, the cell on a SQLite page
+	// or the SQLite row read through database/sql, -1 if unknown
+	Slot int
+	// HeaderNum is the header instance number of the record, 0 if unknown
+	HeaderNum uint32
+	// Offset is the byte offset of the record in the database file, -1 if unknown
+	Offset int64
+	Err    error
+}
+
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s: page %d, slot %d, hnum %d, offset %d: %v", d.Backend, d.PageNo, d.Slot, d.HeaderNum, d.Offset, d.Err)
+}
+
+func (d *Diagnostic) Unwrap() error {
+	return d.Err
+}
+
+// Salvager is implemented by backends which can read past corrupted records.
+// Entries with a *Diagnostic error are skipped records and the reading continues with the
+// next record. Any other error, e.g. of a canceled context, ends the reading.
+type Salvager interface {
+	Salvage(ctx context.Context) <-chan Entry
+}
+
+// Versioner is implemented by backends which can detect modifications of the database.
+// The version is read from the file, not from the state loaded by Open.
+type Versioner interface {
+	// Version returns a value which changes when rpm modifies the database
+	Version() (uint64, error)
+}
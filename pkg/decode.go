@@ -0,0 +1,234 @@
+package rpmdb
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/xerrors"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+)
+
+// Fields is a set of groups of PackageInfo fields
+type Fields uint32
+
+const (
+	// FieldNEVRA is Epoch, Name, Version, Release, Arch and Modularitylabel, which are always decoded
+	FieldNEVRA Fields = 1 << iota
+	// FieldMetadata is SourceRpm, Size, License, Vendor, Summary, InstallTime, InstallColor,
+	// InstPrefixes, RPMFormat and the payload fields
+	FieldMetadata
+	// FieldSignatures is PGP, SigMD5 and OpenPGP
+	FieldSignatures
+	// FieldDependencies is Provides and Requires
+	FieldDependencies
+	// FieldFiles is the file data, like BaseNames, DirNames and FileDigests, and DigestAlgorithm
+	FieldFiles
+
+	FieldsAll = FieldNEVRA | FieldMetadata | FieldSignatures | FieldDependencies | FieldFiles
+)
+
+// DecodeOptions selects the fields of the packages to decode. Skipping the file data
+// saves most of the time and memory of listing the packages.
+type DecodeOptions struct {
+	// Fields are the fields to decode, all of them if it's 0
+	Fields Fields
+
+	// LazyFiles keeps the file data of the packages undecoded until the first call of InstalledFiles,
+	// InstalledFileNames, InstFileNames, PresentFiles or FileDigestAlgorithm, whether or not
+	// Fields has FieldFiles
+	LazyFiles bool
+}
+
+// tagFields returns the group of the field of a tag
+func tagFields(tag int32) Fields {
+	switch tag {
+	case RPMTAG_SOURCERPM, RPMTAG_SIZE, RPMTAG_LICENSE, RPMTAG_VENDOR, RPMTAG_SUMMARY, RPMTAG_INSTALLTIME,
+		RPMTAG_INSTALLCOLOR, RPMTAG_INSTPREFIXES, RPMTAG_RPMFORMAT,
+		RPMTAG_PAYLOADFORMAT, RPMTAG_PAYLOADCOMPRESSOR, RPMTAG_PAYLOADFLAGS:
+		return FieldMetadata
+	case RPMTAG_PGP, RPMTAG_SIGMD5, RPMTAG_OPENPGP:
+		return FieldSignatures
+	case RPMTAG_PROVIDENAME, RPMTAG_REQUIRENAME:
+		return FieldDependencies
+	case RPMTAG_DIRINDEXES, RPMTAG_DIRNAMES, RPMTAG_BASENAMES, RPMTAG_OLDFILENAMES, RPMTAG_FILEDIGESTALGO,
+		RPMTAG_FILEDIGESTALGOS, RPMTAG_FILEALTDIGESTS, RPMTAG_FILESIZES, RPMTAG_LONGFILESIZES, RPMTAG_FILEMTIMES,
+		RPMTAG_FILEINODES, RPMTAG_FILELINKTOS, RPMTAG_FILESTATES, RPMTAG_FILERDEVS, RPMTAG_FILECAPS, RPMTAG_FILEDIGESTS,
+		RPMTAG_FILEMODES, RPMTAG_FILEFLAGS, RPMTAG_FILEUSERNAME, RPMTAG_FILEGROUPNAME, RPMTAG_FILESIGNATURES,
+		RPMTAG_FILESIGNATURELENGTH, RPMTAG_VERITYSIGNATURES, RPMTAG_VERITYSIGNATUREALGO:
+		return FieldFiles
+	}
+	return FieldNEVRA
+}
+
+// packageDecoder decodes the headers of a database. It's not safe for concurrent use,
+// since the packages share the interned strings.
+type packageDecoder struct {
+	strs   stringTable
+	fields Fields
+	lazy   bool
+}
+
+func newPackageDecoder(opts DecodeOptions) *packageDecoder {
+	fields := opts.Fields
+	if fields == 0 {
+		fields = FieldsAll
+	}
+	fields |= FieldNEVRA
+	if opts.LazyFiles {
+		fields &^= FieldFiles
+	}
+	return &packageDecoder{
+		strs:   stringTable{},
+		fields: fields,
+		lazy:   opts.LazyFiles,
+	}
+}
+
+func (dec *packageDecoder) decode(blob []byte, instance uint32) (*PackageInfo, error) {
+	indexEntries, err := headerImport(blob)
+	if err != nil {
+		return nil, xerrors.Errorf("error during importing header: %w", err)
+	}
+	pkg, err := dec.decodeHeader(indexEntries)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid package info: %w", err)
+	}
+	pkg.DBInstance = instance
+	return pkg, nil
+}
+
+func (dec *packageDecoder) decodeHeader(indexEntries []indexEntry) (*PackageInfo, error) {
+	pkgInfo := &PackageInfo{}
+	if err := decodeEntries(pkgInfo, indexEntries, dec.strs, dec.fields); err != nil {
+		return nil, err
+	}
+	if dec.lazy {
+		pkgInfo.files = newLazyFiles(indexEntries)
+	}
+	if headerIsLegacy(indexEntries) {
+		pkgInfo.Legacy = true
+		if dec.fields&FieldDependencies != 0 {
+			providePackageName(pkgInfo, indexEntries)
+		}
+	}
+	pkgInfo.setDefaults()
+	return pkgInfo, nil
+}
+
+// lazyFiles holds the entries of the file data of a package
+type lazyFiles struct {
+	once    sync.Once
+	entries []indexEntry
+	err     error
+}
+
+// newLazyFiles copies the file entries, the backends may reuse the buffers of the blobs
+func newLazyFiles(indexEntries []indexEntry) *lazyFiles {
+	var entries []indexEntry
+	var size int
+	for _, ie := range indexEntries {
+		if tagFields(ie.Info.Tag) == FieldFiles {
+			entries = append(entries, ie)
+			size += len(ie.Data)
+		}
+	}
+
+	data := make([]byte, 0, size)
+	for i := range entries {
+		data = append(data, entries[i].Data...)
+		entries[i].Data = data[len(data)-len(entries[i].Data):]
+	}
+	return &lazyFiles{entries: entries}
+}
+
+// loadFiles decodes the file data of a package decoded with DecodeOptions.LazyFiles
+func (p *PackageInfo) loadFiles() error {
+	if p.files == nil {
+		return nil
+	}
+	p.files.once.Do(func() {
+		if err := decodeEntries(p, p.files.entries, stringTable{}, FieldFiles); err != nil {
+			p.files.err = xerrors.Errorf("failed to decode the files of %s: %w", p.Name, err)
+		}
+		p.setDefaults()
+		p.files.entries = nil
+	})
+	return p.files.err
+}
+
+type decodeResult struct {
+	pkg *PackageInfo
+	err error
+}
+
+type decodeJob struct {
+	entry  dbi.Entry
+	result *decodeResult
+}
+
+// decodePackages decodes the headers of entries with a pool of workers and returns the packages
+// in the order of entries. Reading stops at the first error, and the error of the earliest
+// failing entry is returned, so that the result doesn't depend on the scheduling.
+func decodePackages(ctx context.Context, entries <-chan dbi.Entry, workers int, opts DecodeOptions) ([]*PackageInfo, error) {
+	jobs := make(chan decodeJob, workers)
+	var failed int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// every worker has its own decoder to avoid locking
+			dec := newPackageDecoder(opts)
+			for job := range jobs {
+				if err := ctx.Err(); err != nil {
+					job.result.err = err
+					continue
+				}
+				job.result.pkg, job.result.err = dec.decode(job.entry.Value, job.entry.HeaderNum)
+				if job.result.err != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+
+	// the earlier entries are always dispatched before a failing one
+	var results []*decodeResult
+	var stopErr error
+dispatch:
+	for entry := range entries {
+		if atomic.LoadInt32(&failed) != 0 {
+			break
+		}
+		if entry.Err != nil {
+			results = append(results, &decodeResult{err: entry.Err})
+			break
+		}
+
+		result := &decodeResult{}
+		select {
+		case jobs <- decodeJob{entry: entry, result: result}:
+			results = append(results, result)
+		case <-ctx.Done():
+			stopErr = ctx.Err()
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	pkgs := make([]*PackageInfo, 0, len(results))
+	for _, result := range results {
+		if result.err != nil {
+			return nil, result.err
+		}
+		pkgs = append(pkgs, result.pkg)
+	}
+	if stopErr != nil {
+		return nil, stopErr
+	}
+	return pkgs, nil
+}
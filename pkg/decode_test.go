@@ -0,0 +1,226 @@
+package rpmdb
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+// truncated cuts the data of a header blob
+func truncated(blob []byte) []byte {
+	return blob[:len(blob)-8]
+}
+
+func TestRpmDB_DecodeWorkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr bool
+	}{
+		{
+			name: "BerkeleyDB",
+			file: createLargeBDB(t, 200),
+		},
+		{
+			name: "NDB",
+			file: "testdata/sle15-bci/Packages.db",
+		},
+		{
+			name: "SQLite3",
+			file: "testdata/cbl-mariner-2.0/rpmdb.sqlite",
+		},
+		{
+			name: "corrupted header",
+			file: createSQLite3DB(t,
+				testIndexedHeader("bash", "5.2", 1000, "bash"),
+				truncated(testIndexedHeader("zsh", "5.9", 1000, "zsh")),
+				testIndexedHeader("curl", "8.0", 1000, "curl"),
+				truncated(testIndexedHeader("vim", "9.0", 1000, "vim")),
+			),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serial, err := Open(tt.file)
+			require.NoError(t, err)
+			defer serial.Close()
+			want, wantErr := serial.ListPackages()
+			require.Equal(t, tt.wantErr, wantErr != nil, wantErr)
+
+			for _, workers := range []int{2, 8} {
+				db, err := Open(tt.file, WithDecodeWorkers(workers))
+				require.NoError(t, err)
+
+				// the result doesn't depend on the scheduling
+				for i := 0; i < 5; i++ {
+					got, err := db.ListPackages()
+					if wantErr != nil {
+						require.Error(t, err)
+						assert.Equal(t, wantErr.Error(), err.Error())
+						continue
+					}
+					require.NoError(t, err)
+					assert.Equal(t, want, got)
+				}
+				require.NoError(t, db.Close())
+			}
+		})
+	}
+}
+
+func TestRpmDB_DecodeWorkersCancel(t *testing.T) {
+	var headers [][]byte
+	for i := 0; i < 500; i++ {
+		headers = append(headers, testIndexedHeader(fmt.Sprintf("pkg%d", i), "1.0", 1000, fmt.Sprintf("pkg%d", i)))
+	}
+	file := createSQLite3DB(t, headers...)
+
+	tests := []struct {
+		name    string
+		workers int
+	}{
+		{name: "serial", workers: 1},
+		{name: "workers", workers: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(file, WithDecodeWorkers(tt.workers))
+			require.NoError(t, err)
+			defer db.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err = db.ListPackagesWithContext(ctx)
+			require.Error(t, err)
+			assert.True(t, xerrors.Is(err, context.Canceled), err.Error())
+
+			// the producer and the workers are gone
+			assert.Eventually(t, func() bool {
+				buf := make([]byte, 1<<20)
+				stacks := string(buf[:runtime.Stack(buf, true)])
+				return !strings.Contains(stacks, "decodePackages") && !strings.Contains(stacks, "(*Native).Read")
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func BenchmarkRpmDB_DecodeWorkers(b *testing.B) {
+	file := createLargeBDB(b, 500)
+	for _, workers := range []int{1, 2, 4, 8} {
+		db, err := Open(file, WithDecodeWorkers(workers))
+		require.NoError(b, err)
+
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.ListPackages(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		require.NoError(b, db.Close())
+	}
+}
+
+func TestRpmDB_DecodeOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{
+			name: "NDB",
+			file: "testdata/sle15-bci/Packages.db",
+		},
+		{
+			name: "SQLite3",
+			file: "testdata/cbl-mariner-2.0/rpmdb.sqlite",
+		},
+	}
+	for _, tt := range tests {
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s/workers=%d", tt.name, workers), func(t *testing.T) {
+				db, err := Open(tt.file, WithDecodeWorkers(workers))
+				require.NoError(t, err)
+				defer db.Close()
+
+				ctx := context.Background()
+				want, err := db.ListPackagesWithContext(ctx)
+				require.NoError(t, err)
+
+				t.Run("metadata", func(t *testing.T) {
+					got, err := db.ListPackagesWithOptions(ctx, DecodeOptions{Fields: FieldMetadata})
+					require.NoError(t, err)
+					require.Len(t, got, len(want))
+					for i, pkg := range got {
+						w := want[i]
+						assert.Equal(t, &PackageInfo{
+							Epoch:             w.Epoch,
+							Name:              w.Name,
+							Version:           w.Version,
+							Release:           w.Release,
+							Arch:              w.Arch,
+							SourceRpm:         w.SourceRpm,
+							Size:              w.Size,
+							License:           w.License,
+							Vendor:            w.Vendor,
+							Modularitylabel:   w.Modularitylabel,
+							Summary:           w.Summary,
+							RPMFormat:         w.RPMFormat,
+							InstallTime:       w.InstallTime,
+							InstallColor:      w.InstallColor,
+							InstPrefixes:      w.InstPrefixes,
+							PayloadFormat:     w.PayloadFormat,
+							PayloadCompressor: w.PayloadCompressor,
+							PayloadFlags:      w.PayloadFlags,
+							DBInstance:        w.DBInstance,
+						}, pkg)
+
+						files, err := pkg.InstalledFiles()
+						require.NoError(t, err)
+						assert.Empty(t, files)
+					}
+				})
+
+				t.Run("lazy files", func(t *testing.T) {
+					got, err := db.ListPackagesWithOptions(ctx, DecodeOptions{LazyFiles: true})
+					require.NoError(t, err)
+					require.Len(t, got, len(want))
+					for i, pkg := range got {
+						assert.Nil(t, pkg.BaseNames)
+						assert.Nil(t, pkg.FileDigests)
+						assert.Equal(t, want[i].Provides, pkg.Provides)
+
+						wantFiles, err := want[i].InstalledFiles()
+						require.NoError(t, err)
+
+						// the files are decoded once, by the first caller
+						var wg sync.WaitGroup
+						for j := 0; j < 4; j++ {
+							wg.Add(1)
+							go func() {
+								defer wg.Done()
+								files, err := pkg.InstalledFiles()
+								assert.NoError(t, err)
+								assert.Equal(t, wantFiles, files)
+							}()
+						}
+						wg.Wait()
+
+						require.NotNil(t, pkg.files)
+						pkg.files = nil
+						assert.Equal(t, want[i], pkg)
+					}
+				})
+			})
+		}
+	}
+}
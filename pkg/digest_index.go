@@ -0,0 +1,89 @@
+package rpmdb
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// DigestKey identifies file content by its digest
+type DigestKey struct {
+	Algorithm DigestAlgorithm
+	// Digest is the lower case hex encoded digest
+	Digest string
+}
+
+// DigestOwner is an installed file with a given digest
+type DigestOwner struct {
+	// Source names the rpmdb the package was read from
+	Source  string
+	Package *PackageInfo
+	Path    string
+}
+
+// DigestIndex maps file digests to the packages which shipped files with that content.
+// Packages from several rpmdbs can be added, e.g. for the layers of container images.
+// It is safe for concurrent use.
+type DigestIndex struct {
+	mu     sync.RWMutex
+	owners map[DigestKey][]DigestOwner
+}
+
+// NewDigestIndex returns an empty DigestIndex
+func NewDigestIndex() *DigestIndex {
+	return &DigestIndex{
+		owners: make(map[DigestKey][]DigestOwner),
+	}
+}
+
+// AddPackages indexes the file digests of the packages. Packages with more than one
+// file digest algorithm are indexed with all of them.
+func (idx *DigestIndex) AddPackages(source string, pkgs []*PackageInfo) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, pkg := range pkgs {
+		files, err := pkg.InstalledFiles()
+		if err != nil {
+			return xerrors.Errorf("unable to list files of %s: %w", pkg.Name, err)
+		}
+
+		for _, file := range files {
+			owner := DigestOwner{Source: source, Package: pkg, Path: file.Path}
+			if len(file.Digests) > 0 {
+				for algo, digest := range file.Digests {
+					idx.add(algo, digest, owner)
+				}
+				continue
+			}
+			if file.Digest != "" {
+				idx.add(pkg.FileDigestAlgorithm(), file.Digest, owner)
+			}
+		}
+	}
+	return nil
+}
+
+// AddRpmDBWithContext indexes the file digests of all packages of an rpmdb
+func (idx *DigestIndex) AddRpmDBWithContext(ctx context.Context, source string, db *RpmDB) error {
+	pkgs, err := db.ListPackagesWithContext(ctx)
+	if err != nil {
+		return xerrors.Errorf("unable to list packages: %w", err)
+	}
+	return idx.AddPackages(source, pkgs)
+}
+
+func (idx *DigestIndex) add(algo DigestAlgorithm, digest string, owner DigestOwner) {
+	key := DigestKey{Algorithm: algo, Digest: strings.ToLower(digest)}
+	idx.owners[key] = append(idx.owners[key], owner)
+}
+
+// Lookup returns the installed files with the given digest, or nil if no package shipped such a file
+func (idx *DigestIndex) Lookup(algo DigestAlgorithm, digest string) []DigestOwner {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.owners[DigestKey{Algorithm: algo, Digest: strings.ToLower(digest)}]
+}
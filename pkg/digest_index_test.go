@@ -0,0 +1,87 @@
+package rpmdb
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestIndex(t *testing.T) {
+	sha256sum := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	md5sum := func(content string) string {
+		sum := md5.Sum([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+
+	bash := &PackageInfo{
+		Name:            "bash",
+		DigestAlgorithm: PGPHASHALGO_SHA256,
+		BaseNames:       []string{"bash", "bin"},
+		DirNames:        []string{"/usr/bin/", "/usr/"},
+		DirIndexes:      []int32{0, 1},
+		FileDigests:     []string{sha256sum("bash"), ""},
+	}
+	// old packages without RPMTAG_FILEDIGESTALGO use md5
+	legacy := &PackageInfo{
+		Name:        "legacy",
+		BaseNames:   []string{"bash"},
+		DirNames:    []string{"/bin/"},
+		DirIndexes:  []int32{0},
+		FileDigests: []string{md5sum("bash")},
+	}
+
+	idx := NewDigestIndex()
+	require.NoError(t, idx.AddPackages("host", []*PackageInfo{bash}))
+	require.NoError(t, idx.AddPackages("chroot", []*PackageInfo{legacy}))
+
+	db, err := Open(createSQLite3DB(t, rpm6Header()))
+	require.NoError(t, err)
+	require.NoError(t, idx.AddRpmDBWithContext(context.Background(), "image", db))
+
+	assert.Equal(t, []DigestOwner{{Source: "host", Package: bash, Path: "/usr/bin/bash"}},
+		idx.Lookup(PGPHASHALGO_SHA256, sha256sum("bash")))
+	assert.Equal(t, []DigestOwner{{Source: "chroot", Package: legacy, Path: "/bin/bash"}},
+		idx.Lookup(PGPHASHALGO_MD5, md5sum("bash")))
+	assert.Nil(t, idx.Lookup(PGPHASHALGO_SHA1, sha256sum("bash")))
+	assert.Nil(t, idx.Lookup(PGPHASHALGO_SHA256, ""))
+
+	// rpm v6 packages are indexed with every file digest algorithm
+	for _, key := range []DigestKey{{PGPHASHALGO_SHA256, "sha256-a"}, {PGPHASHALGO_SHA512, "SHA512-A"}} {
+		owners := idx.Lookup(key.Algorithm, key.Digest)
+		require.Len(t, owners, 1, key)
+		assert.Equal(t, "image", owners[0].Source)
+		assert.Equal(t, "/usr/bin/hello", owners[0].Path)
+	}
+}
+
+func TestPackageInfo_HashFile(t *testing.T) {
+	root := fstest.MapFS{
+		"usr/bin/bash": {Data: []byte("replaced")},
+	}
+	pkg := &PackageInfo{
+		DigestAlgorithm: PGPHASHALGO_SHA256,
+		FileDigests:     []string{"0000"},
+	}
+
+	digest, err := pkg.HashFile(root, "usr/bin/bash")
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte("replaced"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), digest)
+	assert.NotEqual(t, pkg.FileDigests[0], digest)
+
+	_, err = pkg.HashFile(root, "usr/bin/missing")
+	assert.Error(t, err)
+
+	_, err = HashFile(root, "usr/bin/bash", PGPHASHALGO_TIGER192)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported digest algorithm: tiger192")
+}
@@ -7,6 +7,8 @@ import (
 	"unsafe"
 
 	"golang.org/x/xerrors"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
 )
 
 const (
@@ -73,10 +75,13 @@ type indexEntry struct {
 	Length int
 	Rdlen  int
 	Data   []byte
+	// DataOffset is the offset of Data in the header blob
+	DataOffset int32
 }
 
 // ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/header_internal.h#L23
 type hdrblob struct {
+	// peList holds the entry infos in host byte order, unlike rpm which swaps them on access with ei2h
 	peList    []entryInfo
 	il        int32
 	dl        int32
@@ -92,27 +97,41 @@ type hdrblob struct {
 func headerImport(data []byte) ([]indexEntry, error) {
 	blob, err := hdrblobInit(data)
 	if err != nil {
-		return nil, xerrors.Errorf("failed to initialize header blob: %w", err)
+		return nil, xerrors.Errorf("failed to initialize header blob: %w", asCorruptHeader(err))
 	}
 	indexEntries, err := hdrblobImport(*blob, data)
 	if err != nil {
-		return nil, xerrors.Errorf("failed to import header blob: %w", err)
+		return nil, xerrors.Errorf("failed to import header blob: %w", asCorruptHeader(err))
 	}
 	return indexEntries, nil
 }
 
+// corruptHeader returns an error of the data of a tag at offset in a header blob
+func corruptHeader(tag, offset int32, err error) error {
+	return &dbi.ErrCorruptHeader{Tag: tag, Offset: int64(offset), Err: err}
+}
+
+// asCorruptHeader returns err as a dbi.ErrCorruptHeader of the header structure, unless it refers to an entry
+func asCorruptHeader(err error) error {
+	var headerErr *dbi.ErrCorruptHeader
+	if xerrors.As(err, &headerErr) {
+		return err
+	}
+	return &dbi.ErrCorruptHeader{Err: err}
+}
+
 // ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/header.c#L1974
 func hdrblobInit(data []byte) (*hdrblob, error) {
 	var blob hdrblob
-	var err error
-	reader := bytes.NewReader(data)
 
-	if err = binary.Read(reader, binary.BigEndian, &blob.il); err != nil {
-		return nil, xerrors.Errorf("invalid index length: %w", err)
+	if len(data) < 4 {
+		return nil, xerrors.Errorf("invalid index length: %w", io.ErrUnexpectedEOF)
 	}
-	if err = binary.Read(reader, binary.BigEndian, &blob.dl); err != nil {
-		return nil, xerrors.Errorf("invalid data length: %w", err)
+	blob.il = int32(binary.BigEndian.Uint32(data))
+	if len(data) < 8 {
+		return nil, xerrors.Errorf("invalid data length: %w", io.ErrUnexpectedEOF)
 	}
+	blob.dl = int32(binary.BigEndian.Uint32(data[4:]))
 	blob.dataStart = int32(unsafe.Sizeof(blob.il)) + int32(unsafe.Sizeof(blob.dl)) + blob.il*int32(unsafe.Sizeof(entryInfo{}))
 	blob.pvlen = int32(unsafe.Sizeof(blob.il)) + int32(unsafe.Sizeof(blob.dl)) + blob.il*int32(unsafe.Sizeof(entryInfo{})) + blob.dl
 	blob.dataEnd = blob.dataStart + blob.dl
@@ -121,16 +140,15 @@ func hdrblobInit(data []byte) (*hdrblob, error) {
 		return nil, xerrors.New("region no tags error")
 	}
 
+	// the entry infos must fit in the blob, which also bounds the allocation below
+	n := (len(data) - 8) / int(unsafe.Sizeof(entryInfo{}))
+	if int64(blob.il) > int64(n) {
+		return nil, xerrors.Errorf("failed to read entry info: %d of %d entries: %w", n, blob.il, io.ErrUnexpectedEOF)
+	}
+
 	blob.peList = make([]entryInfo, blob.il)
-	for i := 0; i < int(blob.il); i++ {
-		var pe entryInfo
-		err = binary.Read(reader, binary.LittleEndian, &pe)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return nil, xerrors.Errorf("failed to read entry info: %w", err)
-		}
-		blob.peList[i] = pe
+	for i := range blob.peList {
+		blob.peList[i] = entryInfoAt(data, 8+i*int(unsafe.Sizeof(entryInfo{})))
 	}
 	if blob.pvlen >= headerMaxbytes {
 		return nil, xerrors.Errorf("blob size(%d) BAD, 8 + 16 * il(%d) + dl(%d)", blob.pvlen, blob.il, blob.dl)
@@ -146,19 +164,46 @@ func hdrblobInit(data []byte) (*hdrblob, error) {
 	return &blob, nil
 }
 
+// entryInfoAt decodes the entry info stored at offset in network byte order.
+// The caller checks the bounds.
+func entryInfoAt(data []byte, offset int) entryInfo {
+	b := data[offset : offset+16]
+	return entryInfo{
+		Tag:    int32(binary.BigEndian.Uint32(b[0:])),
+		Type:   binary.BigEndian.Uint32(b[4:]),
+		Offset: int32(binary.BigEndian.Uint32(b[8:])),
+		Count:  binary.BigEndian.Uint32(b[12:]),
+	}
+}
+
+// headerIsLegacy reports whether the entries are of a legacy header without an immutable region,
+// i.e. an original v3 header or one which rpm 4.0 stored with a legacy HEADERIMAGE region
+func headerIsLegacy(indexEntries []indexEntry) bool {
+	return len(indexEntries) > 0 && indexEntries[0].Info.Tag == RPMTAG_HEADERIMAGE
+}
+
+// hdrblobImport returns the entries of the header. Like in rpm, the entries of legacy headers start
+// with their HEADERIMAGE region, see headerIsLegacy.
 // ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/header.c#L880
 func hdrblobImport(blob hdrblob, data []byte) ([]indexEntry, error) {
 	var indexEntries, dribbleIndexEntries []indexEntry
 	var err error
 	var rdlen int32
 
-	entry := ei2h(blob.peList[0])
+	entry := blob.peList[0]
 	if entry.Tag >= RPMTAG_HEADERI18NTABLE {
 		/* An original v3 header, create a legacy region entry for it */
 		indexEntries, rdlen, err = regionSwab(data, blob.peList, 0, blob.dataStart, blob.dataEnd)
 		if err != nil {
 			return nil, xerrors.Errorf("failed to parse legacy index entries: %w", err)
 		}
+		region := indexEntry{Info: entryInfo{
+			Tag:    RPMTAG_HEADERIMAGE,
+			Type:   REGION_TAG_TYPE,
+			Offset: -blob.il * REGION_TAG_COUNT,
+			Count:  uint32(REGION_TAG_COUNT),
+		}}
+		indexEntries = append([]indexEntry{region}, indexEntries...)
 	} else {
 		/* Either a v4 header or an "upgraded" v3 header with a legacy region */
 		ril := blob.ril
@@ -175,7 +220,7 @@ func hdrblobImport(blob hdrblob, data []byte) ([]indexEntry, error) {
 			return nil, xerrors.New("invalid region length")
 		}
 
-		if blob.ril < int32(len(blob.peList)-1) {
+		if blob.ril < int32(len(blob.peList)) {
 			dribbleIndexEntries, rdlen, err = regionSwab(data, blob.peList[ril:], rdlen, blob.dataStart, blob.dataEnd)
 			if err != nil {
 				return nil, xerrors.Errorf("failed to parse dribble entries: %w", err)
@@ -184,20 +229,28 @@ func hdrblobImport(blob hdrblob, data []byte) ([]indexEntry, error) {
 				return nil, xerrors.New("invalid length of dribble entries")
 			}
 
-			uniqTagMap := make(map[int32]indexEntry)
-
-			for _, indexEntry := range append(indexEntries, dribbleIndexEntries...) {
-				uniqTagMap[indexEntry.Info.Tag] = indexEntry
-			}
-
-			var ies []indexEntry
-			for _, indexEntry := range uniqTagMap {
-				ies = append(ies, indexEntry)
+			// the dribble entries replace the region entries of the same tag, there are only a few of them
+			for _, dribble := range dribbleIndexEntries {
+				replaced := false
+				for i := range indexEntries {
+					if indexEntries[i].Info.Tag == dribble.Info.Tag {
+						indexEntries[i] = dribble
+						replaced = true
+						break
+					}
+				}
+				if !replaced {
+					indexEntries = append(indexEntries, dribble)
+				}
 			}
-
-			indexEntries = ies
 		}
-		rdlen += REGION_TAG_COUNT
+		// a legacy region of all entries has no trailer
+		if entry.Offset != 0 {
+			rdlen += REGION_TAG_COUNT
+		}
+		if blob.regionTag == RPMTAG_HEADERIMAGE {
+			indexEntries = append([]indexEntry{{Info: entry}}, indexEntries...)
+		}
 	}
 
 	if rdlen != blob.dl {
@@ -215,33 +268,31 @@ func hdrblobVerifyInfo(blob *hdrblob, data []byte) error {
 		peOffset = 1
 	}
 
-	for _, pe := range blob.peList[peOffset:] {
-		info := ei2h(pe)
-
+	for _, info := range blob.peList[peOffset:] {
 		if end > info.Offset {
-			return xerrors.Errorf("invalid offset info: %+v", info)
+			return corruptHeader(info.Tag, blob.dataStart+info.Offset, xerrors.Errorf("invalid offset info: %+v", info))
 		}
 
 		if hdrchkTag(info.Tag) {
-			return xerrors.Errorf("invalid tag info: %+v", info)
+			return corruptHeader(info.Tag, blob.dataStart+info.Offset, xerrors.Errorf("invalid tag info: %+v", info))
 		}
 
 		if hdrchkType(info.Type) {
-			return xerrors.Errorf("invalid type info: %+v", info)
+			return corruptHeader(info.Tag, blob.dataStart+info.Offset, xerrors.Errorf("invalid type info: %+v", info))
 		}
 
 		if hdrchkAlign(info.Type, info.Offset) {
-			return xerrors.Errorf("invalid align info: %+v", info)
+			return corruptHeader(info.Tag, blob.dataStart+info.Offset, xerrors.Errorf("invalid align info: %+v", info))
 		}
 
 		if hdrchkRange(blob.dl, info.Offset) {
-			return xerrors.Errorf("invalid range info: %+v", info)
+			return corruptHeader(info.Tag, blob.dataStart+info.Offset, xerrors.Errorf("invalid range info: %+v", info))
 		}
 
 		length := dataLength(data, info.Type, info.Count, blob.dataStart+info.Offset, blob.dataEnd)
 		end := info.Offset + int32(length)
 		if hdrchkRange(blob.dl, end) || length <= 0 {
-			return xerrors.Errorf("invalid data length info: %+v", info)
+			return corruptHeader(info.Tag, blob.dataStart+info.Offset, xerrors.Errorf("invalid data length info: %+v", info))
 		}
 	}
 	return nil
@@ -264,7 +315,7 @@ func hdrblobVerifyRegion(blob *hdrblob, data []byte) error {
 	var einfo entryInfo
 	var regionTag int32
 
-	einfo = ei2h(blob.peList[0])
+	einfo = blob.peList[0]
 
 	if einfo.Tag == RPMTAG_HEADERIMAGE ||
 		einfo.Tag == RPMTAG_HEADERSIGNATURES ||
@@ -278,38 +329,43 @@ func hdrblobVerifyRegion(blob *hdrblob, data []byte) error {
 	}
 
 	if !(einfo.Type == REGION_TAG_TYPE && einfo.Count == uint32(REGION_TAG_COUNT)) {
-		return xerrors.New("invalid region tag")
+		return corruptHeader(einfo.Tag, 8, xerrors.New("invalid region tag"))
 	}
 
 	if hdrchkRange(blob.dl, einfo.Offset+REGION_TAG_COUNT) {
-		return xerrors.New("invalid region offset")
+		return corruptHeader(einfo.Tag, 8, xerrors.New("invalid region offset"))
+	}
+
+	// rpm 4.0 stored upgraded v3 headers with a legacy region of all entries at offset 0, which
+	// has no trailer. hdrblobImport takes all entries for it.
+	if regionTag == RPMTAG_HEADERIMAGE && einfo.Offset == 0 {
+		blob.ril = blob.il
+		blob.rdl = blob.dl
+		blob.regionTag = regionTag
+		return nil
 	}
 
 	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/header.c#L1842
-	var trailer entryInfo
 	regionEnd := blob.dataStart + einfo.Offset
 	if regionEnd > int32(len(data)) || regionEnd+REGION_TAG_COUNT > int32(len(data)) {
-		return xerrors.New("invalid region offset")
-	}
-
-	if err := binary.Read(bytes.NewReader(data[regionEnd:regionEnd+REGION_TAG_COUNT]), binary.LittleEndian, &trailer); err != nil {
-		return xerrors.Errorf("failed to parse trailer: %w", err)
+		return corruptHeader(einfo.Tag, 8, xerrors.New("invalid region offset"))
 	}
+	einfo = entryInfoAt(data, int(regionEnd))
 	blob.rdl = regionEnd + REGION_TAG_COUNT - blob.dataStart
 
+	// some old packages have HEADERIMAGE in the trailer of the signature region
 	if regionTag == RPMTAG_HEADERSIGNATURES && einfo.Tag == RPMTAG_HEADERIMAGE {
 		einfo.Tag = RPMTAG_HEADERSIGNATURES
 	}
 
 	if !(einfo.Tag == regionTag && einfo.Type == REGION_TAG_TYPE && einfo.Count == uint32(REGION_TAG_COUNT)) {
-		return xerrors.New("invalid region trailer")
+		return corruptHeader(regionTag, regionEnd, xerrors.New("invalid region trailer"))
 	}
 
-	einfo = ei2h(trailer)
 	einfo.Offset = -einfo.Offset
 	blob.ril = einfo.Offset / int32(unsafe.Sizeof(blob.peList[0]))
 	if (einfo.Offset%REGION_TAG_COUNT) != 0 || hdrchkRange(blob.il, blob.ril) || hdrchkRange(blob.dl, blob.rdl) {
-		return xerrors.Errorf("invalid region size, region %d", regionTag)
+		return corruptHeader(regionTag, regionEnd, xerrors.Errorf("invalid region size, region %d", regionTag))
 	}
 
 	blob.regionTag = regionTag
@@ -322,42 +378,32 @@ func hdrchkRange(dl, offset int32) bool {
 	return offset < 0 || offset > dl
 }
 
-// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/header_internal.h#L42
-func ei2h(pe entryInfo) entryInfo {
-	return entryInfo{
-		Type:   HtonlU(pe.Type),
-		Count:  HtonlU(pe.Count),
-		Offset: Htonl(pe.Offset),
-		Tag:    Htonl(pe.Tag),
-	}
-}
-
 // ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/header.c#L498
 func regionSwab(data []byte, peList []entryInfo, dl, dataStart, dataEnd int32) ([]indexEntry, int32, error) {
 	indexEntries := make([]indexEntry, len(peList))
 	for i := 0; i < len(peList); i++ {
-		pe := peList[i]
-		indexEntry := indexEntry{Info: ei2h(pe)}
+		indexEntry := indexEntry{Info: peList[i]}
 
 		start := dataStart + indexEntry.Info.Offset
 		if start >= dataEnd {
-			return nil, 0, xerrors.New("invalid data offset")
+			return nil, 0, corruptHeader(indexEntry.Info.Tag, start, xerrors.New("invalid data offset"))
 		}
 
 		if i < len(peList)-1 && typeSizes[indexEntry.Info.Type] == -1 {
-			indexEntry.Length = int(Htonl(peList[i+1].Offset) - indexEntry.Info.Offset)
+			indexEntry.Length = int(peList[i+1].Offset - indexEntry.Info.Offset)
 		} else {
 			indexEntry.Length = dataLength(data, indexEntry.Info.Type, indexEntry.Info.Count, start, dataEnd)
 		}
 		if indexEntry.Length < 0 {
-			return nil, 0, xerrors.New("invalid data length")
+			return nil, 0, corruptHeader(indexEntry.Info.Tag, start, xerrors.New("invalid data length"))
 		}
 
 		end := int(start) + indexEntry.Length
 		if start > int32(len(data)) || end > len(data) {
-			return nil, 0, xerrors.New("invalid data length")
+			return nil, 0, corruptHeader(indexEntry.Info.Tag, start, xerrors.New("invalid data length"))
 		}
 		indexEntry.Data = data[start:end]
+		indexEntry.DataOffset = start
 		indexEntries[i] = indexEntry
 
 		dl += int32(indexEntry.Length + alignDiff(indexEntry.Info.Type, uint32(dl)))
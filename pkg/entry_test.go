@@ -1,9 +1,12 @@
 package rpmdb
 
 import (
+	"encoding/binary"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
 )
 
 //
@@ -17,6 +20,11 @@ func Test_headerImport(t *testing.T) {
 			name: "negative il",
 			data: []byte{0xe3, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30},
 		},
+		{
+			// must not allocate the entry infos before checking the size
+			name: "il beyond the blob",
+			data: []byte{0x7f, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x10, 0x00},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -26,3 +34,247 @@ func Test_headerImport(t *testing.T) {
 		})
 	}
 }
+
+func Test_headerImport_region(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *headerBuilder
+		wantErr bool
+	}{
+		{
+			name:    "immutable region",
+			builder: &headerBuilder{},
+		},
+		{
+			// rpm 4.0 merged the signature tags into the header after the region on install
+			name:    "one entry after the region",
+			builder: &headerBuilder{dribble: 1},
+		},
+		{
+			name:    "entries after the region",
+			builder: &headerBuilder{dribble: 2},
+		},
+		{
+			// some old packages have HEADERIMAGE in the trailer of the signature region
+			name:    "signature region with image trailer",
+			builder: &headerBuilder{regionTag: RPMTAG_HEADERSIGNATURES, trailerTag: RPMTAG_HEADERIMAGE},
+		},
+		{
+			name:    "invalid trailer",
+			builder: &headerBuilder{trailerTag: RPMTAG_HEADERIMAGE},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob := tt.builder.
+				addString(RPMTAG_NAME, "bash").
+				addString(RPMTAG_VERSION, "5.2").
+				addStringArray(RPMTAG_PROVIDENAME, "bash", "sh").
+				addBin(RPMTAG_SIGMD5, []byte{0xde, 0xad, 0xbe, 0xef}).
+				addInt32(1128, 1000). // RPMTAG_INSTALLTID
+				bytes()
+			indexEntries, err := headerImport(blob)
+			if tt.wantErr {
+				var headerErr *ErrCorruptHeader
+				assert.True(t, xerrors.As(err, &headerErr), err)
+				return
+			}
+			require.NoError(t, err)
+
+			pkg, err := getNEVRA(indexEntries)
+			require.NoError(t, err)
+			assert.Equal(t, "bash", pkg.Name)
+			assert.Equal(t, []string{"bash", "sh"}, pkg.Provides)
+			assert.Equal(t, "deadbeef", pkg.SigMD5)
+		})
+	}
+}
+
+// testLegacyHeader returns a header with an old file list like the ones of rpm < 4 in the layout of b
+func testLegacyHeader(b *headerBuilder, name string) []byte {
+	return b.
+		addStringArray(RPMTAG_HEADERI18NTABLE, "C").
+		addString(RPMTAG_NAME, name).
+		addString(RPMTAG_VERSION, "2.05").
+		addString(RPMTAG_RELEASE, "8").
+		addString(RPMTAG_ARCH, "i386").
+		addStringArray(RPMTAG_OLDFILENAMES, "/bin/"+name, "/bin/sh", "/etc/skel/.bashrc").
+		addString(RPMTAG_SOURCERPM, name+"-2.05-8.src.rpm").
+		addStringArray(RPMTAG_PROVIDENAME, "sh").
+		addBin(RPMTAG_SIGMD5, []byte{0xde, 0xad, 0xbe, 0xef}).
+		bytes()
+}
+
+func Test_headerImport_legacy(t *testing.T) {
+	tests := []struct {
+		name        string
+		builder     *headerBuilder
+		wantLegacy  bool
+		wantProvide []string
+	}{
+		{
+			name:        "immutable region",
+			builder:     &headerBuilder{},
+			wantProvide: []string{"sh"},
+		},
+		{
+			// rpm 4.0 merged the signature tags into the header after the region on install
+			name:        "signature tags after the region",
+			builder:     &headerBuilder{dribble: 1},
+			wantProvide: []string{"sh"},
+		},
+		{
+			name:        "v3 header without region",
+			builder:     &headerBuilder{noRegion: true},
+			wantLegacy:  true,
+			wantProvide: []string{"sh", "bash"},
+		},
+		{
+			name:        "legacy region",
+			builder:     &headerBuilder{regionTag: RPMTAG_HEADERIMAGE},
+			wantLegacy:  true,
+			wantProvide: []string{"sh", "bash"},
+		},
+		{
+			name:        "legacy region without trailer",
+			builder:     &headerBuilder{noTrailer: true},
+			wantLegacy:  true,
+			wantProvide: []string{"sh", "bash"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexEntries, err := headerImport(testLegacyHeader(tt.builder, "bash"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantLegacy, headerIsLegacy(indexEntries))
+
+			pkg, err := getNEVRA(indexEntries)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantLegacy, pkg.Legacy)
+			assert.Equal(t, "bash", pkg.Name)
+			assert.Equal(t, "deadbeef", pkg.SigMD5)
+			assert.Equal(t, tt.wantProvide, pkg.Provides)
+
+			fileNames, err := pkg.InstalledFileNames()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"/bin/bash", "/bin/sh", "/etc/skel/.bashrc"}, fileNames)
+			assert.Equal(t, []string{"/bin/", "/etc/skel/"}, pkg.DirNames)
+		})
+	}
+}
+
+// headerBuilder assembles an on-disk header blob with an immutable region, as stored in the rpmdb
+type headerBuilder struct {
+	entries []entryInfo
+	data    []byte
+
+	// regionTag defaults to RPMTAG_HEADERIMMUTABLE
+	regionTag int32
+	// noRegion writes an original v3 header without a region
+	noRegion bool
+	// noTrailer writes a legacy HEADERIMAGE region of all entries at offset 0 like rpm 4.0
+	noTrailer bool
+	// dribble is the number of the last entries, which are written after the region
+	dribble int
+	// trailerTag is the tag of the region trailer, regionTag by default
+	trailerTag int32
+}
+
+func (b *headerBuilder) add(tag int32, t uint32, count uint32, data []byte) *headerBuilder {
+	for len(b.data)%typeAlign[t] != 0 {
+		b.data = append(b.data, 0)
+	}
+	b.entries = append(b.entries, entryInfo{Tag: tag, Type: t, Offset: int32(len(b.data)), Count: count})
+	b.data = append(b.data, data...)
+	return b
+}
+
+func (b *headerBuilder) addString(tag int32, value string) *headerBuilder {
+	return b.add(tag, RPM_STRING_TYPE, 1, append([]byte(value), 0))
+}
+
+func (b *headerBuilder) addStringArray(tag int32, values ...string) *headerBuilder {
+	var data []byte
+	for _, v := range values {
+		data = append(append(data, v...), 0)
+	}
+	return b.add(tag, RPM_STRING_ARRAY_TYPE, uint32(len(values)), data)
+}
+
+func (b *headerBuilder) addInt32(tag int32, values ...int32) *headerBuilder {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(data[4*i:], uint32(v))
+	}
+	return b.add(tag, RPM_INT32_TYPE, uint32(len(values)), data)
+}
+
+func (b *headerBuilder) addInt16(tag int32, values ...uint16) *headerBuilder {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint16(data[2*i:], v)
+	}
+	return b.add(tag, RPM_INT16_TYPE, uint32(len(values)), data)
+}
+
+func (b *headerBuilder) addInt64(tag int32, values ...int64) *headerBuilder {
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint64(data[8*i:], uint64(v))
+	}
+	return b.add(tag, RPM_INT64_TYPE, uint32(len(values)), data)
+}
+
+func (b *headerBuilder) addBin(tag int32, data []byte) *headerBuilder {
+	return b.add(tag, RPM_BIN_TYPE, uint32(len(data)), data)
+}
+
+func (b *headerBuilder) bytes() []byte {
+	regionTag := b.regionTag
+	if regionTag == 0 {
+		regionTag = RPMTAG_HEADERIMMUTABLE
+	}
+	entries := append([]entryInfo{}, b.entries...)
+	data := append([]byte{}, b.data...)
+
+	switch {
+	case b.noRegion:
+	case b.noTrailer:
+		entries = append([]entryInfo{{Tag: RPMTAG_HEADERIMAGE, Type: RPM_BIN_TYPE, Offset: 0, Count: 16}}, entries...)
+	default:
+		// the trailer follows the data of the region, the data of the dribble entries follows it
+		ril := len(entries) - b.dribble + 1
+		trailerOffset := len(data)
+		if b.dribble > 0 {
+			trailerOffset = int(entries[ril-1].Offset)
+		}
+		trailerTag := b.trailerTag
+		if trailerTag == 0 {
+			trailerTag = regionTag
+		}
+		trailer := appendEntryInfo(nil, entryInfo{Tag: trailerTag, Type: RPM_BIN_TYPE, Offset: int32(-ril * 16), Count: 16})
+		data = append(append(append([]byte{}, data[:trailerOffset]...), trailer...), data[trailerOffset:]...)
+		for i := ril - 1; i < len(entries); i++ {
+			entries[i].Offset += 16
+		}
+		entries = append([]entryInfo{{Tag: regionTag, Type: RPM_BIN_TYPE, Offset: int32(trailerOffset), Count: 16}}, entries...)
+	}
+
+	blob := make([]byte, 8)
+	binary.BigEndian.PutUint32(blob[0:], uint32(len(entries)))
+	binary.BigEndian.PutUint32(blob[4:], uint32(len(data)))
+	for _, e := range entries {
+		blob = appendEntryInfo(blob, e)
+	}
+	return append(blob, data...)
+}
+
+func appendEntryInfo(b []byte, e entryInfo) []byte {
+	var buf [16]byte
+	binary.BigEndian.PutUint32(buf[0:], uint32(e.Tag))
+	binary.BigEndian.PutUint32(buf[4:], e.Type)
+	binary.BigEndian.PutUint32(buf[8:], uint32(e.Offset))
+	binary.BigEndian.PutUint32(buf[12:], e.Count)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,29 @@
+package rpmdb
+
+import (
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"golang.org/x/xerrors"
+)
+
+// The errors of all backends, to be checked with errors.Is and errors.As
+var (
+	ErrNotRPMDB        = dbi.ErrNotRPMDB
+	ErrEncrypted       = dbi.ErrEncrypted
+	ErrPackageNotFound = dbi.ErrPackageNotFound
+	ErrHeaderNotFound  = dbi.ErrHeaderNotFound
+	ErrIndexNotFound   = dbi.ErrIndexNotFound
+)
+
+type (
+	ErrCorruptHeader = dbi.ErrCorruptHeader
+	ErrCorruptPage   = dbi.ErrCorruptPage
+)
+
+var (
+	// ErrLocked is returned when a transaction of rpm holds the lock longer than the
+	// timeout of WithLock
+	ErrLocked = xerrors.New("rpmdb is locked by a transaction")
+	// ErrConcurrentModification is returned when the database opened WithLock is modified
+	// during every attempt to read it
+	ErrConcurrentModification = xerrors.New("rpmdb was modified while reading it")
+)
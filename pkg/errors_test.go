@@ -0,0 +1,136 @@
+package rpmdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestOpen_NotRPMDB(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "empty file",
+			data: nil,
+		},
+		{
+			name: "short file",
+			data: []byte("rpm"),
+		},
+		{
+			name: "text file",
+			data: bytes.Repeat([]byte("not an rpmdb\n"), 100),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "Packages")
+			require.NoError(t, os.WriteFile(path, tt.data, 0644))
+
+			_, err := Open(path)
+			require.Error(t, err)
+			assert.True(t, xerrors.Is(err, ErrNotRPMDB), err)
+		})
+	}
+}
+
+func TestOpen_Encrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Packages")
+	createBDBHash(t, path, 4096, testBDBPair{key: []byte{1, 0, 0, 0}, value: []byte("header")})
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	// the encryption algorithm of the metadata page, 1 is DB_ENCRYPT_AES
+	data[24] = 1
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	_, err = Open(path)
+	require.Error(t, err)
+	assert.True(t, xerrors.Is(err, ErrEncrypted), err)
+	assert.False(t, xerrors.Is(err, ErrNotRPMDB), err)
+}
+
+func TestRpmDB_CorruptHeader(t *testing.T) {
+	header := (&headerBuilder{}).
+		addInt32(RPMTAG_NAME, 1).
+		addString(RPMTAG_VERSION, "1.0").
+		addString(RPMTAG_RELEASE, "1").
+		bytes()
+	db, err := Open(createSQLite3DB(t, header))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ListPackages()
+	require.Error(t, err)
+
+	var headerErr *ErrCorruptHeader
+	require.True(t, xerrors.As(err, &headerErr), err)
+	assert.Equal(t, int32(RPMTAG_NAME), headerErr.Tag)
+	assert.NotZero(t, headerErr.Offset)
+}
+
+func TestRpmDB_CorruptPage(t *testing.T) {
+	tests := []struct {
+		name string
+		file func(t *testing.T) string
+	}{
+		{
+			name: "BerkeleyDB",
+			file: func(t *testing.T) string {
+				path := createLargeBDB(t, 50)
+				// keep the metadata, the hash page and the first overflow page of 16k each,
+				// the rest of the overflow pages are missing
+				require.NoError(t, os.Truncate(path, 3*16384))
+				return path
+			},
+		},
+		{
+			name: "SQLite3",
+			file: func(t *testing.T) string {
+				path := createSQLite3DB(t, testIndexedHeader("bash", "5.2", 1000, "bash"))
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				// keep the schema, the first page
+				pageSize := binary.BigEndian.Uint16(data[16:])
+				require.NoError(t, os.WriteFile(path, data[:pageSize], 0644))
+				return path
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(tt.file(t))
+			require.NoError(t, err)
+			defer db.Close()
+
+			_, err = db.ListPackages()
+			require.Error(t, err)
+
+			var pageErr *ErrCorruptPage
+			require.True(t, xerrors.As(err, &pageErr), err)
+			assert.NotZero(t, pageErr.PageNo)
+		})
+	}
+}
+
+func TestRpmDB_PackageNotFound(t *testing.T) {
+	db, err := Open(createSQLite3DB(t, testIndexedHeader("bash", "5.2", 1000, "bash")))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Package("zsh")
+	assert.True(t, xerrors.Is(err, ErrPackageNotFound), err)
+
+	_, err = db.PackageByInstance(context.Background(), 100)
+	assert.True(t, xerrors.Is(err, ErrPackageNotFound), err)
+	assert.True(t, xerrors.Is(err, ErrHeaderNotFound), err)
+}
@@ -1,5 +1,18 @@
 package rpmdb
 
+import (
+	"crypto"
+	_ "crypto/md5"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/hex"
+	"io"
+	"io/fs"
+
+	"golang.org/x/xerrors"
+)
+
 // source: https://github.com/rpm-software-management/rpm/blob/0b75075a8d006c8f792d33a57eae7da6b66a4591/rpmio/rpmpgp.h#L241-L275
 
 type DigestAlgorithm int32
@@ -44,3 +57,58 @@ func (d DigestAlgorithm) String() string {
 		return "unknown-digest-algorithm"
 	}
 }
+
+// Hash returns the Go hash function of the digest algorithm
+func (d DigestAlgorithm) Hash() (crypto.Hash, bool) {
+	switch d {
+	case PGPHASHALGO_MD5:
+		return crypto.MD5, true
+	case PGPHASHALGO_SHA1:
+		return crypto.SHA1, true
+	case PGPHASHALGO_SHA224:
+		return crypto.SHA224, true
+	case PGPHASHALGO_SHA256:
+		return crypto.SHA256, true
+	case PGPHASHALGO_SHA384:
+		return crypto.SHA384, true
+	case PGPHASHALGO_SHA512:
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+// FileDigestAlgorithm returns the algorithm of FileDigests. rpm defaults to md5 if the header has none.
+func (p *PackageInfo) FileDigestAlgorithm() DigestAlgorithm {
+	// an error of the lazily decoded files is returned by InstalledFiles
+	_ = p.loadFiles()
+	if p.DigestAlgorithm == 0 {
+		return PGPHASHALGO_MD5
+	}
+	return p.DigestAlgorithm
+}
+
+// HashFile returns the hex encoded digest of a file, as stored in RPMTAG_FILEDIGESTS
+func HashFile(fsys fs.FS, name string, algo DigestAlgorithm) (string, error) {
+	hash, ok := algo.Hash()
+	if !ok || !hash.Available() {
+		return "", xerrors.Errorf("unsupported digest algorithm: %s", algo)
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", xerrors.Errorf("failed to open %s: %w", name, err)
+	}
+	defer f.Close()
+
+	h := hash.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", xerrors.Errorf("failed to read %s: %w", name, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashFile returns the digest of a file with the file digest algorithm of the package,
+// so that it can be compared to the packaged FileDigests.
+func (p *PackageInfo) HashFile(fsys fs.FS, name string) (string, error) {
+	return HashFile(fsys, name, p.FileDigestAlgorithm())
+}
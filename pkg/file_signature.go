@@ -0,0 +1,246 @@
+package rpmdb
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+
+	"golang.org/x/xerrors"
+)
+
+// source: https://github.com/mimizohar/ima-evm-utils/blob/v1.4/src/imaevm.h
+const (
+	EVM_IMA_XATTR_DIGSIG = 0x03
+	DIGSIG_VERSION_2     = 2
+
+	// type (1) + version (1) + hash algo (1) + key id (4) + signature size (2)
+	imaSignatureHeaderSize = 9
+)
+
+// IMAHashAlgorithm is the kernel hash algorithm identifier stored in an IMA signature header
+type IMAHashAlgorithm uint8
+
+// source: https://github.com/torvalds/linux/blob/v5.15/include/uapi/linux/hash_info.h
+const (
+	HASH_ALGO_MD4 IMAHashAlgorithm = iota
+	HASH_ALGO_MD5
+	HASH_ALGO_SHA1
+	HASH_ALGO_RIPE_MD_160
+	HASH_ALGO_SHA256
+	HASH_ALGO_SHA384
+	HASH_ALGO_SHA512
+	HASH_ALGO_SHA224
+	HASH_ALGO_RIPE_MD_128
+	HASH_ALGO_RIPE_MD_256
+	HASH_ALGO_RIPE_MD_320
+	HASH_ALGO_WP_256
+	HASH_ALGO_WP_384
+	HASH_ALGO_WP_512
+	HASH_ALGO_TGR_128
+	HASH_ALGO_TGR_160
+	HASH_ALGO_TGR_192
+	HASH_ALGO_SM3_256
+	HASH_ALGO_STREEBOG_256
+	HASH_ALGO_STREEBOG_512
+)
+
+var imaHashAlgorithmNames = []string{
+	"md4", "md5", "sha1", "rmd160", "sha256", "sha384", "sha512", "sha224",
+	"rmd128", "rmd256", "rmd320", "wp256", "wp384", "wp512", "tgr128", "tgr160",
+	"tgr192", "sm3-256", "streebog256", "streebog512",
+}
+
+func (a IMAHashAlgorithm) String() string {
+	if int(a) < len(imaHashAlgorithmNames) {
+		return imaHashAlgorithmNames[a]
+	}
+	return "unknown-hash-algorithm"
+}
+
+// VerityHashAlgorithm is the fs-verity hash algorithm (RPMTAG_VERITYSIGNATUREALGO)
+type VerityHashAlgorithm int32
+
+// source: https://github.com/torvalds/linux/blob/v5.15/include/uapi/linux/fsverity.h
+const (
+	FS_VERITY_HASH_ALG_SHA256 VerityHashAlgorithm = 1
+	FS_VERITY_HASH_ALG_SHA512 VerityHashAlgorithm = 2
+)
+
+func (a VerityHashAlgorithm) String() string {
+	switch a {
+	case FS_VERITY_HASH_ALG_SHA256:
+		return "sha256"
+	case FS_VERITY_HASH_ALG_SHA512:
+		return "sha512"
+	default:
+		return "unknown-verity-algorithm"
+	}
+}
+
+// IMASignature is a decoded "signature_v2_hdr" as written to the security.ima xattr
+type IMASignature struct {
+	Version       uint8
+	HashAlgorithm IMAHashAlgorithm
+	KeyID         string
+	Signature     []byte
+}
+
+// ParseIMASignature decodes a hex encoded file signature from RPMTAG_FILESIGNATURES.
+// rpm prefixes the signature with the EVM_IMA_XATTR_DIGSIG type byte.
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.17.0-release/sign/rpmsignfiles.c
+func ParseIMASignature(signature string) (*IMASignature, error) {
+	data, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid IMA signature encoding: %w", err)
+	}
+	if len(data) < imaSignatureHeaderSize {
+		return nil, xerrors.Errorf("IMA signature too short: %d bytes", len(data))
+	}
+	if data[0] != EVM_IMA_XATTR_DIGSIG {
+		return nil, xerrors.Errorf("unexpected IMA xattr type: %d", data[0])
+	}
+	if data[1] != DIGSIG_VERSION_2 {
+		return nil, xerrors.Errorf("unsupported IMA signature version: %d", data[1])
+	}
+
+	size := int(binary.BigEndian.Uint16(data[7:9]))
+	if size != len(data)-imaSignatureHeaderSize {
+		return nil, xerrors.Errorf("IMA signature size mismatch: header %d, actual %d", size, len(data)-imaSignatureHeaderSize)
+	}
+
+	return &IMASignature{
+		Version:       data[1],
+		HashAlgorithm: IMAHashAlgorithm(data[2]),
+		KeyID:         hex.EncodeToString(data[3:7]),
+		Signature:     data[imaSignatureHeaderSize:],
+	}, nil
+}
+
+// VeritySignature holds the metadata of the PKCS#7 envelope of an fs-verity file signature
+type VeritySignature struct {
+	DigestAlgorithm    string
+	SignatureAlgorithm string
+	Issuer             string
+	SerialNumber       *big.Int
+	SubjectKeyID       []byte
+	Signature          []byte
+}
+
+// ref. https://datatracker.ietf.org/doc/html/rfc5652#section-3
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// ref. https://datatracker.ietf.org/doc/html/rfc5652#section-5.1
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+// ref. https://datatracker.ietf.org/doc/html/rfc5652#section-5.3
+type pkcs7SignerInfo struct {
+	Version            int
+	SignerIdentifier   asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7IssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+var (
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+	oidNames = map[string]string{
+		"1.3.14.3.2.26":          "sha1",
+		"2.16.840.1.101.3.4.2.1": "sha256",
+		"2.16.840.1.101.3.4.2.2": "sha384",
+		"2.16.840.1.101.3.4.2.3": "sha512",
+		"2.16.840.1.101.3.4.2.4": "sha224",
+		"1.2.840.113549.1.1.1":   "rsaEncryption",
+		"1.2.840.113549.1.1.5":   "sha1WithRSAEncryption",
+		"1.2.840.113549.1.1.11":  "sha256WithRSAEncryption",
+		"1.2.840.113549.1.1.12":  "sha384WithRSAEncryption",
+		"1.2.840.113549.1.1.13":  "sha512WithRSAEncryption",
+		"1.2.840.10045.2.1":      "ecPublicKey",
+		"1.2.840.10045.4.3.2":    "ecdsa-with-SHA256",
+		"1.2.840.10045.4.3.3":    "ecdsa-with-SHA384",
+		"1.2.840.10045.4.3.4":    "ecdsa-with-SHA512",
+		"1.3.101.112":            "ed25519",
+	}
+)
+
+func oidName(oid asn1.ObjectIdentifier) string {
+	if name, ok := oidNames[oid.String()]; ok {
+		return name
+	}
+	return oid.String()
+}
+
+// ParseVeritySignature decodes a base64 encoded fs-verity signature from RPMTAG_VERITYSIGNATURES.
+// Only the envelope metadata of the first signer is extracted, the signature is not verified.
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.17.0-release/sign/rpmsignverity.c
+func ParseVeritySignature(signature string) (*VeritySignature, error) {
+	data, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid verity signature encoding: %w", err)
+	}
+
+	var contentInfo pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(data, &contentInfo); err != nil {
+		return nil, xerrors.Errorf("failed to parse PKCS#7 content info: %w", err)
+	}
+	if !contentInfo.ContentType.Equal(oidSignedData) {
+		return nil, xerrors.Errorf("unexpected PKCS#7 content type: %s", contentInfo.ContentType)
+	}
+
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, xerrors.Errorf("failed to parse PKCS#7 signed data: %w", err)
+	}
+	if len(signedData.SignerInfos) == 0 {
+		return nil, xerrors.New("PKCS#7 signed data has no signer")
+	}
+
+	signer := signedData.SignerInfos[0]
+	sig := &VeritySignature{
+		DigestAlgorithm:    oidName(signer.DigestAlgorithm.Algorithm),
+		SignatureAlgorithm: oidName(signer.SignatureAlgorithm.Algorithm),
+		Signature:          signer.Signature,
+	}
+
+	switch {
+	case signer.SignerIdentifier.Class == asn1.ClassUniversal && signer.SignerIdentifier.Tag == asn1.TagSequence:
+		var ias pkcs7IssuerAndSerialNumber
+		if _, err := asn1.Unmarshal(signer.SignerIdentifier.FullBytes, &ias); err != nil {
+			return nil, xerrors.Errorf("failed to parse PKCS#7 issuer: %w", err)
+		}
+		var issuer pkix.RDNSequence
+		if _, err := asn1.Unmarshal(ias.Issuer.FullBytes, &issuer); err != nil {
+			return nil, xerrors.Errorf("failed to parse PKCS#7 issuer name: %w", err)
+		}
+		var name pkix.Name
+		name.FillFromRDNSequence(&issuer)
+		sig.Issuer = name.String()
+		sig.SerialNumber = ias.SerialNumber
+	case signer.SignerIdentifier.Class == asn1.ClassContextSpecific && signer.SignerIdentifier.Tag == 0:
+		sig.SubjectKeyID = signer.SignerIdentifier.Bytes
+	default:
+		return nil, xerrors.Errorf("unexpected PKCS#7 signer identifier (tag %d)", signer.SignerIdentifier.Tag)
+	}
+
+	return sig, nil
+}
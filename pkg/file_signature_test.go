@@ -0,0 +1,188 @@
+package rpmdb
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIMASignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+		want      *IMASignature
+		wantErr   string
+	}{
+		{
+			name:      "sha256 signature",
+			signature: "030204a1b2c3d40004deadbeef",
+			want: &IMASignature{
+				Version:       DIGSIG_VERSION_2,
+				HashAlgorithm: HASH_ALGO_SHA256,
+				KeyID:         "a1b2c3d4",
+				Signature:     []byte{0xde, 0xad, 0xbe, 0xef},
+			},
+		},
+		{
+			name:      "not hex",
+			signature: "zz",
+			wantErr:   "invalid IMA signature encoding",
+		},
+		{
+			name:      "too short",
+			signature: "0302",
+			wantErr:   "IMA signature too short",
+		},
+		{
+			name:      "wrong xattr type",
+			signature: "040204a1b2c3d40004deadbeef",
+			wantErr:   "unexpected IMA xattr type",
+		},
+		{
+			name:      "wrong version",
+			signature: "030104a1b2c3d40004deadbeef",
+			wantErr:   "unsupported IMA signature version",
+		},
+		{
+			name:      "size mismatch",
+			signature: "030204a1b2c3d40010deadbeef",
+			wantErr:   "IMA signature size mismatch",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseIMASignature(tt.signature)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, "sha256", got.HashAlgorithm.String())
+		})
+	}
+}
+
+func testVeritySignature(t *testing.T, signerIdentifier asn1.RawValue) string {
+	t.Helper()
+
+	type signerInfo struct {
+		Version            int
+		SignerIdentifier   asn1.RawValue
+		DigestAlgorithm    pkix.AlgorithmIdentifier
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Signature          []byte
+	}
+	type signedData struct {
+		Version          int
+		DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		SignerInfos      []signerInfo `asn1:"set"`
+	}
+	type contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}
+
+	sha256 := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}}
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256},
+		ContentInfo:      struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SignerIdentifier:   signerIdentifier,
+			DigestAlgorithm:    sha256,
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}},
+			Signature:          []byte{0x01, 0x02, 0x03},
+		}},
+	}
+	sdBytes, err := asn1.Marshal(sd)
+	require.NoError(t, err)
+
+	ciBytes, err := asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdBytes},
+	})
+	require.NoError(t, err)
+
+	return base64.StdEncoding.EncodeToString(ciBytes)
+}
+
+func TestParseVeritySignature(t *testing.T) {
+	issuer, err := asn1.Marshal(pkix.Name{CommonName: "Fedora IMA CA", Organization: []string{"Fedora"}}.ToRDNSequence())
+	require.NoError(t, err)
+	issuerAndSerial, err := asn1.Marshal(struct {
+		Issuer       asn1.RawValue
+		SerialNumber *big.Int
+	}{asn1.RawValue{FullBytes: issuer}, big.NewInt(4711)})
+	require.NoError(t, err)
+
+	t.Run("issuer and serial number", func(t *testing.T) {
+		got, err := ParseVeritySignature(testVeritySignature(t, asn1.RawValue{FullBytes: issuerAndSerial}))
+		require.NoError(t, err)
+		assert.Equal(t, &VeritySignature{
+			DigestAlgorithm:    "sha256",
+			SignatureAlgorithm: "rsaEncryption",
+			Issuer:             "CN=Fedora IMA CA,O=Fedora",
+			SerialNumber:       big.NewInt(4711),
+			Signature:          []byte{0x01, 0x02, 0x03},
+		}, got)
+	})
+
+	t.Run("subject key identifier", func(t *testing.T) {
+		ski := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: []byte{0xca, 0xfe}}
+		got, err := ParseVeritySignature(testVeritySignature(t, ski))
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xca, 0xfe}, got.SubjectKeyID)
+		assert.Empty(t, got.Issuer)
+	})
+
+	t.Run("not base64", func(t *testing.T) {
+		_, err := ParseVeritySignature("!!")
+		assert.Error(t, err)
+	})
+
+	t.Run("not signed data", func(t *testing.T) {
+		data, err := asn1.Marshal(struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}})
+		require.NoError(t, err)
+		_, err = ParseVeritySignature(base64.StdEncoding.EncodeToString(data))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unexpected PKCS#7 content type")
+	})
+}
+
+func TestFileSignatures(t *testing.T) {
+	blob := (&headerBuilder{}).
+		addString(RPMTAG_NAME, "signed").
+		addStringArray(RPMTAG_BASENAMES, "a", "b").
+		addStringArray(RPMTAG_DIRNAMES, "/usr/bin/").
+		addInt32(RPMTAG_DIRINDEXES, 0, 0).
+		addStringArray(RPMTAG_FILESIGNATURES, "030204a1b2c3d40004deadbeef", "").
+		addInt32(RPMTAG_FILESIGNATURELENGTH, 13).
+		addStringArray(RPMTAG_VERITYSIGNATURES, "", "MAA=").
+		addInt32(RPMTAG_VERITYSIGNATUREALGO, int32(FS_VERITY_HASH_ALG_SHA256)).
+		bytes()
+
+	indexEntries, err := headerImport(blob)
+	require.NoError(t, err)
+	pkg, err := getNEVRA(indexEntries)
+	require.NoError(t, err)
+
+	assert.Equal(t, 13, pkg.FileSignatureLength)
+	assert.Equal(t, FS_VERITY_HASH_ALG_SHA256, pkg.VeritySignatureAlgo)
+
+	files, err := pkg.InstalledFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, "030204a1b2c3d40004deadbeef", files[0].IMASignature)
+	assert.Empty(t, files[0].VeritySignature)
+	assert.Empty(t, files[1].IMASignature)
+	assert.Equal(t, "MAA=", files[1].VeritySignature)
+}
@@ -0,0 +1,44 @@
+package rpmdb
+
+// FileState is the install state of a file (RPMTAG_FILESTATES)
+type FileState int8
+
+// source: https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmfiles.h
+const (
+	RPMFILE_STATE_MISSING      FileState = -1 /* used for unavailable data */
+	RPMFILE_STATE_NORMAL       FileState = 0
+	RPMFILE_STATE_REPLACED     FileState = 1
+	RPMFILE_STATE_NOTINSTALLED FileState = 2
+	RPMFILE_STATE_NETSHARED    FileState = 3
+	RPMFILE_STATE_WRONGCOLOR   FileState = 4
+)
+
+// source: https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/query.c
+func (s FileState) String() string {
+	switch s {
+	case RPMFILE_STATE_MISSING:
+		return "missing"
+	case RPMFILE_STATE_NORMAL:
+		return "normal"
+	case RPMFILE_STATE_REPLACED:
+		return "replaced"
+	case RPMFILE_STATE_NOTINSTALLED:
+		return "not installed"
+	case RPMFILE_STATE_NETSHARED:
+		return "net shared"
+	case RPMFILE_STATE_WRONGCOLOR:
+		return "wrong color"
+	default:
+		return "(unknown)"
+	}
+}
+
+// Present reports whether the file was written to disk when the package was installed.
+// Replaced files are present, but their content belongs to the package which replaced them.
+func (s FileState) Present() bool {
+	switch s {
+	case RPMFILE_STATE_NOTINSTALLED, RPMFILE_STATE_NETSHARED, RPMFILE_STATE_WRONGCOLOR:
+		return false
+	}
+	return true
+}
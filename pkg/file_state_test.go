@@ -0,0 +1,61 @@
+package rpmdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStates(t *testing.T) {
+	states := []byte{
+		byte(RPMFILE_STATE_NORMAL),
+		byte(RPMFILE_STATE_REPLACED),
+		byte(RPMFILE_STATE_NOTINSTALLED),
+		byte(RPMFILE_STATE_NETSHARED),
+		byte(RPMFILE_STATE_WRONGCOLOR),
+	}
+	blob := (&headerBuilder{}).
+		addString(RPMTAG_NAME, "states").
+		addStringArray(RPMTAG_BASENAMES, "normal", "replaced", "notinstalled", "netshared", "wrongcolor").
+		addStringArray(RPMTAG_DIRNAMES, "/usr/share/doc/states/").
+		addInt32(RPMTAG_DIRINDEXES, 0, 0, 0, 0, 0).
+		add(RPMTAG_FILESTATES, RPM_CHAR_TYPE, uint32(len(states)), states).
+		addInt32(RPMTAG_INSTALLCOLOR, 2).
+		addStringArray(RPMTAG_INSTPREFIXES, "/opt/states").
+		bytes()
+
+	indexEntries, err := headerImport(blob)
+	require.NoError(t, err)
+	pkg, err := getNEVRA(indexEntries)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, pkg.InstallColor)
+	assert.Equal(t, []string{"/opt/states"}, pkg.InstPrefixes)
+
+	files, err := pkg.InstalledFiles()
+	require.NoError(t, err)
+	var got []string
+	for _, f := range files {
+		got = append(got, f.State.String())
+	}
+	assert.Equal(t, []string{"normal", "replaced", "not installed", "net shared", "wrong color"}, got)
+
+	present, err := pkg.PresentFiles()
+	require.NoError(t, err)
+	var presentNames []string
+	for _, f := range present {
+		presentNames = append(presentNames, f.Path)
+	}
+	assert.Equal(t, []string{"/usr/share/doc/states/normal", "/usr/share/doc/states/replaced"}, presentNames)
+
+	instFileNames, err := pkg.InstFileNames()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/usr/share/doc/states/normal", "/usr/share/doc/states/netshared"}, instFileNames)
+
+	// headers without RPMTAG_FILESTATES
+	pkg.FileStates = nil
+	present, err = pkg.PresentFiles()
+	require.NoError(t, err)
+	assert.Len(t, present, 5)
+}
@@ -0,0 +1,188 @@
+package rpmdb
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"golang.org/x/xerrors"
+)
+
+// Index is the name of a secondary index of the rpmdb, e.g. IndexName
+type Index = dbi.Index
+
+const (
+	IndexName        = dbi.IndexName
+	IndexProvidename = dbi.IndexProvidename
+	IndexRequirename = dbi.IndexRequirename
+	IndexBasenames   = dbi.IndexBasenames
+	IndexDirnames    = dbi.IndexDirnames
+	IndexSha1header  = dbi.IndexSha1header
+	IndexSigmd5      = dbi.IndexSigmd5
+	IndexInstalltid  = dbi.IndexInstalltid
+)
+
+// LookupIndex returns the packages whose tag of the index contains key, ordered by instance number.
+// Only the headers referenced by the index are read. If the backend has no such index, the index
+// is stale or it has no entries of key, all packages are read and filtered instead.
+func (d *RpmDB) LookupIndex(ctx context.Context, index Index, key []byte) ([]*PackageInfo, error) {
+	if index.Tag() == 0 {
+		return nil, xerrors.Errorf("unknown index: %s", index)
+	}
+
+	var pkgs []*PackageInfo
+	err := d.locked(ctx, func(db dbi.RpmDBInterface) (err error) {
+		if r, ok := db.(dbi.IndexReader); ok {
+			pkgs, err = lookupIndex(ctx, r, index, key)
+			// a package may be missing from an index which is out of date with Packages
+			if (err == nil && len(pkgs) > 0) || ctx.Err() != nil {
+				return err
+			}
+		}
+		pkgs, err = scanIndex(ctx, db, index, key)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(pkgs, func(i, j int) bool { return pkgs[i].DBInstance < pkgs[j].DBInstance })
+		return nil
+	})
+	return pkgs, err
+}
+
+// lookupIndex fetches the headers referenced by the index and checks that they match
+func lookupIndex(ctx context.Context, r dbi.IndexReader, index Index, key []byte) ([]*PackageInfo, error) {
+	items, err := r.ReadIndex(ctx, index, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var hnums []uint32
+	seen := make(map[uint32]struct{})
+	for _, item := range items {
+		if _, ok := seen[item.HeaderNum]; ok {
+			continue
+		}
+		seen[item.HeaderNum] = struct{}{}
+		hnums = append(hnums, item.HeaderNum)
+	}
+	sort.Slice(hnums, func(i, j int) bool { return hnums[i] < hnums[j] })
+
+	var pkgs []*PackageInfo
+	for _, hnum := range hnums {
+		blob, err := r.ReadHeader(ctx, hnum)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read header %d: %w", hnum, err)
+		}
+		indexEntries, err := headerImport(blob)
+		if err != nil {
+			return nil, xerrors.Errorf("error during importing header: %w", err)
+		}
+		// the index is out of date with Packages
+		if !headerHasKey(indexEntries, index, key) {
+			return nil, xerrors.Errorf("stale %s index: header %d doesn't match", index, hnum)
+		}
+		pkg, err := getNEVRA(indexEntries)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid package info: %w", err)
+		}
+		pkg.DBInstance = hnum
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// scanIndex reads all headers and keeps the ones matching key
+func scanIndex(ctx context.Context, db dbi.RpmDBInterface, index Index, key []byte) ([]*PackageInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	entries := db.Read(ctx)
+	defer func() {
+		cancel()
+		for range entries {
+		}
+	}()
+
+	var pkgs []*PackageInfo
+	for entry := range entries {
+		if entry.Err != nil {
+			return nil, entry.Err
+		}
+
+		indexEntries, err := headerImport(entry.Value)
+		if err != nil {
+			return nil, xerrors.Errorf("error during importing header: %w", err)
+		}
+		if !headerHasKey(indexEntries, index, key) {
+			continue
+		}
+		pkg, err := getNEVRA(indexEntries)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid package info: %w", err)
+		}
+		pkg.DBInstance = entry.HeaderNum
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// headerHasKey reports whether the tag of the index contains key, like the keys rpm
+// generates when adding a header to its indexes
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.16.0-release/lib/rpmdb.c
+func headerHasKey(indexEntries []indexEntry, index Index, key []byte) bool {
+	for _, ie := range indexEntries {
+		if ie.Info.Tag != index.Tag() {
+			continue
+		}
+
+		switch ie.Info.Type {
+		case RPM_STRING_TYPE, RPM_I18NSTRING_TYPE, RPM_STRING_ARRAY_TYPE:
+			for _, value := range parseStringArray(ie.Data) {
+				if value == string(key) {
+					return true
+				}
+			}
+		case RPM_BIN_TYPE:
+			return bytes.Equal(ie.Data, key)
+		case RPM_INT32_TYPE:
+			// integer keys are stored in the byte order of the host, tags in big endian
+			if len(key) != 4 {
+				return false
+			}
+			for i := 0; i+4 <= len(ie.Data); i += 4 {
+				value := ie.Data[i : i+4]
+				if bytes.Equal(value, key) || bytes.Equal(value, []byte{key[3], key[2], key[1], key[0]}) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// PackagesByName returns all installed packages with the name, e.g. several versions of kernel
+func (d *RpmDB) PackagesByName(ctx context.Context, name string) ([]*PackageInfo, error) {
+	return d.LookupIndex(ctx, IndexName, []byte(name))
+}
+
+// WhatProvides returns the packages providing the capability, like rpm -q --whatprovides
+func (d *RpmDB) WhatProvides(ctx context.Context, capability string) ([]*PackageInfo, error) {
+	return d.LookupIndex(ctx, IndexProvidename, []byte(capability))
+}
+
+// WhatRequires returns the packages requiring the capability, like rpm -q --whatrequires
+func (d *RpmDB) WhatRequires(ctx context.Context, capability string) ([]*PackageInfo, error) {
+	return d.LookupIndex(ctx, IndexRequirename, []byte(capability))
+}
+
+// Close releases the database of the backend
+func (d *RpmDB) Close() error {
+	if d.lock != nil {
+		return d.closeLocked()
+	}
+	if c, ok := d.db.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
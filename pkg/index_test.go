@@ -0,0 +1,501 @@
+package rpmdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"github.com/jfrog/go-rpmdb/pkg/ndb"
+)
+
+type testIndexRow struct {
+	key  interface{}
+	hnum int
+	idx  int
+}
+
+// addSQLite3Index creates an index table like rpm's sqlite backend
+func addSQLite3Index(t *testing.T, path string, index Index, rows ...testIndexRow) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.16.0-release/lib/backend/sqlite.c
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS '" + string(index) + "' (key 'TEXT' NOT NULL, hnum INTEGER NOT NULL, idx INTEGER NOT NULL, FOREIGN KEY (hnum) REFERENCES 'Packages'(hnum))")
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS '" + string(index) + "_key_idx' ON '" + string(index) + "'(key ASC)")
+	require.NoError(t, err)
+	for _, row := range rows {
+		_, err = db.Exec("INSERT INTO '"+string(index)+"' (key, hnum, idx) VALUES (?, ?, ?)", row.key, row.hnum, row.idx)
+		require.NoError(t, err)
+	}
+}
+
+func testIndexedHeader(name, version string, installTid int32, provides ...string) []byte {
+	return (&headerBuilder{}).
+		addString(RPMTAG_NAME, name).
+		addString(RPMTAG_VERSION, version).
+		addString(RPMTAG_RELEASE, "1").
+		addStringArray(RPMTAG_PROVIDENAME, provides...).
+		addInt32(1128, installTid). // RPMTAG_INSTALLTID
+		bytes()
+}
+
+func TestRpmDB_LookupIndex(t *testing.T) {
+	headers := [][]byte{
+		testIndexedHeader("kernel", "5.14", 1000, "kernel", "kernel(x86-64)"),
+		testIndexedHeader("bash", "5.2", 1000, "bash", "/bin/sh"),
+		testIndexedHeader("kernel", "6.1", 2000, "kernel", "kernel(x86-64)"),
+	}
+	installTid := make([]byte, 4)
+	binary.LittleEndian.PutUint32(installTid, 2000)
+
+	names := func(pkgs []*PackageInfo) []string {
+		var names []string
+		for _, pkg := range pkgs {
+			names = append(names, pkg.Name+"-"+pkg.Version)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name  string
+		index Index
+		rows  []testIndexRow
+		key   []byte
+		want  []string
+	}{
+		{
+			name:  "name index",
+			index: IndexName,
+			rows:  []testIndexRow{{"kernel", 3, 0}, {"bash", 2, 0}, {"kernel", 1, 0}},
+			key:   []byte("kernel"),
+			want:  []string{"kernel-5.14", "kernel-6.1"},
+		},
+		{
+			name:  "provide index",
+			index: IndexProvidename,
+			rows:  []testIndexRow{{"/bin/sh", 2, 1}},
+			key:   []byte("/bin/sh"),
+			want:  []string{"bash-5.2"},
+		},
+		{
+			name:  "binary key",
+			index: IndexInstalltid,
+			rows:  []testIndexRow{{installTid, 3, 0}},
+			key:   installTid,
+			want:  []string{"kernel-6.1"},
+		},
+		{
+			name:  "no match",
+			index: IndexName,
+			rows:  []testIndexRow{{"bash", 2, 0}},
+			key:   []byte("zsh"),
+		},
+		{
+			name:  "missing index falls back to a scan",
+			index: IndexProvidename,
+			key:   []byte("kernel(x86-64)"),
+			want:  []string{"kernel-5.14", "kernel-6.1"},
+		},
+		{
+			name:  "stale index falls back to a scan",
+			index: IndexName,
+			rows:  []testIndexRow{{"bash", 1, 0}, {"bash", 4, 0}},
+			key:   []byte("bash"),
+			want:  []string{"bash-5.2"},
+		},
+		{
+			name:  "package missing from the index falls back to a scan",
+			index: IndexName,
+			rows:  []testIndexRow{{"kernel", 1, 0}, {"kernel", 3, 0}},
+			key:   []byte("bash"),
+			want:  []string{"bash-5.2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := createSQLite3DB(t, headers...)
+			if tt.rows != nil {
+				addSQLite3Index(t, path, tt.index, tt.rows...)
+			}
+
+			db, err := Open(path)
+			require.NoError(t, err)
+			defer db.Close()
+
+			got, err := db.LookupIndex(context.Background(), tt.index, tt.key)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, names(got))
+
+			pkg, err := db.PackageWithContext(context.Background(), string(tt.key))
+			if tt.index != IndexName || tt.want == nil {
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want[0], pkg.Name+"-"+pkg.Version)
+		})
+	}
+
+	t.Run("unknown index", func(t *testing.T) {
+		db, err := Open(createSQLite3DB(t, headers...))
+		require.NoError(t, err)
+		defer db.Close()
+
+		_, err = db.LookupIndex(context.Background(), Index("Packages"), []byte("kernel"))
+		assert.Error(t, err)
+	})
+}
+
+func TestRpmDB_WhatProvides(t *testing.T) {
+	tests := []struct {
+		name         string
+		file         string
+		capability   string
+		wantProvides []string
+		wantRequires []string
+	}{
+		{
+			name:         "CBL-Mariner 2.0 indexes",
+			file:         "testdata/cbl-mariner-2.0/rpmdb.sqlite",
+			capability:   "libcurl.so.4()(64bit)",
+			wantProvides: []string{"curl-libs"},
+			wantRequires: []string{"curl", "tdnf"},
+		},
+		{
+			name:         "SLE15 without Index.db",
+			file:         "testdata/sle15-bci/Packages.db",
+			capability:   "libreadline.so.7()(64bit)",
+			wantProvides: []string{"libreadline7"},
+			wantRequires: []string{"bash"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(tt.file)
+			require.NoError(t, err)
+			defer db.Close()
+
+			ctx := context.Background()
+			var got []string
+			pkgs, err := db.WhatProvides(ctx, tt.capability)
+			require.NoError(t, err)
+			for _, pkg := range pkgs {
+				got = append(got, pkg.Name)
+			}
+			assert.Equal(t, tt.wantProvides, got)
+
+			got = nil
+			pkgs, err = db.WhatRequires(ctx, tt.capability)
+			require.NoError(t, err)
+			for _, pkg := range pkgs {
+				got = append(got, pkg.Name)
+			}
+			assert.Equal(t, tt.wantRequires, got)
+		})
+	}
+}
+
+func TestRpmDB_LookupIndex_BerkeleyDB(t *testing.T) {
+	hnum := func(n uint32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, n)
+		return b
+	}
+	items := func(hnums ...uint32) []byte {
+		var b []byte
+		for _, n := range hnums {
+			b = append(b, hnum(n)...)
+			b = append(b, hnum(0)...)
+		}
+		return b
+	}
+
+	dir := t.TempDir()
+	createBDBHash(t, filepath.Join(dir, "Packages"), 512,
+		testBDBPair{key: hnum(0), value: hnum(4)},
+		testBDBPair{key: hnum(1), value: testIndexedHeader("kernel", "5.14", 1000, "kernel"), offPage: true},
+		testBDBPair{key: hnum(2), value: testIndexedHeader("bash", "5.2", 1000, "bash", "/bin/sh"), offPage: true},
+		testBDBPair{key: hnum(3), value: testIndexedHeader("kernel", "6.1", 2000, "kernel"), offPage: true},
+	)
+	createBDBHash(t, filepath.Join(dir, "Name"), 512,
+		testBDBPair{key: []byte("bash"), value: items(2)},
+		testBDBPair{key: []byte("kernel"), value: items(3, 1)},
+	)
+	// a stale index
+	createBDBHash(t, filepath.Join(dir, "Providename"), 512,
+		testBDBPair{key: []byte("/bin/sh"), value: items(1)},
+	)
+
+	db, err := Open(filepath.Join(dir, "Packages"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	pkgs, err := db.PackagesByName(ctx, "kernel")
+	require.NoError(t, err)
+	require.Len(t, pkgs, 2)
+	assert.Equal(t, "5.14", pkgs[0].Version)
+	assert.Equal(t, "6.1", pkgs[1].Version)
+
+	pkg, err := db.PackageWithContext(ctx, "bash")
+	require.NoError(t, err)
+	assert.Equal(t, "bash", pkg.Name)
+
+	pkgs, err = db.WhatProvides(ctx, "/bin/sh")
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	assert.Equal(t, "bash", pkgs[0].Name)
+
+	// no Requirename database
+	pkgs, err = db.WhatRequires(ctx, "/bin/sh")
+	require.NoError(t, err)
+	assert.Empty(t, pkgs)
+}
+
+type testIdxSlot struct {
+	key        string
+	hnum, tnum uint32
+}
+
+// idxBlob builds a rpmidx hash table, hashing, encoding the keys and the data like rpm does
+// ref. murmurhash(), encodekeyl() and encodedata() in https://github.com/rpm-software-management/rpm/blob/rpm-4.17.0-release/lib/backend/ndb/rpmidx.c
+func idxBlob(slots ...testIdxSlot) []byte {
+	const nslots = 16
+	const xmask = 0xff000000
+
+	murmur := func(s []byte) uint32 {
+		const m = 0x5bd1e995
+		h := uint32(len(s)) * m
+		for ; len(s) >= 4; s = s[4:] {
+			h += binary.LittleEndian.Uint32(s)
+			h *= m
+			h ^= h >> 16
+		}
+		switch len(s) {
+		case 3:
+			h += uint32(s[2]) << 16
+			fallthrough
+		case 2:
+			h += uint32(s[1]) << 8
+			fallthrough
+		case 1:
+			h += uint32(s[0])
+			h *= m
+			h ^= h >> 16
+		}
+		return h
+	}
+
+	keys := []byte{0, 0} // offsets 0 and 1 mark empty and deleted slots
+	blob := make([]byte, 64+12*nslots)
+	for _, slot := range slots {
+		keyh := murmur([]byte(slot.key))
+		h, hh := keyh&(nslots-1), uint32(7)
+		for binary.LittleEndian.Uint32(blob[64+8*h:]) != 0 {
+			h = (h + hh) & (nslots - 1)
+			hh++
+		}
+		x := uint32(len(keys)) | keyh&xmask // hash bits above the key offset
+		switch keyl := len(slot.key); {
+		case keyl > 0 && keyl < 255:
+			keys = append(keys, byte(keyl))
+		case keyl < 65535:
+			keys = append(keys, 255, byte(keyl), byte(keyl>>8))
+		default:
+			keys = append(keys, 255, 255, 255, byte(keyl), byte(keyl>>8), byte(keyl>>16), byte(keyl>>24))
+		}
+		keys = append(keys, slot.key...)
+
+		binary.LittleEndian.PutUint32(blob[64+8*h:], x)
+		switch {
+		case slot.hnum < 0x100000 && slot.tnum < 0x400:
+			binary.LittleEndian.PutUint32(blob[64+8*h+4:], slot.hnum|slot.tnum<<20)
+		case slot.hnum < 0x1000000 && slot.tnum < 0x40:
+			binary.LittleEndian.PutUint32(blob[64+8*h+4:], slot.hnum|slot.tnum<<24|0x40000000)
+		default:
+			binary.LittleEndian.PutUint32(blob[64+8*h+4:], slot.tnum|0x80000000)
+			binary.LittleEndian.PutUint32(blob[64+8*nslots+4*h:], slot.hnum)
+		}
+	}
+	for i, v := range []uint32{'I' | 'd'<<8 | 'x'<<16 | 'D'<<24, 0, 1, nslots, uint32(len(slots)), 0, xmask, uint32(len(keys))} {
+		binary.LittleEndian.PutUint32(blob[4*i:], v)
+	}
+	return append(blob, keys...)
+}
+
+// createIndexDB writes an NDB Index.db with one blob per tag for the generation of Packages.db
+func createIndexDB(t *testing.T, path string, generation uint32, blobs map[int32][]byte) {
+	t.Helper()
+
+	const pageSize = 4096
+	data := make([]byte, pageSize)
+	for i, v := range []uint32{'R' | 'p'<<8 | 'm'<<16 | 'X'<<24, 0, 1, 1, pageSize, generation} {
+		binary.LittleEndian.PutUint32(data[4*i:], v)
+	}
+
+	slot := 2 // the header overlays the first two slots
+	for tag, blob := range blobs {
+		pages := (len(blob) + pageSize - 1) / pageSize
+		for i, v := range []uint32{'S' | 'l'<<8 | 'o'<<16, uint32(tag), uint32(len(data) / pageSize), uint32(pages)} {
+			binary.LittleEndian.PutUint32(data[16*slot+4*i:], v)
+		}
+		slot++
+		data = append(data, blob...)
+		data = append(data, make([]byte, pages*pageSize-len(blob))...)
+	}
+	require.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+func TestRpmDB_LookupIndex_NDB(t *testing.T) {
+	dir := t.TempDir()
+	packages, err := os.ReadFile("testdata/sle15-bci/Packages.db")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Packages.db"), packages, 0644))
+
+	db, err := Open(filepath.Join(dir, "Packages.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// find the package indexes of the fixture
+	hnums := make(map[string]uint32)
+	r := db.db.(dbi.IndexReader)
+	for hnum := uint32(1); hnum < 64; hnum++ {
+		blob, err := r.ReadHeader(context.Background(), hnum)
+		if xerrors.Is(err, dbi.ErrHeaderNotFound) {
+			continue
+		}
+		require.NoError(t, err)
+		indexEntries, err := headerImport(blob)
+		require.NoError(t, err)
+		pkg, err := getNEVRA(indexEntries)
+		require.NoError(t, err)
+		hnums[pkg.Name] = hnum
+	}
+	require.Contains(t, hnums, "bash")
+	require.Contains(t, hnums, "libreadline7")
+
+	generation := binary.LittleEndian.Uint32(packages[8:])
+	createIndexDB(t, filepath.Join(dir, "Index.db"), generation, map[int32][]byte{
+		RPMTAG_NAME: idxBlob(testIdxSlot{"bash", hnums["bash"], 0}),
+		RPMTAG_PROVIDENAME: idxBlob(
+			testIdxSlot{"bash", hnums["bash"], 0},
+			testIdxSlot{"libreadline.so.7()(64bit)", hnums["libreadline7"], 1},
+		),
+		// stale
+		RPMTAG_REQUIRENAME: idxBlob(testIdxSlot{"libreadline.so.7()(64bit)", hnums["libreadline7"], 0}),
+		RPMTAG_BASENAMES: idxBlob(
+			// the file index exceeds the 10 bits next to the package index
+			testIdxSlot{"bash", hnums["bash"], 1500},
+			// the package index exceeds 20 bits
+			testIdxSlot{"sh", 0x123456, 5},
+			testIdxSlot{strings.Repeat("a", 300), hnums["bash"], 2},
+			testIdxSlot{strings.Repeat("b", 70000), hnums["bash"], 3},
+		),
+	})
+
+	items, err := r.ReadIndex(context.Background(), IndexProvidename, []byte("libreadline.so.7()(64bit)"))
+	require.NoError(t, err)
+	assert.Equal(t, []dbi.IndexItem{{HeaderNum: hnums["libreadline7"], TagNum: 1}}, items)
+
+	for key, want := range map[string]dbi.IndexItem{
+		"bash":                   {HeaderNum: hnums["bash"], TagNum: 1500},
+		"sh":                     {HeaderNum: 0x123456, TagNum: 5},
+		strings.Repeat("a", 300): {HeaderNum: hnums["bash"], TagNum: 2},
+		// keys of 65535 bytes and more have a 4 bytes length
+		strings.Repeat("b", 70000): {HeaderNum: hnums["bash"], TagNum: 3},
+	} {
+		items, err = r.ReadIndex(context.Background(), IndexBasenames, []byte(key))
+		require.NoError(t, err)
+		assert.Equal(t, []dbi.IndexItem{want}, items, "key of %d bytes", len(key))
+	}
+
+	_, err = r.ReadIndex(context.Background(), IndexDirnames, []byte("/usr/bin/"))
+	assert.True(t, xerrors.Is(err, dbi.ErrIndexNotFound))
+
+	ctx := context.Background()
+	pkg, err := db.PackageWithContext(ctx, "bash")
+	require.NoError(t, err)
+	assert.Equal(t, "bash", pkg.Name)
+
+	pkgs, err := db.WhatProvides(ctx, "libreadline.so.7()(64bit)")
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	assert.Equal(t, "libreadline7", pkgs[0].Name)
+
+	pkgs, err = db.WhatRequires(ctx, "libreadline.so.7()(64bit)")
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	assert.Equal(t, "bash", pkgs[0].Name)
+
+	// Packages.db changed after the indexes were updated
+	createIndexDB(t, filepath.Join(dir, "Index.db"), generation-1, map[int32][]byte{
+		RPMTAG_PROVIDENAME: idxBlob(testIdxSlot{"libreadline.so.7()(64bit)", hnums["bash"], 0}),
+	})
+	_, err = r.ReadIndex(ctx, IndexProvidename, []byte("libreadline.so.7()(64bit)"))
+	assert.True(t, xerrors.Is(err, ndb.ErrorStaleIndex))
+
+	pkgs, err = db.WhatProvides(ctx, "libreadline.so.7()(64bit)")
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	assert.Equal(t, "libreadline7", pkgs[0].Name)
+}
+
+func TestRpmDB_LookupIndex_BerkeleyDBBtree(t *testing.T) {
+	hnum := func(n uint32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, n)
+		return b
+	}
+	item := func(n uint32) string {
+		return string(append(hnum(n), hnum(0)...))
+	}
+
+	dir := t.TempDir()
+	// the hash page lists the later kernel first
+	createBDBHash(t, filepath.Join(dir, "Packages"), 512,
+		testBDBPair{key: hnum(3), value: testIndexedHeader("kernel", "6.1", 2000, "kernel"), offPage: true},
+		testBDBPair{key: hnum(1), value: testIndexedHeader("kernel", "5.14", 1000, "kernel"), offPage: true},
+		testBDBPair{key: hnum(2), value: testIndexedHeader("bash", "5.2", 1000, "bash"), offPage: true},
+	)
+	testBtree(t, filepath.Join(dir, "Name"), map[string][]string{
+		"bash":                   {item(2)},
+		"kernel":                 {item(3), item(1)},
+		strings.Repeat("k", 600): {item(1)},
+		"zsh":                    {item(1), item(3)},
+	})
+
+	db, err := Open(filepath.Join(dir, "Packages"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	items, err := db.db.(dbi.IndexReader).ReadIndex(context.Background(), IndexName, []byte("kernel"))
+	require.NoError(t, err)
+	assert.Equal(t, []dbi.IndexItem{{HeaderNum: 3}, {HeaderNum: 1}}, items)
+
+	pkgs, err := db.PackagesByName(context.Background(), "kernel")
+	require.NoError(t, err)
+	require.Len(t, pkgs, 2)
+	assert.Equal(t, "5.14", pkgs[0].Version)
+	assert.Equal(t, "6.1", pkgs[1].Version)
+
+	// Package returns the first kernel of ListPackages
+	pkg, err := db.PackageWithContext(context.Background(), "kernel")
+	require.NoError(t, err)
+	assert.Equal(t, "6.1", pkg.Version)
+
+	pkg, err = db.PackageWithContext(context.Background(), "bash")
+	require.NoError(t, err)
+	assert.Equal(t, "5.2", pkg.Version)
+}
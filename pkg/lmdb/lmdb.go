@@ -0,0 +1,427 @@
+package lmdb
+
+import (
+	"context"
+	"encoding/binary"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"golang.org/x/xerrors"
+)
+
+/* rpm's lmdb backend keeps every dbi as a named database of an LMDB environment in
+   %_dbpath, the headers are in the "Packages" database of data.mdb:
+
+   https://github.com/rpm-software-management/rpm/blob/rpm-4.18.0-release/lib/backend/lmdb.c
+
+   LMDB itself is only documented by its source, the structures are in mdb.c:
+
+   https://github.com/LMDB/lmdb/blob/LMDB_0.9.29/libraries/liblmdb/mdb.c
+
+   data.mdb File Format:
+   =====================
+
+   The file is an array of pages of the page size of the OS which created it, all fields
+   are in its byte order and word size. Only 64-bit environments are supported here.
+
+   Every page starts with a 16 bytes header: the page number, the flags and either the
+   bounds of the free space or, for overflow pages, the number of pages.
+
+   Pages 0 and 1 are "Meta" pages, which are written alternately by the transactions. The
+   one with the higher transaction ID is current. It holds the page size, the last page and
+   the b-trees of the free list and of the main database, whose keys are the names of the
+   named databases and whose values are their b-trees.
+
+   Each database is a B+tree: branch pages hold the child page numbers, leaf pages the
+   keys and the values. The "Nodes" of both are referenced by an array of offsets after
+   the page header. Values too large for a leaf page are stored on consecutive overflow
+   pages instead, and the node holds the number of the first one.
+
+   The keys of Packages are the header instance numbers in the byte order of the
+   environment, the instance 0 holds the next free instance number.
+*/
+
+const (
+	// LMDB_Magic is the magic of the meta pages
+	LMDB_Magic = 0xBEEFC0DE
+	// LMDB_DataVersion is the version of the file format
+	LMDB_DataVersion = 1
+
+	// pageHeaderSize is the size of the page header, of the page number in particular,
+	// in 64-bit environments
+	pageHeaderSize = 16
+	nodeHeaderSize = 8
+	// dbSize is the size of an MDB_db, the description of a b-tree
+	dbSize = 48
+	// invalidPage is the root of empty b-trees
+	invalidPage = ^uint64(0)
+	// maxTreeDepth limits the depth of b-trees of corrupted databases
+	maxTreeDepth = 32
+
+	pageBranch   = 0x01
+	pageLeaf     = 0x02
+	pageOverflow = 0x04
+	pageMeta     = 0x08
+
+	nodeBigData = 0x01
+	nodeSubData = 0x02
+	nodeDupData = 0x04
+
+	// integerKey is MDB_INTEGERKEY, the keys are compared as integers instead of bytes
+	integerKey = 0x08
+
+	// packagesDB is the named database of the headers
+	packagesDB = "Packages"
+)
+
+var (
+	ErrorInvalidLMDB = xerrors.Errorf("invalid or unsupported LMDB format: %w", dbi.ErrNotRPMDB)
+
+	// ErrorUnsupported is returned for LMDB environments of other word sizes and for
+	// databases with features rpm doesn't use
+	ErrorUnsupported = xerrors.New("unsupported LMDB feature")
+)
+
+type RpmLMDB struct {
+	file     dbi.File
+	order    binary.ByteOrder
+	pageSize uint32
+	lastPage uint64
+	// packages is the root page of Packages
+	packages uint64
+}
+
+// meta is the content of a meta page
+type meta struct {
+	pageSize uint32
+	main     tree
+	lastPage uint64
+	txnID    uint64
+}
+
+// tree is an MDB_db
+type tree struct {
+	flags   uint16
+	depth   uint16
+	entries uint64
+	root    uint64
+}
+
+func Open(path string) (*RpmLMDB, error) {
+	file, err := dbi.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := OpenFile(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenFile opens data.mdb in an opened file, e.g. one mapped by dbi.OpenMmap.
+// The file is closed by Close. It returns ErrorInvalidLMDB for files of other formats.
+func OpenFile(file dbi.File) (*RpmLMDB, error) {
+	db := &RpmLMDB{file: file}
+	m, err := db.readMeta()
+	if err != nil {
+		return nil, err
+	}
+	db.pageSize, db.lastPage = m.pageSize, m.lastPage
+	if m.main.root == invalidPage {
+		return nil, xerrors.Errorf("no %s database: %w", packagesDB, ErrorInvalidLMDB)
+	}
+
+	packages, err := db.namedDB(m.main.root, packagesDB)
+	if err != nil {
+		return nil, err
+	}
+	if packages.flags&^integerKey != 0 {
+		return nil, xerrors.Errorf("%s database flags %#x: %w", packagesDB, packages.flags, ErrorUnsupported)
+	}
+	db.packages = packages.root
+	return db, nil
+}
+
+// readMeta returns the current meta page
+func (db *RpmLMDB) readMeta() (*meta, error) {
+	// the page size is in the first meta page, the second one follows it
+	first, err := db.readMetaPage(0)
+	if err != nil {
+		return nil, err
+	}
+	second, err := db.readMetaPage(int64(first.pageSize))
+	if err != nil {
+		return first, nil
+	}
+	if second.txnID > first.txnID && second.pageSize == first.pageSize {
+		return second, nil
+	}
+	return first, nil
+}
+
+func (db *RpmLMDB) readMetaPage(offset int64) (*meta, error) {
+	page := make([]byte, pageHeaderSize+136)
+	if _, err := db.file.ReadAt(page, offset); err != nil {
+		return nil, ErrorInvalidLMDB
+	}
+
+	if db.order == nil {
+		switch {
+		case binary.LittleEndian.Uint32(page[16:]) == LMDB_Magic:
+			db.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(page[16:]) == LMDB_Magic:
+			db.order = binary.BigEndian
+		case binary.LittleEndian.Uint32(page[12:]) == LMDB_Magic, binary.BigEndian.Uint32(page[12:]) == LMDB_Magic:
+			// the page number of 32-bit environments is 4 bytes long
+			return nil, xerrors.Errorf("32-bit environment: %w", ErrorUnsupported)
+		default:
+			return nil, ErrorInvalidLMDB
+		}
+	}
+	order := db.order
+
+	data := page[pageHeaderSize:]
+	if order.Uint32(data) != LMDB_Magic || order.Uint16(page[10:])&pageMeta == 0 {
+		return nil, ErrorInvalidLMDB
+	}
+	if version := order.Uint32(data[4:]); version != LMDB_DataVersion {
+		return nil, xerrors.Errorf("data version %d: %w", version, ErrorUnsupported)
+	}
+
+	// the page size is in the padding of the b-tree of the free list
+	free := data[24:]
+	m := &meta{
+		pageSize: order.Uint32(free),
+		main:     db.parseTree(data[24+dbSize:]),
+		lastPage: order.Uint64(data[24+2*dbSize:]),
+		txnID:    order.Uint64(data[24+2*dbSize+8:]),
+	}
+	if m.pageSize < 512 || m.pageSize&(m.pageSize-1) != 0 {
+		return nil, xerrors.Errorf("page size %d: %w", m.pageSize, ErrorInvalidLMDB)
+	}
+	return m, nil
+}
+
+func (db *RpmLMDB) parseTree(data []byte) tree {
+	return tree{
+		flags:   db.order.Uint16(data[4:]),
+		depth:   db.order.Uint16(data[6:]),
+		entries: db.order.Uint64(data[32:]),
+		root:    db.order.Uint64(data[40:]),
+	}
+}
+
+// namedDB returns the b-tree of a named database from the main database rooted at root
+func (db *RpmLMDB) namedDB(root uint64, name string) (*tree, error) {
+	var named *tree
+	err := db.walk(context.Background(), root, func(pageNo uint64, node []byte, key []byte, flags uint16) (bool, error) {
+		if string(key) != name {
+			return true, nil
+		}
+		value, err := db.nodeValue(pageNo, node, key, flags)
+		if err != nil {
+			return false, err
+		}
+		if flags&nodeSubData == 0 || len(value) != dbSize {
+			return false, corruptPage(pageNo, "%s is not a named database", name)
+		}
+		t := db.parseTree(value)
+		named = &t
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if named == nil {
+		return nil, xerrors.Errorf("no %s database: %w", name, ErrorInvalidLMDB)
+	}
+	return named, nil
+}
+
+// Read returns the headers of Packages in the order of the keys, which are compared as bytes
+// unless the database has integer keys
+func (db *RpmLMDB) Read(ctx context.Context) <-chan dbi.Entry {
+	entries := make(chan dbi.Entry)
+
+	go func() {
+		defer close(entries)
+
+		if db.packages == invalidPage {
+			return
+		}
+		err := db.walk(ctx, db.packages, func(pageNo uint64, node []byte, key []byte, flags uint16) (bool, error) {
+			if len(key) != 4 {
+				return false, corruptPage(pageNo, "%s key of %d bytes", packagesDB, len(key))
+			}
+			// hnum 0 records the next free instance number, it's not a header
+			hnum := db.order.Uint32(key)
+			if hnum == 0 {
+				return true, nil
+			}
+
+			value, err := db.nodeValue(pageNo, node, key, flags)
+			if err != nil {
+				return false, err
+			}
+			entries <- dbi.Entry{
+				Value:     value,
+				HeaderNum: hnum,
+			}
+			return true, nil
+		})
+		if err != nil {
+			entries <- dbi.Entry{
+				Err: err,
+			}
+		}
+	}()
+
+	return entries
+}
+
+// walk calls fn with the nodes of the leaf pages of the b-tree rooted at root in key order,
+// until fn returns false
+func (db *RpmLMDB) walk(ctx context.Context, root uint64, fn func(pageNo uint64, node, key []byte, flags uint16) (bool, error)) error {
+	visited := make(map[uint64]struct{})
+	var walk func(pageNo uint64, depth int) (bool, error)
+	walk = func(pageNo uint64, depth int) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		if _, ok := visited[pageNo]; ok || depth > maxTreeDepth {
+			return false, corruptPage(pageNo, "cycle in b-tree")
+		}
+		visited[pageNo] = struct{}{}
+
+		page, flags, err := db.readPage(pageNo)
+		if err != nil {
+			return false, err
+		}
+		nodes, err := db.nodes(pageNo, page)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case flags&pageBranch != 0:
+			for _, node := range nodes {
+				// the child is in the data size and the flags of branch nodes
+				child := uint64(db.order.Uint32(node)) | uint64(db.order.Uint16(node[4:]))<<32
+				if ok, err := walk(child, depth+1); !ok || err != nil {
+					return false, err
+				}
+			}
+			return true, nil
+		case flags&pageLeaf != 0:
+			for _, node := range nodes {
+				keySize := int(db.order.Uint16(node[6:]))
+				if nodeHeaderSize+keySize > len(node) {
+					return false, corruptPage(pageNo, "key exceeds the page")
+				}
+				key := node[nodeHeaderSize : nodeHeaderSize+keySize]
+				if ok, err := fn(pageNo, node, key, db.order.Uint16(node[4:])); !ok || err != nil {
+					return false, err
+				}
+			}
+			return true, nil
+		}
+		return false, corruptPage(pageNo, "unexpected page flags %#x in b-tree", flags)
+	}
+
+	_, err := walk(root, 0)
+	return err
+}
+
+// readPage returns the page and its flags
+func (db *RpmLMDB) readPage(pageNo uint64) ([]byte, uint16, error) {
+	if pageNo > db.lastPage {
+		return nil, 0, corruptPage(pageNo, "exceeds last page=%d", db.lastPage)
+	}
+	page := make([]byte, db.pageSize)
+	if _, err := db.file.ReadAt(page, int64(pageNo)*int64(db.pageSize)); err != nil {
+		return nil, 0, corruptPage(pageNo, "failed to read page: %w", err)
+	}
+	if n := db.order.Uint64(page); n != pageNo {
+		return nil, 0, corruptPage(pageNo, "unexpected page number %d", n)
+	}
+	return page, db.order.Uint16(page[10:]), nil
+}
+
+// nodes returns the nodes of a branch or leaf page, each up to the end of the page
+func (db *RpmLMDB) nodes(pageNo uint64, page []byte) ([][]byte, error) {
+	lower := int(db.order.Uint16(page[12:]))
+	if lower < pageHeaderSize || lower > len(page) {
+		return nil, corruptPage(pageNo, "invalid lower bound %d", lower)
+	}
+	nodes := make([][]byte, (lower-pageHeaderSize)/2)
+	for i := range nodes {
+		offset := int(db.order.Uint16(page[pageHeaderSize+2*i:]))
+		if offset < lower || offset+nodeHeaderSize > len(page) {
+			return nil, corruptPage(pageNo, "invalid node offset %d", offset)
+		}
+		nodes[i] = page[offset:]
+	}
+	return nodes, nil
+}
+
+// nodeValue returns a copy of the value of a leaf node, which may be on overflow pages
+func (db *RpmLMDB) nodeValue(pageNo uint64, node, key []byte, flags uint16) ([]byte, error) {
+	size := uint64(db.order.Uint32(node))
+	data := node[nodeHeaderSize+len(key):]
+
+	if flags&nodeDupData != 0 {
+		return nil, xerrors.Errorf("duplicate values on page %d: %w", pageNo, ErrorUnsupported)
+	}
+	if flags&nodeBigData == 0 {
+		if size > uint64(len(data)) {
+			return nil, corruptPage(pageNo, "value exceeds the page")
+		}
+		return append([]byte(nil), data[:size]...), nil
+	}
+
+	if len(data) < 8 {
+		return nil, corruptPage(pageNo, "value exceeds the page")
+	}
+	overflow := db.order.Uint64(data)
+	header := make([]byte, pageHeaderSize)
+	if overflow > db.lastPage {
+		return nil, corruptPage(overflow, "exceeds last page=%d", db.lastPage)
+	}
+	if _, err := db.file.ReadAt(header, int64(overflow)*int64(db.pageSize)); err != nil {
+		return nil, corruptPage(overflow, "failed to read page: %w", err)
+	}
+	pages := uint64(db.order.Uint32(header[12:]))
+	if db.order.Uint64(header) != overflow || db.order.Uint16(header[10:])&pageOverflow == 0 {
+		return nil, corruptPage(overflow, "not an overflow page")
+	}
+	if overflow+pages-1 > db.lastPage || pageHeaderSize+size > pages*uint64(db.pageSize) {
+		return nil, corruptPage(overflow, "value of %d bytes exceeds %d overflow pages", size, pages)
+	}
+
+	value := make([]byte, size)
+	if _, err := db.file.ReadAt(value, int64(overflow)*int64(db.pageSize)+pageHeaderSize); err != nil {
+		return nil, corruptPage(overflow, "failed to read value: %w", err)
+	}
+	return value, nil
+}
+
+// Version returns the ID of the last transaction, which is incremented by every write
+func (db *RpmLMDB) Version() (uint64, error) {
+	m, err := db.readMeta()
+	if err != nil {
+		return 0, err
+	}
+	return m.txnID, nil
+}
+
+// Close closes the data.mdb file
+func (db *RpmLMDB) Close() error {
+	return db.file.Close()
+}
+
+// corruptPage returns an error about the content of page pageNo
+func corruptPage(pageNo uint64, format string, args ...interface{}) error {
+	return &dbi.ErrCorruptPage{PageNo: uint32(pageNo), Err: xerrors.Errorf(format, args...)}
+}
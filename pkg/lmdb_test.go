@@ -0,0 +1,366 @@
+package rpmdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/jfrog/go-rpmdb/pkg/lmdb"
+)
+
+const testLMDBPageSize = 4096
+
+type testLMDBPair struct {
+	key, value []byte
+}
+
+// testLMDB describes the environment written by createLMDB
+type testLMDB struct {
+	order binary.ByteOrder
+	// packagesFlags are the flags of the Packages database, e.g. MDB_INTEGERKEY
+	packagesFlags uint16
+	// nodesPerPage limits the nodes of leaf and branch pages, so that small databases
+	// have branch pages as well
+	nodesPerPage int
+	// txnID is the ID of the transaction which added the pairs, 1 by default
+	txnID uint64
+}
+
+// createLMDB writes a 64-bit LMDB environment like rpm's data.mdb with the pairs in the
+// Packages database. The first meta page is the empty environment, the second one the
+// transaction which added the pairs. It's for environments liblmdb doesn't write on
+// this host, e.g. of other byte orders or broken ones, the fixtures are written by liblmdb.
+func createLMDB(t testing.TB, path string, env testLMDB, pairs ...testLMDBPair) {
+	t.Helper()
+
+	order := env.order
+	if order == nil {
+		order = binary.LittleEndian
+	}
+	txnID := env.txnID
+	if txnID == 0 {
+		txnID = 1
+	}
+	nodesPerPage := env.nodesPerPage
+	if nodesPerPage == 0 {
+		nodesPerPage = 1 << 16
+	}
+
+	var pages [][]byte
+	newPage := func(flags uint16) (uint64, []byte) {
+		pgno := uint64(len(pages))
+		p := make([]byte, testLMDBPageSize)
+		order.PutUint64(p, pgno)
+		order.PutUint16(p[10:], flags)
+		order.PutUint16(p[12:], 16)
+		order.PutUint16(p[14:], testLMDBPageSize)
+		pages = append(pages, p)
+		return pgno, p
+	}
+	node := func(lo uint32, flags uint16, key, data []byte) []byte {
+		n := make([]byte, 8, 8+len(key)+len(data)+1)
+		order.PutUint32(n, lo)
+		order.PutUint16(n[4:], flags)
+		order.PutUint16(n[6:], uint16(len(key)))
+		n = append(append(n, key...), data...)
+		if len(n)%2 != 0 {
+			n = append(n, 0)
+		}
+		return n
+	}
+	// addNode stores a node at the top of the free space of a page, if there is room
+	addNode := func(p []byte, n []byte) bool {
+		lower, upper := int(order.Uint16(p[12:])), int(order.Uint16(p[14:]))
+		if lower+2 > upper-len(n) || (lower-16)/2 >= nodesPerPage {
+			return false
+		}
+		upper -= len(n)
+		copy(p[upper:], n)
+		order.PutUint16(p[lower:], uint16(upper))
+		order.PutUint16(p[12:], uint16(lower+2))
+		order.PutUint16(p[14:], uint16(upper))
+		return true
+	}
+	pgnoBytes := func(pgno uint64) []byte {
+		b := make([]byte, 8)
+		order.PutUint64(b, pgno)
+		return b
+	}
+
+	// meta pages
+	newPage(0x08)
+	newPage(0x08)
+
+	sorted := append([]testLMDBPair(nil), pairs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if env.packagesFlags&0x08 != 0 {
+			return order.Uint32(sorted[i].key) < order.Uint32(sorted[j].key)
+		}
+		return bytes.Compare(sorted[i].key, sorted[j].key) < 0
+	})
+
+	type child struct {
+		pgno uint64
+		key  []byte
+	}
+	var leaves []child
+	var leaf []byte
+	var overflowPages uint64
+	nodeMax := ((testLMDBPageSize-16)/2)&^1 - 2
+	for _, pair := range sorted {
+		n := node(uint32(len(pair.value)), 0, pair.key, pair.value)
+		if len(n) > nodeMax {
+			// F_BIGDATA, the value is on consecutive overflow pages
+			count := (16 + len(pair.value) + testLMDBPageSize - 1) / testLMDBPageSize
+			first, p := newPage(0x04)
+			order.PutUint32(p[12:], uint32(count))
+			for i := 1; i < count; i++ {
+				newPage(0)
+			}
+			data := pages[first : first+uint64(count)]
+			for i, rest := 0, pair.value; len(rest) > 0; i++ {
+				offset := 0
+				if i == 0 {
+					offset = 16
+				}
+				rest = rest[copy(data[i][offset:], rest):]
+			}
+			overflowPages += uint64(count)
+			n = node(uint32(len(pair.value)), 0x01, pair.key, pgnoBytes(first))
+		}
+		if leaf == nil || !addNode(leaf, n) {
+			var pgno uint64
+			pgno, leaf = newPage(0x02)
+			leaves = append(leaves, child{pgno: pgno, key: pair.key})
+			require.True(t, addNode(leaf, n), "leaf page overflow")
+		}
+	}
+
+	packages := make([]byte, 48)
+	order.PutUint16(packages[4:], env.packagesFlags)
+	order.PutUint64(packages[40:], ^uint64(0))
+	if len(leaves) > 0 {
+		depth, branchPages := 1, 0
+		level := leaves
+		for len(level) > 1 {
+			var parents []child
+			var branch []byte
+			for _, c := range level {
+				if branch == nil || !addNode(branch, node(uint32(c.pgno), uint16(c.pgno>>32), c.key, nil)) {
+					var pgno uint64
+					pgno, branch = newPage(0x01)
+					parents = append(parents, child{pgno: pgno, key: c.key})
+					branchPages++
+					// the key of the first node of a branch page is empty
+					require.True(t, addNode(branch, node(uint32(c.pgno), uint16(c.pgno>>32), nil, nil)))
+				}
+			}
+			level = parents
+			depth++
+		}
+		order.PutUint16(packages[6:], uint16(depth))
+		order.PutUint64(packages[8:], uint64(branchPages))
+		order.PutUint64(packages[16:], uint64(len(leaves)))
+		order.PutUint64(packages[24:], overflowPages)
+		order.PutUint64(packages[32:], uint64(len(pairs)))
+		order.PutUint64(packages[40:], level[0].pgno)
+	}
+
+	// the main database holds the named databases
+	mainRoot, mainLeaf := newPage(0x02)
+	require.True(t, addNode(mainLeaf, node(48, 0x02, []byte("Packages"), packages)))
+
+	writeMeta := func(p []byte, root uint64, txnID uint64) {
+		m := p[16:]
+		order.PutUint32(m, 0xBEEFC0DE)
+		order.PutUint32(m[4:], 1)
+		order.PutUint64(m[16:], uint64(len(pages)*testLMDBPageSize))
+		free := m[24:]
+		order.PutUint32(free, testLMDBPageSize)
+		order.PutUint64(free[40:], ^uint64(0))
+		main := m[72:]
+		order.PutUint64(main[40:], root)
+		if root != ^uint64(0) {
+			order.PutUint16(main[6:], 1)
+			order.PutUint64(main[16:], 1)
+			order.PutUint64(main[32:], 1)
+		}
+		order.PutUint64(m[120:], uint64(len(pages)-1))
+		order.PutUint64(m[128:], txnID)
+	}
+	writeMeta(pages[0], ^uint64(0), txnID-1)
+	writeMeta(pages[1], mainRoot, txnID)
+
+	require.NoError(t, os.WriteFile(path, bytes.Join(pages, nil), 0644))
+}
+
+// lmdbHeaders returns the Packages pairs of headers numbered from 1, and the next free
+// instance number in the key 0 like rpm
+func lmdbHeaders(order binary.ByteOrder, headers ...[]byte) []testLMDBPair {
+	key := func(hnum uint32) []byte {
+		b := make([]byte, 4)
+		order.PutUint32(b, hnum)
+		return b
+	}
+	pairs := []testLMDBPair{{key: key(0), value: key(uint32(len(headers) + 1))}}
+	for i, header := range headers {
+		pairs = append(pairs, testLMDBPair{key: key(uint32(i + 1)), value: header})
+	}
+	return pairs
+}
+
+func TestRpmDB_LMDB(t *testing.T) {
+	tests := []struct {
+		name string
+		// file is an environment written by liblmdb with the headers pkg1 to pkg<n>, which
+		// are larger than half a page for every 50th instance
+		file string
+		// create writes the environment instead, liblmdb only writes the byte order of the host
+		create func(t *testing.T) string
+		n      int
+		opts   []Option
+		// sorted is set if the keys are in the order of the instance numbers
+		sorted bool
+	}{
+		{
+			name:   "integer keys and branch pages",
+			file:   "testdata/lmdb-integerkey/data.mdb",
+			n:      400,
+			sorted: true,
+		},
+		{
+			name:   "mmap",
+			file:   "testdata/lmdb-integerkey/data.mdb",
+			n:      400,
+			opts:   []Option{WithMmap()},
+			sorted: true,
+		},
+		{
+			name: "big endian",
+			create: func(t *testing.T) string {
+				var headers [][]byte
+				for i := 1; i <= 50; i++ {
+					provides := []string{fmt.Sprintf("pkg%d", i)}
+					for j := 0; i%50 == 0 && j < 300; j++ {
+						provides = append(provides, fmt.Sprintf("pkg%d-capability-%d", i, j))
+					}
+					headers = append(headers, testIndexedHeader(fmt.Sprintf("pkg%d", i), "1.0", 1000, provides...))
+				}
+				path := filepath.Join(t.TempDir(), "data.mdb")
+				createLMDB(t, path, testLMDB{nodesPerPage: 3, order: binary.BigEndian}, lmdbHeaders(binary.BigEndian, headers...)...)
+				return path
+			},
+			n:      50,
+			sorted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.file
+			if tt.create != nil {
+				path = tt.create(t)
+			}
+			db, err := Open(path, tt.opts...)
+			require.NoError(t, err)
+			defer db.Close()
+			require.IsType(t, &lmdb.RpmLMDB{}, db.db)
+
+			pkgs, err := db.ListPackages()
+			require.NoError(t, err)
+			require.Len(t, pkgs, tt.n)
+
+			got := make(map[string]uint32)
+			for _, pkg := range pkgs {
+				got[pkg.Name] = pkg.DBInstance
+			}
+			for i := 1; i <= tt.n; i++ {
+				assert.Equal(t, uint32(i), got[fmt.Sprintf("pkg%d", i)])
+			}
+			if tt.sorted {
+				for i, pkg := range pkgs {
+					assert.Equal(t, uint32(i+1), pkg.DBInstance)
+				}
+			}
+
+			pkg, err := db.PackageByInstance(context.Background(), 50)
+			require.NoError(t, err)
+			assert.Equal(t, "pkg50", pkg.Name)
+			assert.Len(t, pkg.Provides, 301)
+		})
+	}
+}
+
+func TestRpmDB_LMDBErrors(t *testing.T) {
+	header := testIndexedHeader("bash", "5.2", 1000, "bash")
+
+	tests := []struct {
+		name string
+		// modify changes the written environment
+		modify      func(t *testing.T, path string)
+		env         testLMDB
+		wantOpenErr error
+		wantErr     bool
+	}{
+		{
+			name: "32-bit environment",
+			modify: func(t *testing.T, path string) {
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				// the meta of 32-bit environments starts after a 12 bytes page header
+				copy(data[12:], data[16:24])
+				require.NoError(t, os.WriteFile(path, data, 0644))
+			},
+			wantOpenErr: lmdb.ErrorUnsupported,
+		},
+		{
+			name:        "duplicate keys",
+			env:         testLMDB{packagesFlags: 0x04},
+			wantOpenErr: lmdb.ErrorUnsupported,
+		},
+		{
+			name: "corrupt leaf page",
+			modify: func(t *testing.T, path string) {
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				// the leaf of Packages follows the meta pages
+				copy(data[2*testLMDBPageSize:], make([]byte, testLMDBPageSize))
+				require.NoError(t, os.WriteFile(path, data, 0644))
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "data.mdb")
+			createLMDB(t, path, tt.env, lmdbHeaders(binary.LittleEndian, header)...)
+			if tt.modify != nil {
+				tt.modify(t, path)
+			}
+
+			db, err := Open(path)
+			if tt.wantOpenErr != nil {
+				assert.True(t, xerrors.Is(err, tt.wantOpenErr), err)
+				return
+			}
+			require.NoError(t, err)
+			defer db.Close()
+
+			_, err = db.ListPackages()
+			if tt.wantErr {
+				var pageErr *ErrCorruptPage
+				assert.True(t, xerrors.As(err, &pageErr), err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
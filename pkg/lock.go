@@ -0,0 +1,270 @@
+package rpmdb
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"golang.org/x/xerrors"
+)
+
+/* rpm serializes the transactions on a database with an fcntl lock on .rpm.lock in
+   %_dbpath. A transaction holds an exclusive lock until it's done, so that a reader
+   holding a shared lock never sees the database half written.
+
+   ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.18.0-release/lib/rpmlock.c
+
+   fcntl locks belong to the process rather than to the file descriptor, and closing any
+   descriptor of the file releases them. The shared lock is therefore taken once per lock
+   file and process, and released when the last read holding it is done.
+*/
+
+const (
+	// lockFileName is the lock file of rpm in %_dbpath
+	lockFileName = ".rpm.lock"
+	// lockRetryInterval is the interval of polling for a lock held by a transaction
+	lockRetryInterval = 50 * time.Millisecond
+	// lockedReadAttempts limits the reads of a database which is modified while it's read
+	lockedReadAttempts = 3
+)
+
+// dbLock is the state of a database opened WithLock
+type dbLock struct {
+	// path is the database, which is reopened when it's modified
+	path    string
+	opts    options
+	file    string
+	timeout time.Duration
+
+	// mu guards RpmDB.db, which is replaced when reopening
+	mu sync.Mutex
+	// version is the version of RpmDB.db when it was opened
+	version uint64
+	// readers counts the reads using each backend
+	readers map[dbi.RpmDBInterface]int
+	// stale are the replaced backends which are still read, they are closed once their last
+	// read is done
+	stale map[dbi.RpmDBInterface]struct{}
+}
+
+// processLock is a shared lock on a lock file held by this process
+type processLock struct {
+	// mu serializes taking the lock
+	mu   sync.Mutex
+	refs int
+	// held is set once the lock is taken, file is nil if there is no lock file
+	held bool
+	file *os.File
+}
+
+var processLocks = struct {
+	sync.Mutex
+	files map[string]*processLock
+}{files: make(map[string]*processLock)}
+
+func openLocked(path string, o options) (*RpmDB, error) {
+	file := o.lockFile
+	if file == "" {
+		file = filepath.Join(filepath.Dir(path), lockFileName)
+	}
+	l := &dbLock{
+		path:    path,
+		opts:    o,
+		file:    file,
+		timeout: o.lockTimeout,
+		readers: make(map[dbi.RpmDBInterface]int),
+		stale:   make(map[dbi.RpmDBInterface]struct{}),
+	}
+
+	unlock, err := l.acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	d, err := open(path, o)
+	if err != nil {
+		return nil, err
+	}
+	if l.version, err = version(d.db); err != nil {
+		d.Close()
+		return nil, err
+	}
+	d.lock = l
+	return d, nil
+}
+
+// acquire takes a shared lock on the lock file, waiting up to the timeout for a transaction
+func (l *dbLock) acquire(ctx context.Context) (func(), error) {
+	processLocks.Lock()
+	pl, ok := processLocks.files[l.file]
+	if !ok {
+		pl = &processLock{}
+		processLocks.files[l.file] = pl
+	}
+	pl.refs++
+	processLocks.Unlock()
+
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if !pl.held {
+		lockCtx, cancel := context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+
+		file, err := lockShared(lockCtx, l.file)
+		if err != nil {
+			l.release(pl)
+			if ctx.Err() == nil && xerrors.Is(err, context.DeadlineExceeded) {
+				return nil, xerrors.Errorf("%s: %w", l.file, ErrLocked)
+			}
+			return nil, err
+		}
+		pl.held, pl.file = true, file
+	}
+	return func() { l.release(pl) }, nil
+}
+
+func (l *dbLock) release(pl *processLock) {
+	processLocks.Lock()
+	defer processLocks.Unlock()
+
+	pl.refs--
+	if pl.refs > 0 {
+		return
+	}
+	if pl.file != nil {
+		// closing the file releases the lock
+		pl.file.Close()
+	}
+	delete(processLocks.files, l.file)
+}
+
+// current returns the backend, which is reopened first if the database was modified since
+// it was opened, and its version. done must be called once the backend isn't read anymore.
+func (d *RpmDB) current() (db dbi.RpmDBInterface, v uint64, done func(), err error) {
+	l := d.lock
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	v, err = version(d.db)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if v != l.version {
+		reopened, err := open(l.path, l.opts)
+		if err != nil {
+			return nil, 0, nil, xerrors.Errorf("failed to reopen the modified database: %w", err)
+		}
+		if v, err = version(reopened.db); err != nil {
+			reopened.Close()
+			return nil, 0, nil, err
+		}
+		if l.readers[d.db] > 0 {
+			l.stale[d.db] = struct{}{}
+		} else {
+			closeBackend(d.db)
+		}
+		d.db, l.version = reopened.db, v
+	}
+
+	db = d.db
+	l.readers[db]++
+	return db, l.version, func() { l.done(db) }, nil
+}
+
+// done ends a read of db, which is closed if it was replaced and this was its last read
+func (l *dbLock) done(db dbi.RpmDBInterface) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.readers[db]--
+	if l.readers[db] > 0 {
+		return
+	}
+	delete(l.readers, db)
+	if _, ok := l.stale[db]; ok {
+		delete(l.stale, db)
+		closeBackend(db)
+	}
+}
+
+// dbVersion returns the version of the database on disk, 0 if the backend can't detect modifications
+func (d *RpmDB) dbVersion() (uint64, error) {
+	if d.lock != nil {
+		d.lock.mu.Lock()
+		defer d.lock.mu.Unlock()
+	}
+	return version(d.db)
+}
+
+// locked calls fn with the backend. If the database was opened WithLock, fn is called while
+// holding the lock of rpm, and called again if the database was modified while fn ran anyway.
+func (d *RpmDB) locked(ctx context.Context, fn func(db dbi.RpmDBInterface) error) error {
+	if d.lock == nil {
+		return fn(d.db)
+	}
+
+	for attempt := 0; attempt < lockedReadAttempts; attempt++ {
+		unlock, err := d.lock.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		db, before, done, err := d.current()
+		if err != nil {
+			unlock()
+			return err
+		}
+
+		err = fn(db)
+		after, verr := version(db)
+		done()
+		unlock()
+		if verr != nil {
+			return verr
+		}
+		if after == before {
+			return err
+		}
+		// fn may have failed on a torn page as well, read the modified database again
+	}
+	return xerrors.Errorf("%s: %w", d.lock.path, ErrConcurrentModification)
+}
+
+// version returns the version of backends which can detect modifications, 0 otherwise
+func version(db dbi.RpmDBInterface) (uint64, error) {
+	v, ok := db.(dbi.Versioner)
+	if !ok {
+		return 0, nil
+	}
+	n, err := v.Version()
+	if err != nil {
+		return 0, xerrors.Errorf("failed to read the database version: %w", err)
+	}
+	return n, nil
+}
+
+// closeLocked closes the backend and the replaced backends which are still read
+func (d *RpmDB) closeLocked() error {
+	d.lock.mu.Lock()
+	defer d.lock.mu.Unlock()
+
+	err := closeBackend(d.db)
+	for db := range d.lock.stale {
+		if cerr := closeBackend(db); err == nil {
+			err = cerr
+		}
+	}
+	d.lock.stale = make(map[dbi.RpmDBInterface]struct{})
+	return err
+}
+
+func closeBackend(db dbi.RpmDBInterface) error {
+	if c, ok := db.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package rpmdb
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// lockShared takes a shared fcntl lock on the whole lock file, polling until ctx is done
+// while a transaction holds the exclusive lock. It returns nil if there is no lock file,
+// rpm creates it with the first transaction.
+func lockShared(ctx context.Context, path string) (*os.File, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, xerrors.Errorf("failed to open lock file: %w", err)
+	}
+
+	lk := syscall.Flock_t{
+		Type:   syscall.F_RDLCK,
+		Whence: io.SeekStart,
+	}
+	for {
+		err = syscall.FcntlFlock(file.Fd(), syscall.F_SETLK, &lk)
+		if err == nil {
+			return file, nil
+		}
+		if err != syscall.EAGAIN && err != syscall.EACCES && err != syscall.EINTR {
+			file.Close()
+			return nil, xerrors.Errorf("failed to lock %s: %w", path, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
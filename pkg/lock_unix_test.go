@@ -0,0 +1,333 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package rpmdb
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+)
+
+// TestLockHelperProcess isn't a test, it holds the lock like a transaction of rpm for holdLock
+func TestLockHelperProcess(t *testing.T) {
+	path := os.Getenv("GO_RPMDB_LOCK_FILE")
+	if path == "" {
+		t.Skip("helper process of holdLock")
+	}
+	hold, err := time.ParseDuration(os.Getenv("GO_RPMDB_LOCK_HOLD"))
+	require.NoError(t, err)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	require.NoError(t, err)
+	lk := syscall.Flock_t{
+		Type:   syscall.F_WRLCK,
+		Whence: io.SeekStart,
+	}
+	require.NoError(t, syscall.FcntlFlock(file.Fd(), syscall.F_SETLKW, &lk))
+	fmt.Println("locked")
+
+	time.Sleep(hold)
+	os.Exit(0)
+}
+
+// holdLock locks path exclusively in another process for the duration, fcntl locks of the
+// same process never conflict
+func holdLock(t *testing.T, path string, duration time.Duration) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$")
+	cmd.Env = append(os.Environ(), "GO_RPMDB_LOCK_FILE="+path, "GO_RPMDB_LOCK_HOLD="+duration.String())
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "locked\n", line)
+}
+
+func TestRpmDB_Lock(t *testing.T) {
+	tests := []struct {
+		name string
+		// hold is how long a transaction holds the lock, no lock file is created if 0
+		hold    time.Duration
+		timeout time.Duration
+		ctx     func() context.Context
+		wantErr error
+	}{
+		{
+			name: "no lock file",
+		},
+		{
+			name: "transaction finishes",
+			hold: 200 * time.Millisecond,
+		},
+		{
+			name:    "transaction exceeds the timeout",
+			hold:    time.Minute,
+			timeout: 200 * time.Millisecond,
+			wantErr: ErrLocked,
+		},
+		{
+			name: "canceled",
+			hold: time.Minute,
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			},
+			wantErr: context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := createSQLite3DB(t, testIndexedHeader("bash", "5.2", 1000, "bash"))
+			timeout := 10 * time.Second
+			if tt.timeout > 0 {
+				timeout = tt.timeout
+			}
+			db, err := Open(path, WithLock(timeout))
+			require.NoError(t, err)
+			defer db.Close()
+
+			if tt.hold > 0 {
+				holdLock(t, filepath.Join(filepath.Dir(path), ".rpm.lock"), tt.hold)
+			}
+			ctx := context.Background()
+			if tt.ctx != nil {
+				ctx = tt.ctx()
+			}
+
+			pkgs, err := db.ListPackagesWithContext(ctx)
+			if tt.wantErr != nil {
+				assert.True(t, xerrors.Is(err, tt.wantErr), err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, pkgs, 1)
+		})
+	}
+}
+
+func TestRpmDB_LockFile(t *testing.T) {
+	path := createSQLite3DB(t, testIndexedHeader("bash", "5.2", 1000, "bash"))
+	lockFile := filepath.Join(t.TempDir(), "rpm.lock")
+	holdLock(t, lockFile, time.Minute)
+
+	_, err := Open(path, WithLock(100*time.Millisecond), WithLockFile(lockFile))
+	assert.True(t, xerrors.Is(err, ErrLocked), err)
+}
+
+// testFileHeader returns a header of a package with a single file
+func testFileHeader(name, version, file string) []byte {
+	return (&headerBuilder{}).
+		addString(RPMTAG_NAME, name).
+		addString(RPMTAG_VERSION, version).
+		addString(RPMTAG_RELEASE, "1").
+		addStringArray(RPMTAG_BASENAMES, filepath.Base(file)).
+		addStringArray(RPMTAG_DIRNAMES, filepath.Dir(file)+"/").
+		addInt32(RPMTAG_DIRINDEXES, 0).
+		bytes()
+}
+
+func TestRpmDB_LockReopen(t *testing.T) {
+	bash := testFileHeader("bash", "5.2", "/usr/bin/bash")
+	zsh := testFileHeader("zsh", "5.9", "/usr/bin/zsh")
+	bdbPair := func(hnum uint32, header []byte) testBDBPair {
+		key := make([]byte, 4)
+		binary.LittleEndian.PutUint32(key, hnum)
+		return testBDBPair{key: key, value: header, offPage: true}
+	}
+
+	tests := []struct {
+		name    string
+		create  func(t *testing.T) string
+		install func(t *testing.T, path string)
+	}{
+		{
+			name: "SQLite3",
+			create: func(t *testing.T) string {
+				return createSQLite3DB(t, bash)
+			},
+			install: func(t *testing.T, path string) {
+				db, err := sql.Open("sqlite", path)
+				require.NoError(t, err)
+				defer db.Close()
+				_, err = db.Exec("INSERT INTO Packages (blob) VALUES (?)", zsh)
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "BerkeleyDB",
+			create: func(t *testing.T) string {
+				path := filepath.Join(t.TempDir(), "Packages")
+				createBDBHash(t, path, 4096, bdbPair(1, bash))
+				return path
+			},
+			install: func(t *testing.T, path string) {
+				createBDBHash(t, path, 4096, bdbPair(1, bash), bdbPair(2, zsh))
+			},
+		},
+		{
+			name: "LMDB",
+			create: func(t *testing.T) string {
+				path := filepath.Join(t.TempDir(), "data.mdb")
+				createLMDB(t, path, testLMDB{}, lmdbHeaders(binary.LittleEndian, bash)...)
+				return path
+			},
+			install: func(t *testing.T, path string) {
+				createLMDB(t, path, testLMDB{txnID: 2}, lmdbHeaders(binary.LittleEndian, bash, zsh)...)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.create(t)
+			db, err := Open(path, WithLock(time.Second))
+			require.NoError(t, err)
+			defer db.Close()
+
+			ctx := context.Background()
+			pkgs, err := db.ListPackages()
+			require.NoError(t, err)
+			assert.Len(t, pkgs, 1)
+			_, err = db.WhoOwns(ctx, "/usr/bin/zsh")
+			require.Error(t, err)
+
+			tt.install(t, path)
+
+			pkgs, err = db.ListPackages()
+			require.NoError(t, err)
+			require.Len(t, pkgs, 2)
+			assert.Equal(t, "zsh", pkgs[1].Name)
+
+			// the path index is rebuilt from the modified database
+			pkgs, err = db.WhoOwns(ctx, "/usr/bin/zsh")
+			require.NoError(t, err)
+			require.Len(t, pkgs, 1)
+			assert.Equal(t, "zsh", pkgs[0].Name)
+		})
+	}
+}
+
+// closeTrackingDB records whether the backend was closed
+type closeTrackingDB struct {
+	dbi.RpmDBInterface
+	closed bool
+}
+
+func (db *closeTrackingDB) Version() (uint64, error) {
+	return db.RpmDBInterface.(dbi.Versioner).Version()
+}
+
+func (db *closeTrackingDB) Close() error {
+	db.closed = true
+	return db.RpmDBInterface.(io.Closer).Close()
+}
+
+func TestRpmDB_LockCloseStale(t *testing.T) {
+	path := createSQLite3DB(t, testIndexedHeader("bash", "5.2", 1000, "bash"))
+	db, err := Open(path, WithLock(time.Second))
+	require.NoError(t, err)
+	defer db.Close()
+
+	install := func(name string) {
+		sqlDB, err := sql.Open("sqlite", path)
+		require.NoError(t, err)
+		defer sqlDB.Close()
+		_, err = sqlDB.Exec("INSERT INTO Packages (blob) VALUES (?)", testIndexedHeader(name, "1.0", 2000, name))
+		require.NoError(t, err)
+	}
+
+	// a replaced backend without reads is closed right away
+	replaced := &closeTrackingDB{RpmDBInterface: db.db}
+	db.db = replaced
+	install("zsh")
+	pkgs, err := db.ListPackages()
+	require.NoError(t, err)
+	assert.Len(t, pkgs, 2)
+	assert.True(t, replaced.closed)
+	assert.Empty(t, db.lock.stale)
+
+	// a replaced backend which is still read is closed once the read is done
+	read := &closeTrackingDB{RpmDBInterface: db.db}
+	db.db = read
+	err = db.locked(context.Background(), func(dbi.RpmDBInterface) error {
+		if read.closed {
+			return nil
+		}
+		install("fish")
+		pkgs, err := db.ListPackages()
+		require.NoError(t, err)
+		assert.Len(t, pkgs, 3)
+		assert.False(t, read.closed)
+		assert.Len(t, db.lock.stale, 1)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, read.closed)
+	assert.Empty(t, db.lock.stale)
+	assert.Empty(t, db.lock.readers[read])
+}
+
+// modifyingDB modifies the database when it's read, like an rpm which doesn't take the lock
+type modifyingDB struct {
+	dbi.RpmDBInterface
+	modify func()
+}
+
+func (db *modifyingDB) Read(ctx context.Context) <-chan dbi.Entry {
+	if db.modify != nil {
+		db.modify()
+	}
+	return db.RpmDBInterface.Read(ctx)
+}
+
+func (db *modifyingDB) Version() (uint64, error) {
+	return db.RpmDBInterface.(dbi.Versioner).Version()
+}
+
+func TestRpmDB_LockConcurrentModification(t *testing.T) {
+	path := createSQLite3DB(t, testIndexedHeader("bash", "5.2", 1000, "bash"))
+	db, err := Open(path, WithLock(time.Second))
+	require.NoError(t, err)
+	defer db.Close()
+
+	reads := 0
+	db.db = &modifyingDB{
+		RpmDBInterface: db.db,
+		modify: func() {
+			reads++
+			sqlDB, err := sql.Open("sqlite", path)
+			require.NoError(t, err)
+			defer sqlDB.Close()
+			_, err = sqlDB.Exec("INSERT INTO Packages (blob) VALUES (?)", testIndexedHeader("zsh", "5.9", 2000, "zsh"))
+			require.NoError(t, err)
+		},
+	}
+
+	// the database read while it was modified is reopened and read again
+	pkgs, err := db.ListPackages()
+	require.NoError(t, err)
+	assert.Len(t, pkgs, 2)
+	assert.Equal(t, 1, reads)
+}
@@ -0,0 +1,351 @@
+package ndb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"golang.org/x/xerrors"
+)
+
+/* The secondary indexes of the NDB backend live in a single Index.db file next to
+   Packages.db. It's an "xdb" container of blobs, one per index:
+
+   https://github.com/rpm-software-management/rpm/blob/rpm-4.17.0-release/lib/backend/ndb/rpmxdb.c
+
+   Index.db File Format:
+   =====================
+
+   32 bytes "XDB Header": magic "RpmX", version, generation, the number of slot
+   pages, the page size and the user generation, which is the generation of
+   Packages.db the indexes were updated for. The header overlays the first two slots.
+
+   Slot Pages: 16 byte slots describing the blobs. The first word holds the slot magic
+   in the lower 24 bits and the blob subtag in the upper 8 bits, followed by the blob
+   tag (the rpm tag of the index), the first page and the number of pages.
+
+   Each blob is a hash table:
+
+   https://github.com/rpm-software-management/rpm/blob/rpm-4.17.0-release/lib/backend/ndb/rpmidx.c
+
+   64 bytes "Index Header", "IdxD" magic; then nslots 8 byte slots holding the key
+   offset (the upper xmask bits are hash bits) and the data, then nslots 4 byte
+   overflow words and finally the keys, each prefixed by its length.
+
+   A key is looked up by probing the slots from its murmur hash until an empty slot.
+*/
+
+const (
+	xdbHeaderMagic = 'R' | 'p'<<8 | 'm'<<16 | 'X'<<24
+	xdbVersion     = 0
+	xdbSlotMagic   = 'S' | 'l'<<8 | 'o'<<16
+	xdbSlotSize    = 16
+
+	idxHeaderMagic = 'I' | 'd'<<8 | 'x'<<16 | 'D'<<24
+	idxVersion     = 0
+	idxSlotOffset  = 64
+	idxSubtag      = 0
+
+	// IndexFileName is the name of the index database next to Packages.db
+	IndexFileName = "Index.db"
+)
+
+type xdbHeader struct {
+	HeaderMagic    uint32
+	Version        uint32
+	Generation     uint32
+	SlotNPages     uint32
+	PageSize       uint32
+	UserGeneration uint32
+	_              [2]uint32
+}
+
+type xdbSlot struct {
+	SlotMagic uint32
+	BlobTag   uint32
+	StartPage uint32
+	PageCount uint32
+}
+
+type idxHeader struct {
+	HeaderMagic uint32
+	Version     uint32
+	Generation  uint32
+	NSlots      uint32
+	UsedSlots   uint32
+	_           uint32
+	XMask       uint32
+	KeyEnd      uint32
+	KeyExcess   uint32
+}
+
+var (
+	// ErrorInvalidIndex is returned for Index.db files with a bad header or slot
+	ErrorInvalidIndex = xerrors.New("invalid or unsupported NDB index")
+	// ErrorStaleIndex is returned when Index.db wasn't updated with the last change of Packages.db
+	ErrorStaleIndex = xerrors.New("NDB index generation doesn't match Packages.db")
+)
+
+// IndexDB is a read-only Index.db
+type IndexDB struct {
+	file   dbi.File
+	header xdbHeader
+	slots  []xdbSlot
+}
+
+// OpenIndex opens an Index.db file
+func OpenIndex(path string) (*IndexDB, error) {
+	file, err := dbi.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	x, err := readIndexHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return x, nil
+}
+
+func readIndexHeader(file dbi.File) (*IndexDB, error) {
+	r := io.NewSectionReader(file, 0, file.Size())
+
+	var hdr xdbHeader
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, xerrors.Errorf("failed to read xdb header: %w", err)
+	}
+	if hdr.HeaderMagic != xdbHeaderMagic || hdr.Version != xdbVersion {
+		return nil, xerrors.Errorf("xdb header magic %x, version %d: %w", hdr.HeaderMagic, hdr.Version, ErrorInvalidIndex)
+	}
+	if hdr.PageSize == 0 || hdr.PageSize%xdbSlotSize != 0 || hdr.SlotNPages == 0 || hdr.SlotNPages > 2048 {
+		return nil, xerrors.Errorf("page size %d, %d slot pages: %w", hdr.PageSize, hdr.SlotNPages, ErrorInvalidIndex)
+	}
+
+	// the first two slots are actually the XDB Header
+	slots := make([]xdbSlot, hdr.SlotNPages*hdr.PageSize/xdbSlotSize-2)
+	if err := binary.Read(r, binary.LittleEndian, &slots); err != nil {
+		return nil, xerrors.Errorf("failed to read xdb slots: %w", err)
+	}
+	for _, slot := range slots {
+		if slot != (xdbSlot{}) && slot.SlotMagic&0xffffff != xdbSlotMagic {
+			return nil, xerrors.Errorf("xdb slot magic %x: %w", slot.SlotMagic, ErrorInvalidIndex)
+		}
+	}
+
+	return &IndexDB{
+		file:   file,
+		header: hdr,
+		slots:  slots,
+	}, nil
+}
+
+// UserGeneration returns the generation of Packages.db the indexes were last updated for
+func (x *IndexDB) UserGeneration() uint32 {
+	return x.header.UserGeneration
+}
+
+// Lookup returns the items of a key in the index of the rpm tag, dbi.ErrIndexNotFound
+// if there is no such index
+func (x *IndexDB) Lookup(tag int32, key []byte) ([]dbi.IndexItem, error) {
+	blob, err := x.readBlob(tag)
+	if err != nil {
+		return nil, err
+	}
+	return lookupIndexBlob(blob, key)
+}
+
+// Close closes the Index.db file
+func (x *IndexDB) Close() error {
+	return x.file.Close()
+}
+
+// readBlob returns the hash table blob of the index tag
+func (x *IndexDB) readBlob(tag int32) ([]byte, error) {
+	for _, slot := range x.slots {
+		if slot == (xdbSlot{}) {
+			continue
+		}
+		if slot.BlobTag != uint32(tag) || slot.SlotMagic>>24 != idxSubtag || slot.PageCount == 0 {
+			continue
+		}
+
+		blob := make([]byte, int64(slot.PageCount)*int64(x.header.PageSize))
+		if _, err := x.file.ReadAt(blob, int64(slot.StartPage)*int64(x.header.PageSize)); err != nil {
+			return nil, xerrors.Errorf("failed to read index blob: %w", err)
+		}
+		return blob, nil
+	}
+	return nil, xerrors.Errorf("no blob for tag %d: %w", tag, dbi.ErrIndexNotFound)
+}
+
+// lookupIndexBlob returns the items of a key in a rpmidx hash table
+func lookupIndexBlob(blob []byte, key []byte) ([]dbi.IndexItem, error) {
+	var hdr idxHeader
+	if err := binary.Read(bytes.NewReader(blob), binary.LittleEndian, &hdr); err != nil {
+		return nil, xerrors.Errorf("failed to read index header: %w", err)
+	}
+	if hdr.HeaderMagic != idxHeaderMagic || hdr.Version != idxVersion {
+		return nil, xerrors.Errorf("index header magic %x, version %d: %w", hdr.HeaderMagic, hdr.Version, ErrorInvalidIndex)
+	}
+
+	keyStart := uint64(idxSlotOffset) + uint64(hdr.NSlots)*12
+	if keyStart+uint64(hdr.KeyEnd) > uint64(len(blob)) {
+		return nil, xerrors.Errorf("index exceeds blob: %d slots, key end %d: %w", hdr.NSlots, hdr.KeyEnd, ErrorInvalidIndex)
+	}
+	slots := blob[idxSlotOffset : idxSlotOffset+8*hdr.NSlots]
+	overflow := blob[idxSlotOffset+8*hdr.NSlots : keyStart]
+	keys := blob[keyStart : keyStart+uint64(hdr.KeyEnd)]
+
+	if hdr.NSlots == 0 || hdr.NSlots&(hdr.NSlots-1) != 0 {
+		return nil, xerrors.Errorf("%d slots: %w", hdr.NSlots, ErrorInvalidIndex)
+	}
+	keyh := murmurHash(key)
+	hmask := hdr.NSlots - 1
+
+	// the same key is stored in a slot per item, so the probing continues after matches
+	// ref. rpmidxGetInternal() in https://github.com/rpm-software-management/rpm/blob/rpm-4.17.0-release/lib/backend/ndb/rpmidx.c
+	var items []dbi.IndexItem
+	h, hh := keyh&hmask, uint32(7)
+	for probes := uint32(0); probes < hdr.NSlots; probes++ {
+		x := binary.LittleEndian.Uint32(slots[8*h:])
+		if x == 0 {
+			break
+		}
+		// 1 marks a deleted slot, slots with other hash bits hold other keys
+		if x != 1 && (x^keyh)&hdr.XMask == 0 && equalKey(keys, x&^hdr.XMask, key) {
+			data := binary.LittleEndian.Uint32(slots[8*h+4:])
+			items = append(items, decodeData(data, binary.LittleEndian.Uint32(overflow[4*h:])))
+		}
+		h = (h + hh) & hmask
+		hh++
+	}
+	return items, nil
+}
+
+// murmurHash is the hash function of rpmidx, a variant of MurmurHash2
+// ref. murmurhash() in https://github.com/rpm-software-management/rpm/blob/rpm-4.17.0-release/lib/backend/ndb/rpmidx.c
+func murmurHash(s []byte) uint32 {
+	const m = 0x5bd1e995
+	h := uint32(len(s)) * m
+	for ; len(s) >= 4; s = s[4:] {
+		h += binary.LittleEndian.Uint32(s)
+		h *= m
+		h ^= h >> 16
+	}
+	switch len(s) {
+	case 3:
+		h += uint32(s[2]) << 16
+		fallthrough
+	case 2:
+		h += uint32(s[1]) << 8
+		fallthrough
+	case 1:
+		h += uint32(s[0])
+		h *= m
+		h ^= h >> 16
+	}
+	return h
+}
+
+// decodeData returns the item of the data word of a slot and its overflow word
+// ref. decodedata() in https://github.com/rpm-software-management/rpm/blob/rpm-4.17.0-release/lib/backend/ndb/rpmidx.c
+func decodeData(data, overflow uint32) dbi.IndexItem {
+	switch {
+	case data&0x80000000 != 0:
+		return dbi.IndexItem{HeaderNum: overflow, TagNum: data ^ 0x80000000}
+	case data&0x40000000 != 0:
+		return dbi.IndexItem{HeaderNum: data & 0xffffff, TagNum: (data ^ 0x40000000) >> 24}
+	default:
+		return dbi.IndexItem{HeaderNum: data & 0xfffff, TagNum: data >> 20}
+	}
+}
+
+// equalKey compares the length prefixed key at offset with key. Keys shorter than 255 bytes
+// have a single byte length, longer ones 255 and a 2 bytes length, or 255, 255, 255 and a
+// 4 bytes length from 65535 bytes on.
+// ref. equalkey() in https://github.com/rpm-software-management/rpm/blob/rpm-4.17.0-release/lib/backend/ndb/rpmidx.c
+func equalKey(keys []byte, offset uint32, key []byte) bool {
+	if uint64(offset)+uint64(len(key))+1 > uint64(len(keys)) {
+		return false
+	}
+	p := keys[offset:]
+	switch keyl := len(key); {
+	case keyl > 0 && keyl < 255:
+		if int(p[0]) != keyl {
+			return false
+		}
+		p = p[1:]
+	case keyl < 65535:
+		if len(p) < 3 || p[0] != 255 || int(binary.LittleEndian.Uint16(p[1:])) != keyl {
+			return false
+		}
+		p = p[3:]
+	default:
+		if len(p) < 7 || p[0] != 255 || p[1] != 255 || p[2] != 255 || uint64(binary.LittleEndian.Uint32(p[3:])) != uint64(keyl) {
+			return false
+		}
+		p = p[7:]
+	}
+	return len(p) >= len(key) && bytes.Equal(p[:len(key)], key)
+}
+
+// ReadIndex looks up a key in Index.db next to Packages.db
+func (db *RpmNDB) ReadIndex(ctx context.Context, index dbi.Index, key []byte) ([]dbi.IndexItem, error) {
+	tag := index.Tag()
+	if tag == 0 {
+		return nil, xerrors.Errorf("unknown index %q: %w", index, dbi.ErrIndexNotFound)
+	}
+
+	indexPath := filepath.Join(filepath.Dir(db.file.Name()), IndexFileName)
+	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
+		return nil, xerrors.Errorf("no %s: %w", IndexFileName, dbi.ErrIndexNotFound)
+	}
+
+	x, err := OpenIndex(indexPath)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open %s: %w", IndexFileName, err)
+	}
+	defer x.Close()
+
+	// rpm rebuilds the indexes when their generation differs
+	if x.UserGeneration() != db.generation {
+		return nil, xerrors.Errorf("generation %d, Packages.db %d: %w", x.UserGeneration(), db.generation, ErrorStaleIndex)
+	}
+
+	items, err := x.Lookup(tag, key)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %s index: %w", index, err)
+	}
+	return items, nil
+}
+
+// ReadHeader returns the header blob of the package with the index hnum
+func (db *RpmNDB) ReadHeader(ctx context.Context, hnum uint32) ([]byte, error) {
+	for _, slot := range db.slots {
+		if hnum == 0 || slot.PkgIndex != hnum {
+			continue
+		}
+		return db.readBlob(slot)
+	}
+	return nil, xerrors.Errorf("no package with index %d: %w", hnum, dbi.ErrHeaderNotFound)
+}
+
+// Version returns the generation of Packages.db, rpm increments it with every written or
+// deleted blob
+func (db *RpmNDB) Version() (uint64, error) {
+	var hdr ndbHeader
+	if err := binary.Read(io.NewSectionReader(db.file, 0, int64(binary.Size(hdr))), binary.LittleEndian, &hdr); err != nil {
+		return 0, xerrors.Errorf("failed to read metadata: %w", err)
+	}
+	return uint64(hdr.NDBGeneration), nil
+}
+
+// Close closes the Packages.db file
+func (db *RpmNDB) Close() error {
+	return db.file.Close()
+}
@@ -22,12 +22,12 @@ SOFTWARE.
 package ndb
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"fmt"
+	"hash/adler32"
 	"io"
-	"os"
-	"unsafe"
 
 	dbi "github.com/jfrog/go-rpmdb/pkg/db"
 	"golang.org/x/xerrors"
@@ -53,9 +53,9 @@ import (
    index is zero). If a Slot Entry is non-free, the BlkOffset points to the "Block".
 
    The "Block" has a "Blob Header", directly followed by the "Blob" (the actual package headers) and
-   a Blob "tail" of up to 16 bytes. The "Blob" is checksummed using Adler32 from RFC1950.
-
-   This implementation is currently not validating the blob checksum.
+   a Blob "Tail". The Blob Header holds the generation of the database when the blob was written,
+   the Blob Tail the Adler32 checksum (RFC1950) of the block up to the tail and the blob length.
+   Every Blob is validated like rpmpkgVerifyblob() does.
 */
 
 type ndbHeader struct {
@@ -74,31 +74,100 @@ type ndbSlotEntry struct {
 }
 
 type ndbBlobHeader struct {
-	BlobMagic uint32
-	PkgIndex  uint32
+	BlobMagic      uint32
+	PkgIndex       uint32
+	BlobGeneration uint32
+	BlobLen        uint32
+}
+
+type ndbBlobTail struct {
 	BlobCkSum uint32
 	BlobLen   uint32
+	BlobMagic uint32
 }
 
 type RpmNDB struct {
-	file  *os.File
-	slots []ndbSlotEntry
+	file       dbi.File
+	size       int64
+	generation uint32
+	slots      []ndbSlotEntry
 }
 
 const NDB_SlotEntriesPerPage = 4096 / 16 /* 16 == unsafe.Sizeof(NDBSlotEntry) */
 const NDB_HeaderMagic = 'R' | 'p'<<8 | 'm'<<16 | 'P'<<24
 const NDB_DBVersion = 0
 
-var ErrorInvalidNDB = xerrors.Errorf("invalid or unsupported NDB format")
+const (
+	NDB_SlotMagic      = 'S' | 'l'<<8 | 'o'<<16 | 't'<<24
+	NDB_BlobMagic      = 'B' | 'l'<<8 | 'b'<<16 | 'S'<<24
+	NDB_BlobTailMagic  = 'B' | 'l'<<8 | 'b'<<16 | 'E'<<24
+	NDB_BlkSize        = 16
+	NDB_SlotSize       = 16
+	NDB_BlobHeaderSize = 16
+	NDB_BlobTailSize   = 12
+)
+
+var (
+	ErrorInvalidNDB = xerrors.Errorf("invalid or unsupported NDB format: %w", dbi.ErrNotRPMDB)
+
+	// ErrorBadSlotMagic is returned for slots without the slot magic
+	ErrorBadSlotMagic = xerrors.New("bad NDB slot magic")
+	// ErrorBlobMagic is returned for blocks without the blob magic
+	ErrorBlobMagic = xerrors.New("unexpected NDB blob magic")
+	// ErrorBlobPkgIndex is returned when the blob belongs to another package than the slot
+	ErrorBlobPkgIndex = xerrors.New("NDB blob of another package")
+	// ErrorBlockCount is returned when the number of blocks of a slot doesn't fit the blob length
+	ErrorBlockCount = xerrors.New("NDB block count doesn't match the blob length")
+	// ErrorBlobTail is returned for blobs with a bad tail magic or length
+	ErrorBlobTail = xerrors.New("invalid NDB blob tail")
+	// ErrorChecksum is returned when the Adler32 checksum of a blob doesn't match
+	ErrorChecksum = xerrors.New("NDB blob checksum mismatch")
+	// ErrorGeneration is returned for blobs written by a later generation than the database
+	ErrorGeneration = xerrors.New("NDB blob generation exceeds the database generation")
+)
+
+// BlobError is returned for blobs which fail the validation
+type BlobError struct {
+	PkgIndex uint32
+	// Offset is the byte offset of the blob in Packages.db
+	Offset int64
+	Err    error
+}
+
+func (e *BlobError) Error() string {
+	return fmt.Sprintf("invalid NDB blob for pkg %d at offset %d: %v", e.PkgIndex, e.Offset, e.Err)
+}
+
+func (e *BlobError) Unwrap() error {
+	return e.Err
+}
 
 func Open(path string) (*RpmNDB, error) {
-	file, err := os.Open(path)
+	file, err := dbi.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := OpenFile(file)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
+	return db, nil
+}
+
+// OpenFile opens Packages.db in an opened file, e.g. one mapped by dbi.OpenMmap.
+// The file is closed by Close.
+func OpenFile(file dbi.File) (*RpmNDB, error) {
+	// the file is only read at offsets, so that several goroutines can read it
+	r := io.NewSectionReader(file, 0, file.Size())
 
 	hdrBuff := ndbHeader{}
-	err = binary.Read(file, binary.LittleEndian, &hdrBuff)
+	err := binary.Read(r, binary.LittleEndian, &hdrBuff)
+	if xerrors.Is(err, io.EOF) || xerrors.Is(err, io.ErrUnexpectedEOF) {
+		// too short for the header, it may be another format
+		return nil, ErrorInvalidNDB
+	}
 	if err != nil {
 		return nil, xerrors.Errorf("failed to read metadata: %w", err)
 	}
@@ -115,15 +184,17 @@ func Open(path string) (*RpmNDB, error) {
 
 	// the first two slots are actually the NDB Header
 	slots := make([]ndbSlotEntry, hdrBuff.SlotNPages*NDB_SlotEntriesPerPage-2)
-	err = binary.Read(file, binary.LittleEndian, &slots)
+	err = binary.Read(r, binary.LittleEndian, &slots)
 
 	if err != nil {
 		return nil, xerrors.Errorf("failed to read NDB slot pages: %w", err)
 	}
 
 	return &RpmNDB{
-		file:  file,
-		slots: slots,
+		file:       file,
+		size:       file.Size(),
+		generation: hdrBuff.NDBGeneration,
+		slots:      slots,
 	}, nil
 }
 
@@ -133,14 +204,19 @@ func (db *RpmNDB) Read(ctx context.Context) <-chan dbi.Entry {
 	go func() {
 		defer close(entries)
 
-		const NDB_BlobHeaderSize = int64(unsafe.Sizeof(ndbBlobHeader{}))
-
-		for _, slot := range db.slots {
-			const NDB_SlotMagic = 'S' | 'l'<<8 | 'o'<<16 | 't'<<24
+		for i, slot := range db.slots {
+			if err := ctx.Err(); err != nil {
+				entries <- dbi.Entry{
+					Err: err,
+				}
+				return
+			}
 			if slot.SlotMagic != NDB_SlotMagic {
-				fmt.Println("bad slot magic", slot.SlotMagic)
 				entries <- dbi.Entry{
-					Err: xerrors.Errorf("bad slot Magic: %x", slot.SlotMagic),
+					Err: &dbi.ErrCorruptPage{
+						PageNo: uint32((i + 2) / NDB_SlotEntriesPerPage),
+						Err:    xerrors.Errorf("slot %d: %x: %w", i, slot.SlotMagic, ErrorBadSlotMagic),
+					},
 				}
 				return
 			}
@@ -148,43 +224,120 @@ func (db *RpmNDB) Read(ctx context.Context) <-chan dbi.Entry {
 			if slot.PkgIndex == 0 {
 				continue
 			}
-			// Seek to Blob
-			_, err := db.file.Seek(int64(slot.BlkOffset)*NDB_BlobHeaderSize, io.SeekStart)
+
+			blob, err := db.readBlob(slot)
 			if err != nil {
 				entries <- dbi.Entry{
-					Err: err,
+					HeaderNum: slot.PkgIndex,
+					Err:       err,
 				}
 				return
 			}
+			entries <- dbi.Entry{
+				Value:     blob,
+				HeaderNum: slot.PkgIndex,
+			}
+		}
+	}()
 
-			// Read Blob Header
-			blobHeaderBuff := ndbBlobHeader{}
-			err = binary.Read(db.file, binary.LittleEndian, &blobHeaderBuff)
-			if err != nil {
-				entries <- dbi.Entry{
-					Err: err,
-				}
+	return entries
+}
+
+// readBlob reads and validates the blob of a slot
+// ref. rpmpkgVerifyblob() in https://github.com/rpm-software-management/rpm/blob/rpm-4.17.0-release/lib/backend/ndb/rpmpkg.c
+func (db *RpmNDB) readBlob(slot ndbSlotEntry) ([]byte, error) {
+	offset := int64(slot.BlkOffset) * NDB_BlkSize
+	blobError := func(err error) error {
+		return &BlobError{PkgIndex: slot.PkgIndex, Offset: offset, Err: err}
+	}
+
+	size := int64(slot.BlkCount) * NDB_BlkSize
+	if size < NDB_BlobHeaderSize+NDB_BlobTailSize {
+		return nil, blobError(xerrors.Errorf("%d blocks: %w", slot.BlkCount, ErrorBlockCount))
+	}
+	if offset+size > db.size {
+		return nil, blobError(xerrors.Errorf("%d blocks exceed the file: %w", slot.BlkCount, io.ErrUnexpectedEOF))
+	}
+	block := make([]byte, size)
+	if _, err := db.file.ReadAt(block, offset); err != nil {
+		return nil, blobError(xerrors.Errorf("failed to read blob: %w", err))
+	}
+
+	var blobHeader ndbBlobHeader
+	if err := binary.Read(bytes.NewReader(block), binary.LittleEndian, &blobHeader); err != nil {
+		return nil, blobError(xerrors.Errorf("failed to unpack blob header: %w", err))
+	}
+	if blobHeader.BlobMagic != NDB_BlobMagic {
+		return nil, blobError(xerrors.Errorf("%x: %w", blobHeader.BlobMagic, ErrorBlobMagic))
+	}
+	if blobHeader.PkgIndex != slot.PkgIndex {
+		return nil, blobError(xerrors.Errorf("pkg %d: %w", blobHeader.PkgIndex, ErrorBlobPkgIndex))
+	}
+	if size != (NDB_BlobHeaderSize+int64(blobHeader.BlobLen)+NDB_BlobTailSize+NDB_BlkSize-1)/NDB_BlkSize*NDB_BlkSize {
+		return nil, blobError(xerrors.Errorf("%d blocks for %d bytes: %w", slot.BlkCount, blobHeader.BlobLen, ErrorBlockCount))
+	}
+	if blobHeader.BlobGeneration > db.generation {
+		return nil, blobError(xerrors.Errorf("generation %d > %d: %w", blobHeader.BlobGeneration, db.generation, ErrorGeneration))
+	}
+
+	var blobTail ndbBlobTail
+	if err := binary.Read(bytes.NewReader(block[size-NDB_BlobTailSize:]), binary.LittleEndian, &blobTail); err != nil {
+		return nil, blobError(xerrors.Errorf("failed to unpack blob tail: %w", err))
+	}
+	if blobTail.BlobMagic != NDB_BlobTailMagic || blobTail.BlobLen != blobHeader.BlobLen {
+		return nil, blobError(xerrors.Errorf("magic %x, length %d: %w", blobTail.BlobMagic, blobTail.BlobLen, ErrorBlobTail))
+	}
+	// the checksum covers the header, the blob and the padding
+	if sum := adler32.Checksum(block[:size-NDB_BlobTailSize]); sum != blobTail.BlobCkSum {
+		return nil, blobError(xerrors.Errorf("%x != %x: %w", sum, blobTail.BlobCkSum, ErrorChecksum))
+	}
+
+	return block[NDB_BlobHeaderSize : NDB_BlobHeaderSize+blobHeader.BlobLen], nil
+}
+
+// Salvage reads the packages like Read, but skips the slots and blobs which can't be read
+func (db *RpmNDB) Salvage(ctx context.Context) <-chan dbi.Entry {
+	entries := make(chan dbi.Entry)
+
+	go func() {
+		defer close(entries)
+
+		diagnose := func(slot int, hnum uint32, offset int64, err error) {
+			entries <- dbi.Entry{
+				HeaderNum: hnum,
+				Err: &dbi.Diagnostic{
+					Backend:   "ndb",
+					Slot:      slot,
+					HeaderNum: hnum,
+					Offset:    offset,
+					Err:       err,
+				},
+			}
+		}
+
+		for i, slot := range db.slots {
+			if err := ctx.Err(); err != nil {
+				entries <- dbi.Entry{Err: err}
 				return
 			}
-			const NDB_BlobMagic = 'B' | 'l'<<8 | 'b'<<16 | 'S'<<24
-			if blobHeaderBuff.BlobMagic != NDB_BlobMagic {
-				entries <- dbi.Entry{
-					Err: xerrors.Errorf("unexpected NDB blob Magic for pkg %d: %x", slot.PkgIndex, blobHeaderBuff.BlobMagic),
-				}
+
+			if slot.SlotMagic != NDB_SlotMagic {
+				// the first two slots are actually the NDB Header
+				diagnose(i, 0, int64(i+2)*NDB_SlotSize, xerrors.Errorf("%x: %w", slot.SlotMagic, ErrorBadSlotMagic))
+				continue
 			}
-			if blobHeaderBuff.PkgIndex != slot.PkgIndex {
-				entries <- dbi.Entry{
-					Err: xerrors.Errorf("failed to find NDB blob for pkg %d", slot.PkgIndex),
-				}
+			if slot.PkgIndex == 0 {
+				continue
 			}
-			// ### check that BlkCnt == (BLOBHEAD_SIZE + bloblen + BLOBTAIL_SIZE + PKGDB_BLK_SIZE - 1) / PKGDB_BLK_SIZE)
 
-			// Read Blob Content
-			BlobEntry := make([]byte, blobHeaderBuff.BlobLen)
-			_, err = db.file.Read(BlobEntry)
+			blob, err := db.readBlob(slot)
+			if err != nil {
+				diagnose(i, slot.PkgIndex, int64(slot.BlkOffset)*NDB_BlkSize, err)
+				continue
+			}
 			entries <- dbi.Entry{
-				Value: BlobEntry,
-				Err:   err,
+				Value:     blob,
+				HeaderNum: slot.PkgIndex,
 			}
 		}
 	}()
@@ -0,0 +1,130 @@
+package rpmdb
+
+import (
+	"encoding/binary"
+	"hash/adler32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/jfrog/go-rpmdb/pkg/ndb"
+)
+
+// replaceNDBBlobs returns a copy of a Packages.db written by rpm with the blobs in place of
+// the first packages, the slots of the other packages are freed
+func replaceNDBBlobs(t *testing.T, data []byte, blobs ...[]byte) []byte {
+	t.Helper()
+
+	data = append([]byte{}, data...)
+	slotCount := int(binary.LittleEndian.Uint32(data[12:]))*ndb.NDB_SlotEntriesPerPage - 2
+	for i := 0; i < slotCount; i++ {
+		slot := data[32+i*ndb.NDB_SlotSize:]
+		if binary.LittleEndian.Uint32(slot[4:]) == 0 {
+			continue
+		}
+		if len(blobs) == 0 {
+			copy(slot[4:], make([]byte, 12))
+			continue
+		}
+		blob := blobs[0]
+		blobs = blobs[1:]
+
+		// the block keeps its place, the header and the generation of the blob
+		offset := int(binary.LittleEndian.Uint32(slot[8:])) * ndb.NDB_BlkSize
+		blkCount := int(binary.LittleEndian.Uint32(slot[12:]))
+		size := (ndb.NDB_BlobHeaderSize + len(blob) + ndb.NDB_BlobTailSize + ndb.NDB_BlkSize - 1) / ndb.NDB_BlkSize * ndb.NDB_BlkSize
+		require.LessOrEqual(t, size, blkCount*ndb.NDB_BlkSize, "blob exceeds the block")
+
+		block := data[offset : offset+size]
+		binary.LittleEndian.PutUint32(block[12:], uint32(len(blob)))
+		copy(block[ndb.NDB_BlobHeaderSize:], blob)
+		copy(block[ndb.NDB_BlobHeaderSize+len(blob):], make([]byte, size-ndb.NDB_BlobHeaderSize-len(blob)))
+		tail := block[size-ndb.NDB_BlobTailSize:]
+		binary.LittleEndian.PutUint32(tail, adler32.Checksum(block[:size-ndb.NDB_BlobTailSize]))
+		binary.LittleEndian.PutUint32(tail[4:], uint32(len(blob)))
+		binary.LittleEndian.PutUint32(tail[8:], ndb.NDB_BlobTailMagic)
+		binary.LittleEndian.PutUint32(slot[12:], uint32(size/ndb.NDB_BlkSize))
+	}
+	require.Empty(t, blobs, "not enough packages")
+	return data
+}
+
+func TestNDBBlobValidation(t *testing.T) {
+	data, err := os.ReadFile("testdata/sle15-bci/Packages.db")
+	require.NoError(t, err)
+
+	// the first slot follows the 32 bytes NDB header
+	const slot = 32
+	require.NotZero(t, binary.LittleEndian.Uint32(data[slot+4:]))
+	pkgIndex := binary.LittleEndian.Uint32(data[slot+4:])
+	blkOffset := int(binary.LittleEndian.Uint32(data[slot+8:])) * 16
+	blkEnd := blkOffset + int(binary.LittleEndian.Uint32(data[slot+12:]))*16
+
+	tests := []struct {
+		name    string
+		corrupt func(data []byte)
+		wantErr error
+	}{
+		{
+			name:    "bad slot magic",
+			corrupt: func(data []byte) { copy(data[slot:], "XXXX") },
+			wantErr: ndb.ErrorBadSlotMagic,
+		},
+		{
+			name:    "bad blob magic",
+			corrupt: func(data []byte) { copy(data[blkOffset:], "XXXX") },
+			wantErr: ndb.ErrorBlobMagic,
+		},
+		{
+			name:    "blob of another package",
+			corrupt: func(data []byte) { binary.LittleEndian.PutUint32(data[blkOffset+4:], pkgIndex+1) },
+			wantErr: ndb.ErrorBlobPkgIndex,
+		},
+		{
+			name:    "future generation",
+			corrupt: func(data []byte) { binary.LittleEndian.PutUint32(data[blkOffset+8:], 1000) },
+			wantErr: ndb.ErrorGeneration,
+		},
+		{
+			name:    "block count",
+			corrupt: func(data []byte) { binary.LittleEndian.PutUint32(data[slot+12:], 2) },
+			wantErr: ndb.ErrorBlockCount,
+		},
+		{
+			name:    "bad tail magic",
+			corrupt: func(data []byte) { copy(data[blkEnd-4:], "XXXX") },
+			wantErr: ndb.ErrorBlobTail,
+		},
+		{
+			name:    "checksum",
+			corrupt: func(data []byte) { data[blkOffset+100]++ },
+			wantErr: ndb.ErrorChecksum,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupted := append([]byte{}, data...)
+			tt.corrupt(corrupted)
+			path := filepath.Join(t.TempDir(), "Packages.db")
+			require.NoError(t, os.WriteFile(path, corrupted, 0644))
+
+			db, err := Open(path)
+			require.NoError(t, err)
+			defer db.Close()
+
+			_, err = db.ListPackages()
+			require.Error(t, err)
+			assert.True(t, xerrors.Is(err, tt.wantErr), err.Error())
+
+			var blobErr *ndb.BlobError
+			if xerrors.As(err, &blobErr) {
+				assert.Equal(t, pkgIndex, blobErr.PkgIndex)
+				assert.Equal(t, int64(blkOffset), blobErr.Offset)
+			}
+		})
+	}
+}
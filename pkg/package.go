@@ -4,10 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
-	"fmt"
+	"io"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"golang.org/x/xerrors"
 )
@@ -27,7 +26,10 @@ type PackageInfo struct {
 	PGP             string
 	SigMD5          string
 	DigestAlgorithm DigestAlgorithm
+	RPMFormat       int
 	InstallTime     int
+	InstallColor    int
+	InstPrefixes    []string
 	BaseNames       []string
 	DirIndexes      []int32
 	DirNames        []string
@@ -37,9 +39,46 @@ type PackageInfo struct {
 	FileFlags       []int32
 	UserNames       []string
 	GroupNames      []string
+	FileMTimes      []int32
+	FileLinkTos     []string
+	FileInodes      []int32
+	LongFileSizes   []int64
+	FileStates      []FileState
+	FileRdevs       []uint16
+	FileCaps        []string
+
+	PayloadFormat     string
+	PayloadCompressor string
+	PayloadFlags      string
+
+	FileSignatures      []string
+	FileSignatureLength int
+	VeritySignatures    []string
+	VeritySignatureAlgo VerityHashAlgorithm
+
+	// rpm v6 headers may carry signatures and file digests for more than one algorithm
+	OpenPGP              []string
+	FileDigestAlgorithms []DigestAlgorithm
+	FileAltDigests       []string
 
 	Provides []string
 	Requires []string
+
+	// Signature and PayloadOffset are only set for packages read with ReadPackageFile
+	Signature     *SignatureInfo
+	PayloadOffset int64
+
+	// Legacy is set for legacy headers without an immutable region, i.e. original v3 headers of
+	// rpm < 4 and the ones rpm 4.0 stored with a legacy region. The name of the package is added
+	// to their Provides like rpm does.
+	Legacy bool
+
+	// DBInstance is the header instance number in the rpmdb, like %{DBINSTANCE} of rpm -q.
+	// It's 0 for package files.
+	DBInstance uint32
+
+	// files holds the file data of packages decoded with DecodeOptions.LazyFiles until it's needed
+	files *lazyFiles
 }
 
 type FileInfo struct {
@@ -50,345 +89,561 @@ type FileInfo struct {
 	Username  string
 	Groupname string
 	Flags     FileFlags
+	State     FileState
+
+	// IMASignature is the hex encoded IMA signature (security.ima xattr) of the file
+	IMASignature string
+	// VeritySignature is the base64 encoded fs-verity PKCS#7 signature of the file
+	VeritySignature string
+	// Digests holds the digest per algorithm when the package has more than one file digest algorithm
+	Digests map[DigestAlgorithm]string
 }
 
 // ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/tagexts.c#L752
 func getNEVRA(indexEntries []indexEntry) (*PackageInfo, error) {
-	pkgInfo := &PackageInfo{}
-	for _, ie := range indexEntries {
-		switch ie.Info.Tag {
-		case RPMTAG_DIRINDEXES:
-			if ie.Info.Type != RPM_INT32_TYPE {
-				return nil, xerrors.New("invalid tag dir indexes")
-			}
-
-			dirIndexes, err := parseInt32Array(ie.Data, ie.Length)
-			if err != nil {
-				return nil, xerrors.Errorf("unable to read dir indexes: %w", err)
-			}
-			pkgInfo.DirIndexes = dirIndexes
-		case RPMTAG_DIRNAMES:
-			if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
-				return nil, xerrors.New("invalid tag dir names")
-			}
-			pkgInfo.DirNames = parseStringArray(ie.Data)
-		case RPMTAG_BASENAMES:
-			if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
-				return nil, xerrors.New("invalid tag base names")
-			}
-			pkgInfo.BaseNames = parseStringArray(ie.Data)
-		case RPMTAG_MODULARITYLABEL:
-			if ie.Info.Type != RPM_STRING_TYPE {
-				return nil, xerrors.New("invalid tag modularitylabel")
-			}
-			pkgInfo.Modularitylabel = string(bytes.TrimRight(ie.Data, "\x00"))
-		case RPMTAG_NAME:
-			if ie.Info.Type != RPM_STRING_TYPE {
-				return nil, xerrors.New("invalid tag name")
-			}
-			pkgInfo.Name = string(bytes.TrimRight(ie.Data, "\x00"))
-		case RPMTAG_EPOCH:
-			if ie.Info.Type != RPM_INT32_TYPE {
-				return nil, xerrors.New("invalid tag epoch")
-			}
+	return newPackageDecoder(DecodeOptions{}).decodeHeader(indexEntries)
+}
 
-			if ie.Data != nil {
-				value, err := parseInt32(ie.Data)
-				if err != nil {
-					return nil, xerrors.Errorf("failed to parse epoch: %w", err)
-				}
-				pkgInfo.Epoch = &value
-			}
-		case RPMTAG_VERSION:
-			if ie.Info.Type != RPM_STRING_TYPE {
-				return nil, xerrors.New("invalid tag version")
-			}
-			pkgInfo.Version = string(bytes.TrimRight(ie.Data, "\x00"))
-		case RPMTAG_RELEASE:
-			if ie.Info.Type != RPM_STRING_TYPE {
-				return nil, xerrors.New("invalid tag release")
-			}
-			pkgInfo.Release = string(bytes.TrimRight(ie.Data, "\x00"))
-		case RPMTAG_ARCH:
-			if ie.Info.Type != RPM_STRING_TYPE {
-				return nil, xerrors.New("invalid tag arch")
-			}
-			pkgInfo.Arch = string(bytes.TrimRight(ie.Data, "\x00"))
-		case RPMTAG_SOURCERPM:
-			if ie.Info.Type != RPM_STRING_TYPE {
-				return nil, xerrors.New("invalid tag sourcerpm")
-			}
-			pkgInfo.SourceRpm = string(bytes.TrimRight(ie.Data, "\x00"))
-			if pkgInfo.SourceRpm == "(none)" {
-				pkgInfo.SourceRpm = ""
-			}
-		case RPMTAG_PROVIDENAME:
-			if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
-				return nil, xerrors.New("invalid tag providename")
-			}
-			pkgInfo.Provides = parseStringArray(ie.Data)
-		case RPMTAG_REQUIRENAME:
-			if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
-				return nil, xerrors.New("invalid tag requirename")
-			}
-			pkgInfo.Requires = parseStringArray(ie.Data)
-		case RPMTAG_LICENSE:
-			if ie.Info.Type != RPM_STRING_TYPE {
-				return nil, xerrors.New("invalid tag license")
-			}
-			pkgInfo.License = string(bytes.TrimRight(ie.Data, "\x00"))
-			if pkgInfo.License == "(none)" {
-				pkgInfo.License = ""
-			}
-		case RPMTAG_VENDOR:
-			if ie.Info.Type != RPM_STRING_TYPE {
-				return nil, xerrors.New("invalid tag vendor")
-			}
-			pkgInfo.Vendor = string(bytes.TrimRight(ie.Data, "\x00"))
-			if pkgInfo.Vendor == "(none)" {
-				pkgInfo.Vendor = ""
-			}
-		case RPMTAG_SIZE:
-			if ie.Info.Type != RPM_INT32_TYPE {
-				return nil, xerrors.New("invalid tag size")
-			}
+// decodeEntries sets the fields of pkgInfo selected by fields, interning the repeated strings in strs
+func decodeEntries(pkgInfo *PackageInfo, indexEntries []indexEntry, strs stringTable, fields Fields) error {
+	for _, ie := range indexEntries {
+		if tagFields(ie.Info.Tag)&fields == 0 {
+			continue
+		}
+		if err := decodeEntry(pkgInfo, ie, strs); err != nil {
+			return corruptHeader(ie.Info.Tag, ie.DataOffset, err)
+		}
+	}
+	return nil
+}
 
-			size, err := parseInt32(ie.Data)
-			if err != nil {
-				return nil, xerrors.Errorf("failed to parse size: %w", err)
-			}
-			pkgInfo.Size = size
-		case RPMTAG_FILEDIGESTALGO:
-			// note: all digests within a package entry only supports a single digest algorithm (there may be future support for
-			// algorithm noted for each file entry, but currently unimplemented: https://github.com/rpm-software-management/rpm/blob/0b75075a8d006c8f792d33a57eae7da6b66a4591/lib/rpmtag.h#L256)
-			if ie.Info.Type != RPM_INT32_TYPE {
-				return nil, xerrors.New("invalid tag digest algo")
-			}
+// decodeEntry sets the field of the tag of an entry
+func decodeEntry(pkgInfo *PackageInfo, ie indexEntry, strs stringTable) error {
+	switch ie.Info.Tag {
+	case RPMTAG_DIRINDEXES:
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag dir indexes")
+		}
 
-			digestAlgorithm, err := parseInt32(ie.Data)
-			if err != nil {
-				return nil, xerrors.Errorf("failed to parse digest algo: %w", err)
-			}
+		dirIndexes, err := parseInt32Array(ie.Data, ie.Length)
+		if err != nil {
+			return xerrors.Errorf("unable to read dir indexes: %w", err)
+		}
+		pkgInfo.DirIndexes = dirIndexes
+	case RPMTAG_DIRNAMES:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag dir names")
+		}
+		pkgInfo.DirNames = strs.parseStringArray(ie.Data)
+	case RPMTAG_BASENAMES:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag base names")
+		}
+		pkgInfo.BaseNames = parseStringArray(ie.Data)
+	case RPMTAG_OLDFILENAMES:
+		// the file list of legacy packages and packages built with --nodirtokens, the tags of the
+		// compressed file list come later and replace it
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag old file names")
+		}
+		compressFilelist(pkgInfo, parseStringArray(ie.Data), strs)
+	case RPMTAG_MODULARITYLABEL:
+		if ie.Info.Type != RPM_STRING_TYPE {
+			return xerrors.New("invalid tag modularitylabel")
+		}
+		pkgInfo.Modularitylabel = string(bytes.TrimRight(ie.Data, "\x00"))
+	case RPMTAG_NAME:
+		if ie.Info.Type != RPM_STRING_TYPE {
+			return xerrors.New("invalid tag name")
+		}
+		pkgInfo.Name = string(bytes.TrimRight(ie.Data, "\x00"))
+	case RPMTAG_EPOCH:
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag epoch")
+		}
 
-			pkgInfo.DigestAlgorithm = DigestAlgorithm(digestAlgorithm)
-		case RPMTAG_FILESIZES:
-			// note: there is no distinction between int32, uint32, and []uint32
-			if ie.Info.Type != RPM_INT32_TYPE {
-				return nil, xerrors.New("invalid tag file-sizes")
-			}
-			fileSizes, err := parseInt32Array(ie.Data, ie.Length)
-			if err != nil {
-				return nil, xerrors.Errorf("failed to parse file-sizes: %w", err)
-			}
-			pkgInfo.FileSizes = fileSizes
-		case RPMTAG_FILEDIGESTS:
-			if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
-				return nil, xerrors.New("invalid tag file-digests")
-			}
-			pkgInfo.FileDigests = parseStringArray(ie.Data)
-		case RPMTAG_FILEMODES:
-			// note: there is no distinction between int16, uint16, and []uint16
-			if ie.Info.Type != RPM_INT16_TYPE {
-				return nil, xerrors.New("invalid tag file-modes")
-			}
-			fileModes, err := uint16Array(ie.Data, ie.Length)
-			if err != nil {
-				return nil, xerrors.Errorf("failed to parse file-modes: %w", err)
-			}
-			pkgInfo.FileModes = fileModes
-		case RPMTAG_FILEFLAGS:
-			// note: there is no distinction between int32, uint32, and []uint32
-			if ie.Info.Type != RPM_INT32_TYPE {
-				return nil, xerrors.New("invalid tag file-flags")
-			}
-			fileFlags, err := parseInt32Array(ie.Data, ie.Length)
-			if err != nil {
-				return nil, xerrors.Errorf("failed to parse file-flags: %w", err)
-			}
-			pkgInfo.FileFlags = fileFlags
-		case RPMTAG_FILEUSERNAME:
-			if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
-				return nil, xerrors.New("invalid tag usernames")
-			}
-			pkgInfo.UserNames = parseStringArray(ie.Data)
-		case RPMTAG_FILEGROUPNAME:
-			if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
-				return nil, xerrors.New("invalid tag groupnames")
-			}
-			pkgInfo.GroupNames = parseStringArray(ie.Data)
-		case RPMTAG_SUMMARY:
-			// some libraries have a string value instead of international string, so accounting for both
-			if ie.Info.Type != RPM_I18NSTRING_TYPE && ie.Info.Type != RPM_STRING_TYPE {
-				return nil, xerrors.New("invalid tag summary")
-			}
-			// since this is an international string, getting the first null terminated string
-			pkgInfo.Summary = string(bytes.Split(ie.Data, []byte{0})[0])
-		case RPMTAG_INSTALLTIME:
-			if ie.Info.Type != RPM_INT32_TYPE {
-				return nil, xerrors.New("invalid tag installtime")
-			}
-			installTime, err := parseInt32(ie.Data)
+		if ie.Data != nil {
+			value, err := parseInt32(ie.Data)
 			if err != nil {
-				return nil, xerrors.Errorf("failed to parse installtime: %w", err)
-			}
-			pkgInfo.InstallTime = installTime
-		case RPMTAG_SIGMD5:
-			// It is just string that we need to encode to hex
-			digest := bytes.TrimRight(ie.Data, "\x00")
-			pkgInfo.SigMD5 = hex.EncodeToString(digest)
-		case RPMTAG_PGP:
-			type pgpSig struct {
-				_          [3]byte
-				Date       int32
-				KeyID      [8]byte
-				PubKeyAlgo uint8
-				HashAlgo   uint8
+				return xerrors.Errorf("failed to parse epoch: %w", err)
 			}
+			pkgInfo.Epoch = &value
+		}
+	case RPMTAG_VERSION:
+		if ie.Info.Type != RPM_STRING_TYPE {
+			return xerrors.New("invalid tag version")
+		}
+		pkgInfo.Version = string(bytes.TrimRight(ie.Data, "\x00"))
+	case RPMTAG_RELEASE:
+		if ie.Info.Type != RPM_STRING_TYPE {
+			return xerrors.New("invalid tag release")
+		}
+		pkgInfo.Release = string(bytes.TrimRight(ie.Data, "\x00"))
+	case RPMTAG_ARCH:
+		if ie.Info.Type != RPM_STRING_TYPE {
+			return xerrors.New("invalid tag arch")
+		}
+		pkgInfo.Arch = string(bytes.TrimRight(ie.Data, "\x00"))
+	case RPMTAG_SOURCERPM:
+		if ie.Info.Type != RPM_STRING_TYPE {
+			return xerrors.New("invalid tag sourcerpm")
+		}
+		pkgInfo.SourceRpm = string(bytes.TrimRight(ie.Data, "\x00"))
+		if pkgInfo.SourceRpm == "(none)" {
+			pkgInfo.SourceRpm = ""
+		}
+	case RPMTAG_PROVIDENAME:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag providename")
+		}
+		pkgInfo.Provides = parseStringArray(ie.Data)
+	case RPMTAG_REQUIRENAME:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag requirename")
+		}
+		pkgInfo.Requires = parseStringArray(ie.Data)
+	case RPMTAG_LICENSE:
+		if ie.Info.Type != RPM_STRING_TYPE {
+			return xerrors.New("invalid tag license")
+		}
+		pkgInfo.License = string(bytes.TrimRight(ie.Data, "\x00"))
+		if pkgInfo.License == "(none)" {
+			pkgInfo.License = ""
+		}
+	case RPMTAG_VENDOR:
+		if ie.Info.Type != RPM_STRING_TYPE {
+			return xerrors.New("invalid tag vendor")
+		}
+		pkgInfo.Vendor = string(bytes.TrimRight(ie.Data, "\x00"))
+		if pkgInfo.Vendor == "(none)" {
+			pkgInfo.Vendor = ""
+		}
+	case RPMTAG_SIZE:
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag size")
+		}
 
-			type textSig struct {
-				_          [2]byte
-				PubKeyAlgo uint8
-				HashAlgo   uint8
-				_          [4]byte
-				Date       int32
-				_          [4]byte
-				KeyID      [8]byte
-			}
+		size, err := parseInt32(ie.Data)
+		if err != nil {
+			return xerrors.Errorf("failed to parse size: %w", err)
+		}
+		pkgInfo.Size = size
+	case RPMTAG_FILEDIGESTALGO:
+		// note: this is the algorithm of RPMTAG_FILEDIGESTS, rpm v6 headers list additional algorithms in RPMTAG_FILEDIGESTALGOS
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag digest algo")
+		}
 
-			type pgp4Sig struct {
-				_          [2]byte
-				PubKeyAlgo uint8
-				HashAlgo   uint8
-				_          [17]byte
-				KeyID      [8]byte
-				_          [2]byte
-				Date       int32
-			}
+		digestAlgorithm, err := parseInt32(ie.Data)
+		if err != nil {
+			return xerrors.Errorf("failed to parse digest algo: %w", err)
+		}
 
-			pubKeyLookup := map[uint8]string{
-				0x01: "RSA",
-			}
-			hashLookup := map[uint8]string{
-				0x02: "SHA1",
-				0x08: "SHA256",
+		pkgInfo.DigestAlgorithm = DigestAlgorithm(digestAlgorithm)
+	case RPMTAG_FILEDIGESTALGOS:
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag digest algos")
+		}
+		digestAlgorithms, err := parseInt32Array(ie.Data, ie.Length)
+		if err != nil {
+			return xerrors.Errorf("failed to parse digest algos: %w", err)
+		}
+		for _, digestAlgorithm := range digestAlgorithms {
+			pkgInfo.FileDigestAlgorithms = append(pkgInfo.FileDigestAlgorithms, DigestAlgorithm(digestAlgorithm))
+		}
+	case RPMTAG_FILEALTDIGESTS:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag file-alt-digests")
+		}
+		pkgInfo.FileAltDigests = parseStringArray(ie.Data)
+	case RPMTAG_RPMFORMAT:
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag rpmformat")
+		}
+		format, err := parseInt32(ie.Data)
+		if err != nil {
+			return xerrors.Errorf("failed to parse rpmformat: %w", err)
+		}
+		pkgInfo.RPMFormat = format
+	case RPMTAG_OPENPGP:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag openpgp")
+		}
+		for _, signature := range parseStringArray(ie.Data) {
+			sig, err := parseOpenPGPSignature(signature)
+			if err != nil {
+				return xerrors.Errorf("invalid OpenPGP signature: %w", err)
 			}
+			pkgInfo.OpenPGP = append(pkgInfo.OpenPGP, sig)
+		}
+	case RPMTAG_FILESIZES:
+		// note: there is no distinction between int32, uint32, and []uint32
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag file-sizes")
+		}
+		fileSizes, err := parseInt32Array(ie.Data, ie.Length)
+		if err != nil {
+			return xerrors.Errorf("failed to parse file-sizes: %w", err)
+		}
+		pkgInfo.FileSizes = fileSizes
+	case RPMTAG_LONGFILESIZES:
+		if ie.Info.Type != RPM_INT64_TYPE {
+			return xerrors.New("invalid tag long-file-sizes")
+		}
+		longFileSizes, err := parseInt64Array(ie.Data, ie.Length)
+		if err != nil {
+			return xerrors.Errorf("failed to parse long-file-sizes: %w", err)
+		}
+		pkgInfo.LongFileSizes = longFileSizes
+	case RPMTAG_FILEMTIMES:
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag file-mtimes")
+		}
+		fileMTimes, err := parseInt32Array(ie.Data, ie.Length)
+		if err != nil {
+			return xerrors.Errorf("failed to parse file-mtimes: %w", err)
+		}
+		pkgInfo.FileMTimes = fileMTimes
+	case RPMTAG_FILEINODES:
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag file-inodes")
+		}
+		fileInodes, err := parseInt32Array(ie.Data, ie.Length)
+		if err != nil {
+			return xerrors.Errorf("failed to parse file-inodes: %w", err)
+		}
+		pkgInfo.FileInodes = fileInodes
+	case RPMTAG_FILELINKTOS:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag file-linktos")
+		}
+		pkgInfo.FileLinkTos = parseStringArray(ie.Data)
+	case RPMTAG_FILESTATES:
+		if ie.Info.Type != RPM_CHAR_TYPE {
+			return xerrors.New("invalid tag file-states")
+		}
+		if int(ie.Info.Count) > len(ie.Data) {
+			return xerrors.New("invalid tag file-states count")
+		}
+		fileStates := make([]FileState, ie.Info.Count)
+		for i, state := range ie.Data[:ie.Info.Count] {
+			fileStates[i] = FileState(int8(state))
+		}
+		pkgInfo.FileStates = fileStates
+	case RPMTAG_FILERDEVS:
+		if ie.Info.Type != RPM_INT16_TYPE {
+			return xerrors.New("invalid tag file-rdevs")
+		}
+		fileRdevs, err := uint16Array(ie.Data, ie.Length)
+		if err != nil {
+			return xerrors.Errorf("failed to parse file-rdevs: %w", err)
+		}
+		pkgInfo.FileRdevs = fileRdevs
+	case RPMTAG_FILECAPS:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag file-caps")
+		}
+		pkgInfo.FileCaps = parseStringArray(ie.Data)
+	case RPMTAG_PAYLOADFORMAT:
+		if ie.Info.Type != RPM_STRING_TYPE {
+			return xerrors.New("invalid tag payloadformat")
+		}
+		pkgInfo.PayloadFormat = string(bytes.TrimRight(ie.Data, "\x00"))
+	case RPMTAG_PAYLOADCOMPRESSOR:
+		if ie.Info.Type != RPM_STRING_TYPE {
+			return xerrors.New("invalid tag payloadcompressor")
+		}
+		pkgInfo.PayloadCompressor = string(bytes.TrimRight(ie.Data, "\x00"))
+	case RPMTAG_PAYLOADFLAGS:
+		if ie.Info.Type != RPM_STRING_TYPE {
+			return xerrors.New("invalid tag payloadflags")
+		}
+		pkgInfo.PayloadFlags = string(bytes.TrimRight(ie.Data, "\x00"))
+	case RPMTAG_FILEDIGESTS:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag file-digests")
+		}
+		pkgInfo.FileDigests = parseStringArray(ie.Data)
+	case RPMTAG_FILEMODES:
+		// note: there is no distinction between int16, uint16, and []uint16
+		if ie.Info.Type != RPM_INT16_TYPE {
+			return xerrors.New("invalid tag file-modes")
+		}
+		fileModes, err := uint16Array(ie.Data, ie.Length)
+		if err != nil {
+			return xerrors.Errorf("failed to parse file-modes: %w", err)
+		}
+		pkgInfo.FileModes = fileModes
+	case RPMTAG_FILEFLAGS:
+		// note: there is no distinction between int32, uint32, and []uint32
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag file-flags")
+		}
+		fileFlags, err := parseInt32Array(ie.Data, ie.Length)
+		if err != nil {
+			return xerrors.Errorf("failed to parse file-flags: %w", err)
+		}
+		pkgInfo.FileFlags = fileFlags
+	case RPMTAG_FILEUSERNAME:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag usernames")
+		}
+		pkgInfo.UserNames = strs.parseStringArray(ie.Data)
+	case RPMTAG_FILEGROUPNAME:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag groupnames")
+		}
+		pkgInfo.GroupNames = strs.parseStringArray(ie.Data)
+	case RPMTAG_FILESIGNATURES:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag file-signatures")
+		}
+		pkgInfo.FileSignatures = parseStringArray(ie.Data)
+	case RPMTAG_FILESIGNATURELENGTH:
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag file-signature-length")
+		}
+		length, err := parseInt32(ie.Data)
+		if err != nil {
+			return xerrors.Errorf("failed to parse file-signature-length: %w", err)
+		}
+		pkgInfo.FileSignatureLength = length
+	case RPMTAG_VERITYSIGNATURES:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag verity-signatures")
+		}
+		pkgInfo.VeritySignatures = parseStringArray(ie.Data)
+	case RPMTAG_VERITYSIGNATUREALGO:
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag verity-signature-algo")
+		}
+		algo, err := parseInt32(ie.Data)
+		if err != nil {
+			return xerrors.Errorf("failed to parse verity-signature-algo: %w", err)
+		}
+		pkgInfo.VeritySignatureAlgo = VerityHashAlgorithm(algo)
+	case RPMTAG_SUMMARY:
+		// some libraries have a string value instead of international string, so accounting for both
+		if ie.Info.Type != RPM_I18NSTRING_TYPE && ie.Info.Type != RPM_STRING_TYPE {
+			return xerrors.New("invalid tag summary")
+		}
+		// since this is an international string, getting the first null terminated string
+		if i := bytes.IndexByte(ie.Data, 0); i >= 0 {
+			pkgInfo.Summary = string(ie.Data[:i])
+		} else {
+			pkgInfo.Summary = string(ie.Data)
+		}
+	case RPMTAG_INSTALLTIME:
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag installtime")
+		}
+		installTime, err := parseInt32(ie.Data)
+		if err != nil {
+			return xerrors.Errorf("failed to parse installtime: %w", err)
+		}
+		pkgInfo.InstallTime = installTime
+	case RPMTAG_INSTALLCOLOR:
+		if ie.Info.Type != RPM_INT32_TYPE {
+			return xerrors.New("invalid tag installcolor")
+		}
+		installColor, err := parseInt32(ie.Data)
+		if err != nil {
+			return xerrors.Errorf("failed to parse installcolor: %w", err)
+		}
+		pkgInfo.InstallColor = installColor
+	case RPMTAG_INSTPREFIXES:
+		if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+			return xerrors.New("invalid tag instprefixes")
+		}
+		pkgInfo.InstPrefixes = parseStringArray(ie.Data)
+	case RPMTAG_SIGMD5:
+		// It is just string that we need to encode to hex
+		digest := bytes.TrimRight(ie.Data, "\x00")
+		pkgInfo.SigMD5 = hex.EncodeToString(digest)
+	case RPMTAG_PGP:
+		if ie.Info.Type != RPM_BIN_TYPE {
+			return xerrors.New("invalid PGP signature")
+		}
+		pgp, err := parsePGPSignature(ie.Data)
+		if err != nil {
+			return err
+		}
+		pkgInfo.PGP = pgp
+	}
 
-			if ie.Info.Type != RPM_BIN_TYPE {
-				return nil, xerrors.New("invalid PGP signature")
-			}
+	return nil
+}
 
-			var tag, signatureType, version uint8
-			r := bytes.NewReader(ie.Data)
-			err := binary.Read(r, binary.BigEndian, &tag)
-			if err != nil {
-				return nil, err
-			}
-			err = binary.Read(r, binary.BigEndian, &signatureType)
-			if err != nil {
-				return nil, err
-			}
-			err = binary.Read(r, binary.BigEndian, &version)
-			if err != nil {
-				return nil, err
-			}
+// compressFilelist splits the paths of an old file list into base names and directories like rpm
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/legacy.c
+func compressFilelist(pkgInfo *PackageInfo, fileNames []string, strs stringTable) {
+	dirIndex := make(map[string]int32)
+	pkgInfo.BaseNames = make([]string, 0, len(fileNames))
+	pkgInfo.DirIndexes = make([]int32, 0, len(fileNames))
+	pkgInfo.DirNames = nil
+	for _, fileName := range fileNames {
+		// the directories keep their trailing slash, paths without one are relative to ""
+		i := strings.LastIndexByte(fileName, '/') + 1
+		dirName := strs.intern([]byte(fileName[:i]))
+		index, ok := dirIndex[dirName]
+		if !ok {
+			index = int32(len(pkgInfo.DirNames))
+			dirIndex[dirName] = index
+			pkgInfo.DirNames = append(pkgInfo.DirNames, dirName)
+		}
+		pkgInfo.BaseNames = append(pkgInfo.BaseNames, fileName[i:])
+		pkgInfo.DirIndexes = append(pkgInfo.DirIndexes, index)
+	}
+}
 
-			var pubKeyAlgo, hashAlgo, pkgDate string
-			var keyId [8]byte
-
-			switch signatureType {
-			case 0x01:
-				switch version {
-				case 0x1c:
-					sig := textSig{}
-					err = binary.Read(r, binary.BigEndian, &sig)
-					if err != nil {
-						return nil, xerrors.Errorf("invalid PGP signature on decode: %w", err)
-					}
-					pubKeyAlgo = pubKeyLookup[sig.PubKeyAlgo]
-					hashAlgo = hashLookup[sig.HashAlgo]
-					pkgDate = time.Unix(int64(sig.Date), 0).UTC().Format("Mon Jan _2 15:04:05 2006")
-					keyId = sig.KeyID
-				default:
-					sig := pgpSig{}
-					err = binary.Read(r, binary.BigEndian, &sig)
-					if err != nil {
-						return nil, xerrors.Errorf("invalid PGP signature on decode: %w", err)
-					}
-					pubKeyAlgo = pubKeyLookup[sig.PubKeyAlgo]
-					hashAlgo = hashLookup[sig.HashAlgo]
-					pkgDate = time.Unix(int64(sig.Date), 0).UTC().Format("Mon Jan _2 15:04:05 2006")
-					keyId = sig.KeyID
-				}
-			case 0x02:
-				switch version {
-				case 0x33:
-					sig := pgp4Sig{}
-					err = binary.Read(r, binary.BigEndian, &sig)
-					if err != nil {
-						return nil, xerrors.Errorf("invalid PGP signature on decode: %w", err)
-					}
-					pubKeyAlgo = pubKeyLookup[sig.PubKeyAlgo]
-					hashAlgo = hashLookup[sig.HashAlgo]
-					pkgDate = time.Unix(int64(sig.Date), 0).UTC().Format("Mon Jan _2 15:04:05 2006")
-					keyId = sig.KeyID
-				default:
-					sig := pgpSig{}
-					err = binary.Read(r, binary.BigEndian, &sig)
-					if err != nil {
-						return nil, xerrors.Errorf("invalid PGP signature on decode: %w", err)
-					}
-					pubKeyAlgo = pubKeyLookup[sig.PubKeyAlgo]
-					hashAlgo = hashLookup[sig.HashAlgo]
-					pkgDate = time.Unix(int64(sig.Date), 0).UTC().Format("Mon Jan _2 15:04:05 2006")
-					keyId = sig.KeyID
-				}
-			}
-			pkgInfo.PGP = fmt.Sprintf("%s/%s, %s, Key ID %x", pubKeyAlgo, hashAlgo, pkgDate, keyId)
+// providePackageName adds the name of a legacy binary package to its provides, which rpm retrofits
+// as "Provides: name = EVR"
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/legacy.c
+func providePackageName(pkgInfo *PackageInfo, indexEntries []indexEntry) {
+	// source packages have no RPMTAG_SOURCERPM
+	source := true
+	for _, ie := range indexEntries {
+		if ie.Info.Tag == RPMTAG_SOURCERPM {
+			source = false
+			break
+		}
+	}
+	if source || pkgInfo.Name == "" {
+		return
+	}
+	for _, provide := range pkgInfo.Provides {
+		if provide == pkgInfo.Name {
+			return
 		}
 	}
+	pkgInfo.Provides = append(pkgInfo.Provides, pkgInfo.Name)
+}
 
-	return pkgInfo, nil
+// setDefaults fills the legacy single-valued fields, which rpm v6 packages may come without
+func (p *PackageInfo) setDefaults() {
+	if p.DigestAlgorithm == 0 && len(p.FileDigestAlgorithms) > 0 {
+		p.DigestAlgorithm = p.FileDigestAlgorithms[0]
+	}
+	if p.PGP == "" && len(p.OpenPGP) > 0 {
+		p.PGP = p.OpenPGP[0]
+	}
 }
 
 const (
+	sizeOfInt64  = 8
 	sizeOfInt32  = 4
 	sizeOfUInt16 = 2
 )
 
 func parseInt32Array(data []byte, arraySize int) ([]int32, error) {
 	length := arraySize / sizeOfInt32
+	if err := checkArraySize(data, length*sizeOfInt32); err != nil {
+		return nil, err
+	}
 	values := make([]int32, length)
-	reader := bytes.NewReader(data)
-	if err := binary.Read(reader, binary.BigEndian, &values); err != nil {
-		return nil, xerrors.Errorf("failed to read binary: %w", err)
+	for i := range values {
+		values[i] = int32(binary.BigEndian.Uint32(data[i*sizeOfInt32:]))
+	}
+	return values, nil
+}
+
+func parseInt64Array(data []byte, arraySize int) ([]int64, error) {
+	length := arraySize / sizeOfInt64
+	if err := checkArraySize(data, length*sizeOfInt64); err != nil {
+		return nil, err
+	}
+	values := make([]int64, length)
+	for i := range values {
+		values[i] = int64(binary.BigEndian.Uint64(data[i*sizeOfInt64:]))
 	}
 	return values, nil
 }
 
 func parseInt32(data []byte) (int, error) {
-	var value int32
-	reader := bytes.NewReader(data)
-	if err := binary.Read(reader, binary.BigEndian, &value); err != nil {
-		return 0, xerrors.Errorf("failed to read binary: %w", err)
+	if err := checkArraySize(data, sizeOfInt32); err != nil {
+		return 0, err
+	}
+	return int(int32(binary.BigEndian.Uint32(data))), nil
+}
+
+func parseInt64(data []byte) (int64, error) {
+	if err := checkArraySize(data, sizeOfInt64); err != nil {
+		return 0, err
 	}
-	return int(value), nil
+	return int64(binary.BigEndian.Uint64(data)), nil
 }
 
 func uint16Array(data []byte, arraySize int) ([]uint16, error) {
 	length := arraySize / sizeOfUInt16
+	if err := checkArraySize(data, length*sizeOfUInt16); err != nil {
+		return nil, err
+	}
 	values := make([]uint16, length)
-	reader := bytes.NewReader(data)
-	if err := binary.Read(reader, binary.BigEndian, &values); err != nil {
-		return nil, xerrors.Errorf("failed to read binary: %w", err)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(data[i*sizeOfUInt16:])
 	}
 	return values, nil
 }
 
+// checkArraySize returns the error of binary.Read for data shorter than size
+func checkArraySize(data []byte, size int) error {
+	switch {
+	case len(data) >= size:
+		return nil
+	case len(data) == 0:
+		return xerrors.Errorf("failed to read binary: %w", io.EOF)
+	default:
+		return xerrors.Errorf("failed to read binary: %w", io.ErrUnexpectedEOF)
+	}
+}
+
+// parseStringArray splits the NUL terminated strings of data.
+// The strings share a single copy of data.
 func parseStringArray(data []byte) []string {
-	return strings.Split(string(bytes.TrimRight(data, "\x00")), "\x00")
+	data = bytes.TrimRight(data, "\x00")
+	values := make([]string, 0, bytes.Count(data, []byte{0})+1)
+	str := string(data)
+	for {
+		i := strings.IndexByte(str, 0)
+		if i < 0 {
+			return append(values, str)
+		}
+		values = append(values, str[:i])
+		str = str[i+1:]
+	}
+}
+
+// stringTable interns strings repeated across files and packages, like directory, user and group names
+type stringTable map[string]string
+
+func (t stringTable) intern(b []byte) string {
+	// the conversion in the map index doesn't allocate
+	if s, ok := t[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	t[s] = s
+	return s
+}
+
+// parseStringArray is parseStringArray with interned strings
+func (t stringTable) parseStringArray(data []byte) []string {
+	data = bytes.TrimRight(data, "\x00")
+	values := make([]string, 0, bytes.Count(data, []byte{0})+1)
+	for {
+		i := bytes.IndexByte(data, 0)
+		if i < 0 {
+			return append(values, t.intern(data))
+		}
+		values = append(values, t.intern(data[:i]))
+		data = data[i+1:]
+	}
 }
 
 func (p *PackageInfo) InstalledFileNames() ([]string, error) {
+	if err := p.loadFiles(); err != nil {
+		return nil, err
+	}
 	if len(p.DirNames) == 0 || len(p.DirIndexes) == 0 || len(p.BaseNames) == 0 {
 		return nil, nil
 	}
@@ -414,9 +669,10 @@ func (p *PackageInfo) InstalledFiles() ([]FileInfo, error) {
 
 	var files []FileInfo
 	for i, fileName := range fileNames {
-		var digest, username, groupname string
+		var digest, username, groupname, imaSignature, veritySignature string
 		var mode uint16
 		var size, flags int32
+		var state FileState
 
 		if p.FileDigests != nil && len(p.FileDigests) > i {
 			digest = p.FileDigests[i]
@@ -442,6 +698,18 @@ func (p *PackageInfo) InstalledFiles() ([]FileInfo, error) {
 			flags = p.FileFlags[i]
 		}
 
+		if p.FileStates != nil && len(p.FileStates) > i {
+			state = p.FileStates[i]
+		}
+
+		if p.FileSignatures != nil && len(p.FileSignatures) > i {
+			imaSignature = p.FileSignatures[i]
+		}
+
+		if p.VeritySignatures != nil && len(p.VeritySignatures) > i {
+			veritySignature = p.VeritySignatures[i]
+		}
+
 		record := FileInfo{
 			Path:      fileName,
 			Mode:      mode,
@@ -450,6 +718,11 @@ func (p *PackageInfo) InstalledFiles() ([]FileInfo, error) {
 			Username:  username,
 			Groupname: groupname,
 			Flags:     FileFlags(flags),
+			State:     state,
+
+			IMASignature:    imaSignature,
+			VeritySignature: veritySignature,
+			Digests:         p.fileDigests(i, len(fileNames)),
 		}
 		files = append(files, record)
 	}
@@ -457,6 +730,66 @@ func (p *PackageInfo) InstalledFiles() ([]FileInfo, error) {
 	return files, nil
 }
 
+// InstFileNames returns the paths of the files installed by this package, like the instfilenames
+// extension of rpm. Files in a %_netsharedpath are included, replaced files are not.
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/tagexts.c
+func (p *PackageInfo) InstFileNames() ([]string, error) {
+	fileNames, err := p.InstalledFileNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var filePaths []string
+	for i, fileName := range fileNames {
+		if len(p.FileStates) > i {
+			// RPMFILE_IS_INSTALLED
+			if state := p.FileStates[i]; state != RPMFILE_STATE_NORMAL && state != RPMFILE_STATE_NETSHARED {
+				continue
+			}
+		}
+		filePaths = append(filePaths, fileName)
+	}
+	return filePaths, nil
+}
+
+// PresentFiles returns the installed files which were actually written to disk. Files excluded
+// with --excludedocs, in a %_netsharedpath or skipped by multilib color resolution are omitted.
+func (p *PackageInfo) PresentFiles() ([]FileInfo, error) {
+	files, err := p.InstalledFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var present []FileInfo
+	for _, file := range files {
+		if file.State.Present() {
+			present = append(present, file)
+		}
+	}
+	return present, nil
+}
+
+// fileDigests returns the digests of the i-th file for every algorithm of RPMTAG_FILEDIGESTALGOS.
+// RPMTAG_FILEDIGESTS holds the digests of the first algorithm, RPMTAG_FILEALTDIGESTS those of the
+// remaining algorithms, one full set of file digests per algorithm.
+func (p *PackageInfo) fileDigests(i, numFiles int) map[DigestAlgorithm]string {
+	if len(p.FileDigestAlgorithms) < 2 {
+		return nil
+	}
+
+	digests := make(map[DigestAlgorithm]string, len(p.FileDigestAlgorithms))
+	if len(p.FileDigests) > i && p.FileDigests[i] != "" {
+		digests[p.FileDigestAlgorithms[0]] = p.FileDigests[i]
+	}
+	for j, algo := range p.FileDigestAlgorithms[1:] {
+		idx := j*numFiles + i
+		if len(p.FileAltDigests) > idx && p.FileAltDigests[idx] != "" {
+			digests[algo] = p.FileAltDigests[idx]
+		}
+	}
+	return digests
+}
+
 func (p *PackageInfo) EpochNum() int {
 	if p.Epoch == nil {
 		return 0
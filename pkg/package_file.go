@@ -0,0 +1,255 @@
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmlead.c
+	leadSize = 96
+
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/header_internal.h
+	headerMagicSize = 8
+
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmlead.c
+	RPMSIGTYPE_HEADERSIG = 5
+)
+
+var (
+	leadMagic   = []byte{0xed, 0xab, 0xee, 0xdb}
+	headerMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+)
+
+// rpmSigTag_e
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.17.0-release/lib/rpmtag.h
+const (
+	RPMSIGTAG_DSA             = 267  /* x */
+	RPMSIGTAG_RSA             = 268  /* x */
+	RPMSIGTAG_SHA1            = 269  /* s */
+	RPMSIGTAG_LONGSIZE        = 270  /* l */
+	RPMSIGTAG_LONGARCHIVESIZE = 271  /* l */
+	RPMSIGTAG_SHA256          = 273  /* s */
+	RPMSIGTAG_OPENPGP         = 278  /* s[] */
+	RPMSIGTAG_SIZE            = 1000 /* i */
+	RPMSIGTAG_PGP             = 1002 /* x */
+	RPMSIGTAG_MD5             = 1004 /* x */
+	RPMSIGTAG_GPG             = 1005 /* x */
+	RPMSIGTAG_PAYLOADSIZE     = 1007 /* i */
+)
+
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmlead.c
+type rpmLead struct {
+	Magic         [4]byte
+	Major         uint8
+	Minor         uint8
+	Type          int16
+	ArchNum       int16
+	Name          [66]byte
+	OSNum         int16
+	SignatureType int16
+	Reserved      [16]byte
+}
+
+// SignatureInfo holds the signature header of a package file
+type SignatureInfo struct {
+	// Size is the size of the header and the compressed payload
+	Size int64
+	// PayloadSize is the size of the uncompressed payload
+	PayloadSize int64
+	MD5         string
+	SHA1        string
+	SHA256      string
+	// PGP and GPG are signatures of the header and payload, RSA and DSA of the header only
+	PGP     string
+	GPG     string
+	RSA     string
+	DSA     string
+	OpenPGP []string
+}
+
+// ReadPackageFile parses the lead, signature header and main header of an .rpm package file.
+// The reader is left positioned at the start of the payload.
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/package.c
+func ReadPackageFile(r io.Reader) (*PackageInfo, error) {
+	var lead rpmLead
+	if err := binary.Read(r, binary.BigEndian, &lead); err != nil {
+		return nil, xerrors.Errorf("failed to read lead: %w", err)
+	}
+	if !bytes.Equal(lead.Magic[:], leadMagic) {
+		return nil, xerrors.New("invalid lead magic")
+	}
+	if lead.SignatureType != RPMSIGTYPE_HEADERSIG {
+		return nil, xerrors.Errorf("unsupported signature type: %d", lead.SignatureType)
+	}
+
+	sigData, err := readHeader(r)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read signature header: %w", err)
+	}
+	offset := int64(leadSize + headerMagicSize + len(sigData))
+
+	// the signature header is padded to an 8 byte boundary
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/signature.c
+	if pad := (8 - offset%8) % 8; pad > 0 {
+		if _, err := io.CopyN(io.Discard, r, pad); err != nil {
+			return nil, xerrors.Errorf("failed to read signature header padding: %w", err)
+		}
+		offset += pad
+	}
+
+	sigEntries, err := headerImport(sigData)
+	if err != nil {
+		return nil, xerrors.Errorf("error during importing signature header: %w", err)
+	}
+	sigInfo, err := getSignatureInfo(sigEntries)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid signature info: %w", err)
+	}
+
+	hdrData, err := readHeader(r)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read header: %w", err)
+	}
+	offset += int64(headerMagicSize + len(hdrData))
+
+	indexEntries, err := headerImport(hdrData)
+	if err != nil {
+		return nil, xerrors.Errorf("error during importing header: %w", err)
+	}
+	pkg, err := getNEVRA(indexEntries)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid package info: %w", err)
+	}
+
+	// rpm merges these signature tags into the header on install
+	if pkg.SigMD5 == "" {
+		pkg.SigMD5 = sigInfo.MD5
+	}
+	if pkg.PGP == "" {
+		pkg.PGP = sigInfo.PGP
+	}
+
+	pkg.Signature = sigInfo
+	pkg.PayloadOffset = offset
+
+	return pkg, nil
+}
+
+// readHeader reads a header with its magic and returns the header blob without the magic, as stored in the rpmdb
+func readHeader(r io.Reader) ([]byte, error) {
+	magic := make([]byte, headerMagicSize)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, xerrors.Errorf("failed to read header magic: %w", err)
+	}
+	if !bytes.Equal(magic[:len(headerMagic)], headerMagic) {
+		return nil, xerrors.New("invalid header magic")
+	}
+
+	intro := make([]byte, 8)
+	if _, err := io.ReadFull(r, intro); err != nil {
+		return nil, xerrors.Errorf("failed to read header intro: %w", err)
+	}
+	il := int32(binary.BigEndian.Uint32(intro[0:4]))
+	dl := int32(binary.BigEndian.Uint32(intro[4:8]))
+	if il < 1 || dl < 0 || int64(il)*int64(REGION_TAG_COUNT)+int64(dl) >= headerMaxbytes {
+		return nil, xerrors.Errorf("invalid header size: il %d, dl %d", il, dl)
+	}
+
+	data := make([]byte, 8+int(il)*int(REGION_TAG_COUNT)+int(dl))
+	copy(data, intro)
+	if _, err := io.ReadFull(r, data[8:]); err != nil {
+		return nil, xerrors.Errorf("failed to read header data: %w", err)
+	}
+	return data, nil
+}
+
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/signature.c
+func getSignatureInfo(indexEntries []indexEntry) (*SignatureInfo, error) {
+	sigInfo := &SignatureInfo{}
+	for _, ie := range indexEntries {
+		var err error
+		switch ie.Info.Tag {
+		case RPMSIGTAG_SIZE:
+			if ie.Info.Type != RPM_INT32_TYPE {
+				return nil, xerrors.New("invalid tag size")
+			}
+			var size int
+			size, err = parseInt32(ie.Data)
+			// RPMSIGTAG_LONGSIZE takes precedence when present
+			if sigInfo.Size == 0 {
+				sigInfo.Size = int64(uint32(size))
+			}
+		case RPMSIGTAG_LONGSIZE:
+			if ie.Info.Type != RPM_INT64_TYPE {
+				return nil, xerrors.New("invalid tag longsize")
+			}
+			sigInfo.Size, err = parseInt64(ie.Data)
+		case RPMSIGTAG_PAYLOADSIZE:
+			if ie.Info.Type != RPM_INT32_TYPE {
+				return nil, xerrors.New("invalid tag payloadsize")
+			}
+			var size int
+			size, err = parseInt32(ie.Data)
+			// RPMSIGTAG_LONGARCHIVESIZE takes precedence when present
+			if sigInfo.PayloadSize == 0 {
+				sigInfo.PayloadSize = int64(uint32(size))
+			}
+		case RPMSIGTAG_LONGARCHIVESIZE:
+			if ie.Info.Type != RPM_INT64_TYPE {
+				return nil, xerrors.New("invalid tag longarchivesize")
+			}
+			sigInfo.PayloadSize, err = parseInt64(ie.Data)
+		case RPMSIGTAG_MD5:
+			if ie.Info.Type != RPM_BIN_TYPE {
+				return nil, xerrors.New("invalid tag md5")
+			}
+			sigInfo.MD5 = hex.EncodeToString(ie.Data)
+		case RPMSIGTAG_SHA1:
+			if ie.Info.Type != RPM_STRING_TYPE {
+				return nil, xerrors.New("invalid tag sha1")
+			}
+			sigInfo.SHA1 = string(bytes.TrimRight(ie.Data, "\x00"))
+		case RPMSIGTAG_SHA256:
+			if ie.Info.Type != RPM_STRING_TYPE {
+				return nil, xerrors.New("invalid tag sha256")
+			}
+			sigInfo.SHA256 = string(bytes.TrimRight(ie.Data, "\x00"))
+		case RPMSIGTAG_PGP, RPMSIGTAG_GPG, RPMSIGTAG_RSA, RPMSIGTAG_DSA:
+			if ie.Info.Type != RPM_BIN_TYPE {
+				return nil, xerrors.Errorf("invalid signature tag %d", ie.Info.Tag)
+			}
+			var sig string
+			sig, err = parsePGPSignature(ie.Data)
+			switch ie.Info.Tag {
+			case RPMSIGTAG_PGP:
+				sigInfo.PGP = sig
+			case RPMSIGTAG_GPG:
+				sigInfo.GPG = sig
+			case RPMSIGTAG_RSA:
+				sigInfo.RSA = sig
+			case RPMSIGTAG_DSA:
+				sigInfo.DSA = sig
+			}
+		case RPMSIGTAG_OPENPGP:
+			if ie.Info.Type != RPM_STRING_ARRAY_TYPE {
+				return nil, xerrors.New("invalid tag openpgp")
+			}
+			for _, signature := range parseStringArray(ie.Data) {
+				var sig string
+				if sig, err = parseOpenPGPSignature(signature); err != nil {
+					break
+				}
+				sigInfo.OpenPGP = append(sigInfo.OpenPGP, sig)
+			}
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("failed to parse signature tag %d: %w", ie.Info.Tag, err)
+		}
+	}
+	return sigInfo, nil
+}
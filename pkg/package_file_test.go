@@ -0,0 +1,109 @@
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildPackageFile assembles an .rpm file from a signature header, a main header and the payload
+func buildPackageFile(sigHeader, header, payload []byte) []byte {
+	lead := make([]byte, leadSize)
+	copy(lead, leadMagic)
+	lead[4] = 3
+	copy(lead[10:], "hello-2.12.1-7.fc43")
+	binary.BigEndian.PutUint16(lead[78:], RPMSIGTYPE_HEADERSIG)
+
+	buf := append(lead, headerMagic...)
+	buf = append(buf, 0, 0, 0, 0)
+	buf = append(buf, sigHeader...)
+	for len(buf)%8 != 0 {
+		buf = append(buf, 0)
+	}
+	buf = append(buf, headerMagic...)
+	buf = append(buf, 0, 0, 0, 0)
+	buf = append(buf, header...)
+	return append(buf, payload...)
+}
+
+func testSignatureHeader() []byte {
+	return (&headerBuilder{regionTag: RPMTAG_HEADERSIGNATURES}).
+		addString(RPMSIGTAG_SHA256, "0ea3e5b2b3b4d8c1e0fa4a6e1b6fb1b4f5d8b4a8a1d3c0e0f8b1e2d3c4b5a6f7").
+		addInt64(RPMSIGTAG_LONGSIZE, 1<<33).
+		addInt32(RPMSIGTAG_SIZE, 4711).
+		addBin(RPMSIGTAG_MD5, []byte{0xe1, 0x2d, 0x0c, 0x1f, 0xa3, 0x6b, 0x1d, 0x3b, 0x8c, 0x9b, 0x1f, 0x51, 0x25, 0xc2, 0x1e, 0x8e}).
+		addInt32(RPMSIGTAG_PAYLOADSIZE, 1234).
+		bytes()
+}
+
+func TestReadPackageFile(t *testing.T) {
+	header := (&headerBuilder{}).
+		addString(RPMTAG_NAME, "hello").
+		addString(RPMTAG_VERSION, "2.12.1").
+		addString(RPMTAG_RELEASE, "7.fc43").
+		addString(RPMTAG_ARCH, "x86_64").
+		bytes()
+	payload := []byte("payload")
+	file := buildPackageFile(testSignatureHeader(), header, payload)
+
+	r := bytes.NewReader(file)
+	pkg, err := ReadPackageFile(r)
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello", pkg.Name)
+	assert.Equal(t, "2.12.1", pkg.Version)
+	assert.Equal(t, "e12d0c1fa36b1d3b8c9b1f5125c21e8e", pkg.SigMD5)
+	assert.Equal(t, &SignatureInfo{
+		Size:        1 << 33,
+		PayloadSize: 1234,
+		MD5:         "e12d0c1fa36b1d3b8c9b1f5125c21e8e",
+		SHA256:      "0ea3e5b2b3b4d8c1e0fa4a6e1b6fb1b4f5d8b4a8a1d3c0e0f8b1e2d3c4b5a6f7",
+	}, pkg.Signature)
+	assert.Equal(t, int64(len(file)-len(payload)), pkg.PayloadOffset)
+
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, payload, rest)
+}
+
+func TestReadPackageFileErrors(t *testing.T) {
+	valid := buildPackageFile(testSignatureHeader(), (&headerBuilder{}).addString(RPMTAG_NAME, "hello").bytes(), nil)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{
+			name:    "empty",
+			data:    nil,
+			wantErr: "failed to read lead",
+		},
+		{
+			name:    "invalid lead magic",
+			data:    append([]byte{0xde, 0xad}, valid[2:]...),
+			wantErr: "invalid lead magic",
+		},
+		{
+			name:    "invalid header magic",
+			data:    append(append([]byte{}, valid[:leadSize]...), make([]byte, 16)...),
+			wantErr: "invalid header magic",
+		},
+		{
+			name:    "truncated header",
+			data:    valid[:len(valid)-4],
+			wantErr: "failed to read header data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadPackageFile(bytes.NewReader(tt.data))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
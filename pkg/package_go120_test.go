@@ -0,0 +1,30 @@
+//go:build go1.20
+
+package rpmdb
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stringData returns the address of the bytes of s, unsafe.StringData needs go1.20
+func stringData(s string) uintptr {
+	return uintptr(unsafe.Pointer(unsafe.StringData(s)))
+}
+
+func Test_stringTable(t *testing.T) {
+	strs := stringTable{}
+	users := strs.parseStringArray([]byte("root\x00root\x00bin\x00"))
+	groups := strs.parseStringArray([]byte("bin\x00root\x00"))
+	require.Equal(t, []string{"root", "root", "bin"}, users)
+	require.Equal(t, []string{"bin", "root"}, groups)
+
+	// equal strings share their memory
+	assert.Equal(t, stringData(users[0]), stringData(users[1]))
+	assert.Equal(t, stringData(users[0]), stringData(groups[1]))
+	assert.Equal(t, stringData(users[2]), stringData(groups[0]))
+	assert.Len(t, strs, 2)
+}
@@ -0,0 +1,57 @@
+package rpmdb
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func Test_parseStringArray(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{
+			name: "strings",
+			data: []byte("root\x00bin\x00\x00daemon\x00"),
+			want: []string{"root", "bin", "", "daemon"},
+		},
+		{
+			name: "trailing NULs",
+			data: []byte("root\x00\x00\x00"),
+			want: []string{"root"},
+		},
+		{
+			name: "no terminator",
+			data: []byte("root"),
+			want: []string{"root"},
+		},
+		{
+			name: "empty",
+			data: nil,
+			want: []string{""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseStringArray(tt.data))
+			assert.Equal(t, tt.want, stringTable{}.parseStringArray(tt.data))
+		})
+	}
+}
+
+func Test_parseInt32Array(t *testing.T) {
+	values, err := parseInt32Array([]byte{0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xfe}, 8)
+	require.NoError(t, err)
+	assert.Equal(t, []int32{1, -2}, values)
+
+	_, err = parseInt32Array([]byte{0x00, 0x00, 0x00, 0x01, 0xff}, 8)
+	assert.True(t, xerrors.Is(err, io.ErrUnexpectedEOF))
+
+	_, err = parseInt32(nil)
+	assert.True(t, xerrors.Is(err, io.EOF))
+}
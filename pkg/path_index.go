@@ -0,0 +1,123 @@
+package rpmdb
+
+import (
+	"path"
+	"strings"
+)
+
+// maxSymlinks limits the number of symbolic links followed while resolving a path, as with MAXSYMLINKS
+const maxSymlinks = 40
+
+// PathIndex maps installed file paths to the packages owning them.
+// Directories which are symbolic links, such as /lib -> usr/lib on merged /usr systems, are resolved
+// through the symbolic links owned by the indexed packages, similar to rpm's fingerprint cache.
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/fprint.c
+type PathIndex struct {
+	owners   map[string][]*PackageInfo
+	symlinks map[string]string
+}
+
+// NewPathIndex builds a PathIndex of the installed files of the given packages
+func NewPathIndex(pkgs []*PackageInfo) (*PathIndex, error) {
+	idx := &PathIndex{
+		owners:   make(map[string][]*PackageInfo),
+		symlinks: make(map[string]string),
+	}
+
+	type ownedFile struct {
+		pkg  *PackageInfo
+		name string
+	}
+	var files []ownedFile
+	for _, pkg := range pkgs {
+		fileNames, err := pkg.InstalledFileNames()
+		if err != nil {
+			return nil, err
+		}
+		for i, fileName := range fileNames {
+			files = append(files, ownedFile{pkg: pkg, name: fileName})
+
+			if len(pkg.FileModes) > i && uint32(pkg.FileModes[i])&s_IFMT == s_IFLNK &&
+				len(pkg.FileLinkTos) > i && pkg.FileLinkTos[i] != "" {
+				target := pkg.FileLinkTos[i]
+				if !path.IsAbs(target) {
+					target = path.Join(path.Dir(fileName), target)
+				}
+				idx.symlinks[path.Clean(fileName)] = path.Clean(target)
+			}
+		}
+	}
+
+	// symbolic links may be listed below other symbolic links
+	symlinks := make(map[string]string, len(idx.symlinks))
+	for link, target := range idx.symlinks {
+		symlinks[idx.resolve(link)] = target
+	}
+	for link, target := range symlinks {
+		idx.symlinks[link] = target
+	}
+
+	for _, f := range files {
+		key := idx.resolve(f.name)
+		owners := idx.owners[key]
+		// a package may list the same path through different symbolic links
+		if len(owners) > 0 && owners[len(owners)-1] == f.pkg {
+			continue
+		}
+		idx.owners[key] = append(owners, f.pkg)
+	}
+
+	return idx, nil
+}
+
+// WhoOwns returns the packages owning the path, or nil if it's not owned by any package
+func (idx *PathIndex) WhoOwns(name string) []*PackageInfo {
+	return idx.owners[idx.resolve(name)]
+}
+
+// Paths returns the resolved paths of all owned files in no particular order
+func (idx *PathIndex) Paths() []string {
+	paths := make([]string, 0, len(idx.owners))
+	for p := range idx.owners {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// resolve resolves symbolic links in the directory part of an absolute path.
+// The last component is not resolved, so a symbolic link itself stays addressable.
+func (idx *PathIndex) resolve(name string) string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return name
+	}
+	dir, base := path.Split(name)
+	return path.Join(idx.resolveDir(dir), base)
+}
+
+func (idx *PathIndex) resolveDir(dir string) string {
+	dir = path.Clean("/" + dir)
+	for n := 0; n < maxSymlinks; n++ {
+		parts := strings.Split(strings.TrimPrefix(dir, "/"), "/")
+
+		resolved, changed := "/", false
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			next := path.Join(resolved, part)
+			target, ok := idx.symlinks[next]
+			if !ok {
+				resolved = next
+				continue
+			}
+			dir = path.Join(append([]string{target}, parts[i+1:]...)...)
+			changed = true
+			break
+		}
+		if !changed {
+			return dir
+		}
+	}
+	return dir
+}
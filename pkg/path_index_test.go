@@ -0,0 +1,121 @@
+package rpmdb
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+)
+
+func testPackageFiles(name string, files map[string]string) *PackageInfo {
+	pkg := &PackageInfo{Name: name}
+	dirs := map[string]int32{}
+	for file, linkTo := range files {
+		dir, base := path.Split(file)
+		if _, ok := dirs[dir]; !ok {
+			dirs[dir] = int32(len(pkg.DirNames))
+			pkg.DirNames = append(pkg.DirNames, dir)
+		}
+		pkg.BaseNames = append(pkg.BaseNames, base)
+		pkg.DirIndexes = append(pkg.DirIndexes, dirs[dir])
+		pkg.FileLinkTos = append(pkg.FileLinkTos, linkTo)
+		mode := uint16(s_IFREG | 0o644)
+		if linkTo != "" {
+			mode = s_IFLNK | 0o777
+		}
+		pkg.FileModes = append(pkg.FileModes, mode)
+	}
+	return pkg
+}
+
+func TestPathIndex(t *testing.T) {
+	filesystem := testPackageFiles("filesystem", map[string]string{
+		"/lib":           "usr/lib",
+		"/lib64":         "usr/lib64",
+		"/usr/lib":       "",
+		"/usr/lib64":     "",
+		"/usr/share/doc": "",
+	})
+	openssl := testPackageFiles("openssl-libs", map[string]string{
+		"/usr/lib64/libssl.so.3":      "",
+		"/usr/lib64/libssl.so":        "libssl.so.3",
+		"/usr/share/doc/openssl/NEWS": "",
+	})
+	compat := testPackageFiles("compat", map[string]string{
+		// listed through the /lib64 symbolic link
+		"/lib64/libcompat.so.1": "",
+		"/usr/share/doc":        "",
+		"/opt/compat/current":   "/opt/compat/1.0",
+		"/opt/compat/1.0/bin/x": "",
+	})
+
+	idx, err := NewPathIndex([]*PackageInfo{filesystem, openssl, compat})
+	require.NoError(t, err)
+
+	names := func(pkgs []*PackageInfo) []string {
+		var n []string
+		for _, pkg := range pkgs {
+			n = append(n, pkg.Name)
+		}
+		return n
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "/usr/lib64/libssl.so.3", want: []string{"openssl-libs"}},
+		{path: "/lib64/libssl.so.3", want: []string{"openssl-libs"}},
+		{path: "/lib64/../lib64/libssl.so", want: []string{"openssl-libs"}},
+		{path: "/usr/lib64/libcompat.so.1", want: []string{"compat"}},
+		{path: "/usr/share/doc", want: []string{"filesystem", "compat"}},
+		{path: "/lib", want: []string{"filesystem"}},
+		{path: "/opt/compat/current/bin/x", want: []string{"compat"}},
+		{path: "/usr/lib64/libmissing.so", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, names(idx.WhoOwns(tt.path)))
+		})
+	}
+}
+
+func TestRpmDB_WhoOwns(t *testing.T) {
+	filesystem := (&headerBuilder{}).
+		addString(RPMTAG_NAME, "filesystem").
+		addStringArray(RPMTAG_BASENAMES, "lib", "lib", "bin").
+		addStringArray(RPMTAG_DIRNAMES, "/", "/usr/").
+		addInt32(RPMTAG_DIRINDEXES, 0, 1, 1).
+		addInt16(RPMTAG_FILEMODES, s_IFLNK|0o777, s_IFDIR|0o555, s_IFDIR|0o555).
+		addStringArray(RPMTAG_FILELINKTOS, "usr/lib", "", "").
+		bytes()
+	glibc := (&headerBuilder{}).
+		addString(RPMTAG_NAME, "glibc").
+		addStringArray(RPMTAG_BASENAMES, "libc.so.6", "bin").
+		addStringArray(RPMTAG_DIRNAMES, "/usr/lib/", "/usr/").
+		addInt32(RPMTAG_DIRINDEXES, 0, 1).
+		addInt16(RPMTAG_FILEMODES, s_IFREG|0o755, s_IFDIR|0o555).
+		bytes()
+
+	db, err := Open(createSQLite3DB(t, filesystem, glibc))
+	require.NoError(t, err)
+
+	pkgs, err := db.WhoOwns(context.Background(), "/lib/libc.so.6")
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	assert.Equal(t, "glibc", pkgs[0].Name)
+
+	pkgs, err = db.WhoOwns(context.Background(), "/usr/bin")
+	require.NoError(t, err)
+	require.Len(t, pkgs, 2)
+
+	_, err = db.WhoOwns(context.Background(), "/usr/bin/curl")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not owned by any package")
+	assert.True(t, xerrors.Is(err, dbi.ErrPackageNotFound))
+}
@@ -0,0 +1,416 @@
+package rpmdb
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Decompressor returns a reader of the decompressed payload stream
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	decompressorsMu sync.RWMutex
+
+	// keyed on RPMTAG_PAYLOADCOMPRESSOR, rpm assumes gzip when the tag is missing
+	decompressors = map[string]Decompressor{
+		"gzip": func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+		"bzip2": func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(bzip2.NewReader(r)), nil
+		},
+		"identity": func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(r), nil
+		},
+	}
+)
+
+// RegisterDecompressor registers a Decompressor for a RPMTAG_PAYLOADCOMPRESSOR value, e.g. "xz", "lzma" or "zstd".
+// Only gzip and bzip2 are supported out of the box.
+func RegisterDecompressor(name string, d Decompressor) {
+	decompressorsMu.Lock()
+	defer decompressorsMu.Unlock()
+	decompressors[name] = d
+}
+
+func lookupDecompressor(name string) (Decompressor, bool) {
+	decompressorsMu.RLock()
+	defer decompressorsMu.RUnlock()
+	d, ok := decompressors[name]
+	return d, ok
+}
+
+// cpio "new ascii" format as written by rpm
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/cpio.c
+const (
+	cpioNewcMagic     = "070701"
+	cpioCrcMagic      = "070702"
+	cpioStrippedMagic = "07070X"
+	cpioTrailer       = "TRAILER!!!"
+
+	cpioMagicSize          = 6
+	cpioHeaderSize         = 110
+	cpioStrippedHeaderSize = 14
+
+	// maxLinkTargetSize is PATH_MAX, link targets are read into memory
+	maxLinkTargetSize = 4096
+)
+
+// PayloadEntry describes a single file of a package payload
+type PayloadEntry struct {
+	// Path is the absolute path the file is installed to
+	Path string
+	// Mode holds the file type and permission bits as in st_mode
+	Mode      uint32
+	Size      int64
+	UID       uint32
+	GID       uint32
+	Username  string
+	Groupname string
+	MTime     time.Time
+	Nlink     uint32
+	Inode     uint32
+	// Linkname is the target of a symbolic link
+	Linkname string
+	// FileIndex is the index of the file in the header file list, or -1 if unknown
+	FileIndex int
+}
+
+// FileMode converts Mode to an fs.FileMode
+func (e *PayloadEntry) FileMode() fs.FileMode {
+	return unixFileMode(e.Mode)
+}
+
+// PayloadReader iterates over the files of a package payload, similar to tar.Reader.
+// Next advances to the next file, after which Read returns its content.
+type PayloadReader struct {
+	pkg         *PackageInfo
+	fileNames   []string
+	fileIndexes map[string]int
+	lastLinks   map[int32]int
+
+	decompressed io.ReadCloser
+	r            *countingReader
+	remaining    int64
+	done         bool
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewPayloadReader parses the headers of an .rpm package file and returns a reader over its payload
+func NewPayloadReader(r io.Reader) (*PayloadReader, error) {
+	pkg, err := ReadPackageFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if pkg.PayloadFormat != "" && pkg.PayloadFormat != "cpio" {
+		return nil, xerrors.Errorf("unsupported payload format: %s", pkg.PayloadFormat)
+	}
+
+	compressor := pkg.PayloadCompressor
+	if compressor == "" {
+		compressor = "gzip"
+	}
+	decompressor, ok := lookupDecompressor(compressor)
+	if !ok {
+		return nil, xerrors.Errorf("unsupported payload compressor %q, see RegisterDecompressor", compressor)
+	}
+	decompressed, err := decompressor(r)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decompress payload: %w", err)
+	}
+
+	fileNames, err := pkg.InstalledFileNames()
+	if err != nil {
+		return nil, err
+	}
+
+	fileIndexes := make(map[string]int, len(fileNames))
+	for i := len(fileNames) - 1; i >= 0; i-- {
+		fileIndexes[fileNames[i]] = i
+	}
+
+	// only the last file of a hardlink set carries the content
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmfi.c
+	lastLinks := make(map[int32]int)
+	for i, inode := range pkg.FileInodes {
+		lastLinks[inode] = i
+	}
+
+	return &PayloadReader{
+		pkg:          pkg,
+		fileNames:    fileNames,
+		fileIndexes:  fileIndexes,
+		lastLinks:    lastLinks,
+		decompressed: decompressed,
+		r:            &countingReader{r: decompressed},
+	}, nil
+}
+
+// Package returns the header information of the package
+func (p *PayloadReader) Package() *PackageInfo {
+	return p.pkg
+}
+
+// Next advances to the next entry of the payload. io.EOF is returned at the end of the payload.
+func (p *PayloadReader) Next() (*PayloadEntry, error) {
+	if p.done {
+		return nil, io.EOF
+	}
+
+	if p.remaining > 0 {
+		if _, err := io.CopyN(io.Discard, p.r, p.remaining); err != nil {
+			return nil, xerrors.Errorf("failed to skip file content: %w", err)
+		}
+		p.remaining = 0
+	}
+	if err := p.align(); err != nil {
+		return nil, err
+	}
+
+	magic := make([]byte, cpioMagicSize)
+	if _, err := io.ReadFull(p.r, magic); err != nil {
+		return nil, xerrors.Errorf("failed to read cpio magic: %w", err)
+	}
+
+	var entry *PayloadEntry
+	var err error
+	switch string(magic) {
+	case cpioNewcMagic, cpioCrcMagic:
+		entry, err = p.readNewcHeader()
+	case cpioStrippedMagic:
+		entry, err = p.readStrippedHeader()
+	default:
+		return nil, xerrors.Errorf("invalid cpio magic: %q", magic)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		p.done = true
+		return nil, io.EOF
+	}
+
+	if entry.Mode&s_IFMT == s_IFLNK && p.remaining > 0 {
+		if p.remaining > maxLinkTargetSize {
+			return nil, xerrors.Errorf("link target of %s exceeds %d bytes: %d", entry.Path, maxLinkTargetSize, p.remaining)
+		}
+		target := make([]byte, p.remaining)
+		if _, err := io.ReadFull(p.r, target); err != nil {
+			return nil, xerrors.Errorf("failed to read link target of %s: %w", entry.Path, err)
+		}
+		p.remaining = 0
+		entry.Linkname = string(target)
+	}
+
+	return entry, nil
+}
+
+// Read reads the content of the current entry
+func (p *PayloadReader) Read(b []byte) (int, error) {
+	if p.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > p.remaining {
+		b = b[:p.remaining]
+	}
+	n, err := p.r.Read(b)
+	p.remaining -= int64(n)
+	if err == io.EOF && p.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Close releases the decompressor. It does not close the underlying package reader.
+func (p *PayloadReader) Close() error {
+	return p.decompressed.Close()
+}
+
+func (p *PayloadReader) align() error {
+	if pad := (4 - p.r.n%4) % 4; pad > 0 {
+		if _, err := io.CopyN(io.Discard, p.r, pad); err != nil {
+			return xerrors.Errorf("failed to read cpio padding: %w", err)
+		}
+	}
+	return nil
+}
+
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/cpio.c
+func (p *PayloadReader) readNewcHeader() (*PayloadEntry, error) {
+	hdr := make([]byte, cpioHeaderSize-cpioMagicSize)
+	if _, err := io.ReadFull(p.r, hdr); err != nil {
+		return nil, xerrors.Errorf("failed to read cpio header: %w", err)
+	}
+
+	var fields [13]uint32
+	for i := range fields {
+		v, err := strconv.ParseUint(string(hdr[i*8:i*8+8]), 16, 32)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid cpio header field: %w", err)
+		}
+		fields[i] = uint32(v)
+	}
+	ino, mode, uid, gid, nlink, mtime, size, nameSize := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6], fields[11]
+
+	if nameSize == 0 || nameSize > 4096 {
+		return nil, xerrors.Errorf("invalid cpio name size: %d", nameSize)
+	}
+	name := make([]byte, nameSize)
+	if _, err := io.ReadFull(p.r, name); err != nil {
+		return nil, xerrors.Errorf("failed to read cpio file name: %w", err)
+	}
+	if err := p.align(); err != nil {
+		return nil, err
+	}
+
+	fileName := string(name[:nameSize-1])
+	if fileName == cpioTrailer {
+		return nil, nil
+	}
+
+	p.remaining = int64(size)
+	return &PayloadEntry{
+		Path:      path.Join("/", fileName),
+		Mode:      mode,
+		Size:      int64(size),
+		UID:       uid,
+		GID:       gid,
+		MTime:     time.Unix(int64(mtime), 0).UTC(),
+		Nlink:     nlink,
+		Inode:     ino,
+		FileIndex: p.fileIndex(path.Join("/", fileName)),
+	}, nil
+}
+
+// Stripped archives as written for packages with files >4GB only carry the file index,
+// the metadata comes from the header.
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/cpio.c
+func (p *PayloadReader) readStrippedHeader() (*PayloadEntry, error) {
+	hdr := make([]byte, cpioStrippedHeaderSize-cpioMagicSize)
+	if _, err := io.ReadFull(p.r, hdr); err != nil {
+		return nil, xerrors.Errorf("failed to read stripped cpio header: %w", err)
+	}
+	fx, err := strconv.ParseUint(string(hdr), 16, 32)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid stripped cpio file index: %w", err)
+	}
+	if err := p.align(); err != nil {
+		return nil, err
+	}
+
+	i := int(fx)
+	if i >= len(p.fileNames) {
+		return nil, xerrors.Errorf("stripped cpio file index out of range: %d", i)
+	}
+
+	entry := &PayloadEntry{
+		Path:      p.fileNames[i],
+		Nlink:     1,
+		FileIndex: i,
+	}
+	if len(p.pkg.FileModes) > i {
+		entry.Mode = uint32(p.pkg.FileModes[i])
+	}
+	if len(p.pkg.LongFileSizes) > i {
+		entry.Size = p.pkg.LongFileSizes[i]
+	} else if len(p.pkg.FileSizes) > i {
+		entry.Size = int64(uint32(p.pkg.FileSizes[i]))
+	}
+	if len(p.pkg.FileMTimes) > i {
+		entry.MTime = time.Unix(int64(uint32(p.pkg.FileMTimes[i])), 0).UTC()
+	}
+	if len(p.pkg.UserNames) > i {
+		entry.Username = p.pkg.UserNames[i]
+	}
+	if len(p.pkg.GroupNames) > i {
+		entry.Groupname = p.pkg.GroupNames[i]
+	}
+	if len(p.pkg.FileInodes) > i {
+		entry.Inode = uint32(p.pkg.FileInodes[i])
+	}
+
+	switch entry.Mode & s_IFMT {
+	case s_IFREG:
+		if last, ok := p.lastLinks[int32(entry.Inode)]; ok && len(p.pkg.FileInodes) > i && last != i {
+			p.remaining = 0
+		} else {
+			p.remaining = entry.Size
+		}
+	case s_IFLNK:
+		p.remaining = entry.Size
+	default:
+		p.remaining = 0
+	}
+
+	return entry, nil
+}
+
+func (p *PayloadReader) fileIndex(name string) int {
+	if i, ok := p.fileIndexes[name]; ok {
+		return i
+	}
+	return -1
+}
+
+// file type bits of st_mode
+// ref. https://man7.org/linux/man-pages/man7/inode.7.html
+const (
+	s_IFMT   = 0o170000
+	s_IFSOCK = 0o140000
+	s_IFLNK  = 0o120000
+	s_IFREG  = 0o100000
+	s_IFBLK  = 0o060000
+	s_IFDIR  = 0o040000
+	s_IFCHR  = 0o020000
+	s_IFIFO  = 0o010000
+	s_ISUID  = 0o4000
+	s_ISGID  = 0o2000
+	s_ISVTX  = 0o1000
+)
+
+func unixFileMode(mode uint32) fs.FileMode {
+	m := fs.FileMode(mode & 0o777)
+	switch mode & s_IFMT {
+	case s_IFDIR:
+		m |= fs.ModeDir
+	case s_IFLNK:
+		m |= fs.ModeSymlink
+	case s_IFCHR:
+		m |= fs.ModeDevice | fs.ModeCharDevice
+	case s_IFBLK:
+		m |= fs.ModeDevice
+	case s_IFIFO:
+		m |= fs.ModeNamedPipe
+	case s_IFSOCK:
+		m |= fs.ModeSocket
+	}
+	if mode&s_ISUID != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&s_ISGID != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&s_ISVTX != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
@@ -0,0 +1,255 @@
+package rpmdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCpioFile struct {
+	name    string
+	mode    uint32
+	ino     uint32
+	nlink   uint32
+	mtime   uint32
+	content string
+}
+
+func cpioPad(buf *bytes.Buffer) {
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+func buildNewcArchive(files ...testCpioFile) []byte {
+	var buf bytes.Buffer
+	write := func(f testCpioFile) {
+		fmt.Fprintf(&buf, "%s%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			cpioNewcMagic, f.ino, f.mode, 0, 0, f.nlink, f.mtime, len(f.content), 0, 0, 0, 0, len(f.name)+1, 0)
+		buf.WriteString(f.name)
+		buf.WriteByte(0)
+		cpioPad(&buf)
+		buf.WriteString(f.content)
+		cpioPad(&buf)
+	}
+	for _, f := range files {
+		write(f)
+	}
+	write(testCpioFile{name: cpioTrailer})
+	return buf.Bytes()
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func readPayload(t *testing.T, pr *PayloadReader) ([]*PayloadEntry, []string) {
+	t.Helper()
+	var entries []*PayloadEntry
+	var contents []string
+	for {
+		entry, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(pr)
+		require.NoError(t, err)
+		entries = append(entries, entry)
+		contents = append(contents, string(content))
+	}
+	return entries, contents
+}
+
+func TestPayloadReader(t *testing.T) {
+	header := (&headerBuilder{}).
+		addString(RPMTAG_NAME, "hello").
+		addStringArray(RPMTAG_BASENAMES, "bin", "hello", "hi").
+		addStringArray(RPMTAG_DIRNAMES, "/usr/", "/usr/bin/").
+		addInt32(RPMTAG_DIRINDEXES, 0, 1, 1).
+		addString(RPMTAG_PAYLOADFORMAT, "cpio").
+		addString(RPMTAG_PAYLOADCOMPRESSOR, "gzip").
+		bytes()
+	archive := buildNewcArchive(
+		testCpioFile{name: "./usr/bin", mode: s_IFDIR | 0o755, ino: 1, nlink: 2, mtime: 1700000000},
+		testCpioFile{name: "./usr/bin/hello", mode: s_IFREG | 0o755, ino: 2, nlink: 1, mtime: 1700000000, content: "#!/bin/sh\necho hello\n"},
+		testCpioFile{name: "./usr/bin/hi", mode: s_IFLNK | 0o777, ino: 3, nlink: 1, mtime: 1700000000, content: "hello"},
+	)
+	file := buildPackageFile(testSignatureHeader(), header, gzipData(t, archive))
+
+	pr, err := NewPayloadReader(bytes.NewReader(file))
+	require.NoError(t, err)
+	defer pr.Close()
+	assert.Equal(t, "hello", pr.Package().Name)
+
+	entries, contents := readPayload(t, pr)
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, &PayloadEntry{
+		Path:      "/usr/bin",
+		Mode:      s_IFDIR | 0o755,
+		UID:       0,
+		GID:       0,
+		MTime:     time.Unix(1700000000, 0).UTC(),
+		Nlink:     2,
+		Inode:     1,
+		FileIndex: 0,
+	}, entries[0])
+	assert.True(t, entries[0].FileMode().IsDir())
+
+	assert.Equal(t, "/usr/bin/hello", entries[1].Path)
+	assert.Equal(t, int64(21), entries[1].Size)
+	assert.Equal(t, 1, entries[1].FileIndex)
+	assert.Equal(t, fs.FileMode(0o755), entries[1].FileMode())
+	assert.Equal(t, "#!/bin/sh\necho hello\n", contents[1])
+
+	assert.Equal(t, "hello", entries[2].Linkname)
+	assert.Equal(t, fs.ModeSymlink|0o777, entries[2].FileMode())
+	assert.Empty(t, contents[2])
+
+	_, err = pr.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPayloadReaderStripped(t *testing.T) {
+	header := (&headerBuilder{}).
+		addString(RPMTAG_NAME, "big").
+		addStringArray(RPMTAG_BASENAMES, "a", "b", "c").
+		addStringArray(RPMTAG_DIRNAMES, "/data/").
+		addInt32(RPMTAG_DIRINDEXES, 0, 0, 0).
+		addInt16(RPMTAG_FILEMODES, s_IFREG|0o644, s_IFREG|0o644, s_IFREG|0o600).
+		addInt64(RPMTAG_LONGFILESIZES, 3, 3, 2).
+		addInt32(RPMTAG_FILEMTIMES, 1700000000, 1700000000, 1700000001).
+		addInt32(RPMTAG_FILEINODES, 1, 1, 2).
+		addStringArray(RPMTAG_FILEUSERNAME, "root", "root", "nobody").
+		addStringArray(RPMTAG_FILEGROUPNAME, "root", "root", "nobody").
+		addString(RPMTAG_PAYLOADFORMAT, "cpio").
+		addString(RPMTAG_PAYLOADCOMPRESSOR, "identity").
+		bytes()
+
+	// "a" and "b" are hardlinks, only the last one carries the content
+	var buf bytes.Buffer
+	for _, f := range []struct {
+		index   int
+		content string
+	}{{0, ""}, {1, "abc"}, {2, "xy"}} {
+		fmt.Fprintf(&buf, "%s%08x", cpioStrippedMagic, f.index)
+		cpioPad(&buf)
+		buf.WriteString(f.content)
+		cpioPad(&buf)
+	}
+	fmt.Fprintf(&buf, "%s%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		cpioNewcMagic, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, len(cpioTrailer)+1, 0)
+	buf.WriteString(cpioTrailer + "\x00")
+	cpioPad(&buf)
+
+	file := buildPackageFile(testSignatureHeader(), header, buf.Bytes())
+	pr, err := NewPayloadReader(bytes.NewReader(file))
+	require.NoError(t, err)
+	defer pr.Close()
+
+	entries, contents := readPayload(t, pr)
+	require.Len(t, entries, 3)
+	assert.Equal(t, []string{"", "abc", "xy"}, contents)
+	assert.Equal(t, &PayloadEntry{
+		Path:      "/data/c",
+		Mode:      s_IFREG | 0o600,
+		Size:      2,
+		Username:  "nobody",
+		Groupname: "nobody",
+		MTime:     time.Unix(1700000001, 0).UTC(),
+		Nlink:     1,
+		Inode:     2,
+		FileIndex: 2,
+	}, entries[2])
+	assert.Equal(t, "/data/a", entries[0].Path)
+	assert.Equal(t, int64(3), entries[0].Size)
+}
+
+func TestPayloadReaderDecompressor(t *testing.T) {
+	header := (&headerBuilder{}).
+		addString(RPMTAG_NAME, "hello").
+		addString(RPMTAG_PAYLOADCOMPRESSOR, "test-reverse").
+		bytes()
+	archive := buildNewcArchive(testCpioFile{name: "./etc/motd", mode: s_IFREG | 0o644, nlink: 1, content: "hi"})
+	reversed := make([]byte, len(archive))
+	for i, b := range archive {
+		reversed[len(archive)-1-i] = b
+	}
+	file := buildPackageFile(testSignatureHeader(), header, reversed)
+
+	_, err := NewPayloadReader(bytes.NewReader(file))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "RegisterDecompressor")
+
+	RegisterDecompressor("test-reverse", func(r io.Reader) (io.ReadCloser, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+			data[i], data[j] = data[j], data[i]
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+
+	pr, err := NewPayloadReader(bytes.NewReader(file))
+	require.NoError(t, err)
+	defer pr.Close()
+
+	entries, contents := readPayload(t, pr)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "/etc/motd", entries[0].Path)
+	assert.Equal(t, -1, entries[0].FileIndex)
+	assert.Equal(t, []string{"hi"}, contents)
+}
+
+func TestPayloadReaderErrors(t *testing.T) {
+	t.Run("unsupported format", func(t *testing.T) {
+		header := (&headerBuilder{}).
+			addString(RPMTAG_NAME, "hello").
+			addString(RPMTAG_PAYLOADFORMAT, "drpm").
+			bytes()
+		_, err := NewPayloadReader(bytes.NewReader(buildPackageFile(testSignatureHeader(), header, nil)))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unsupported payload format")
+	})
+
+	t.Run("invalid magic", func(t *testing.T) {
+		header := (&headerBuilder{}).
+			addString(RPMTAG_NAME, "hello").
+			addString(RPMTAG_PAYLOADCOMPRESSOR, "identity").
+			bytes()
+		pr, err := NewPayloadReader(bytes.NewReader(buildPackageFile(testSignatureHeader(), header, []byte("not a cpio archive"))))
+		require.NoError(t, err)
+		_, err = pr.Next()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid cpio magic")
+	})
+	t.Run("link target too long", func(t *testing.T) {
+		header := (&headerBuilder{}).
+			addString(RPMTAG_NAME, "hello").
+			addString(RPMTAG_PAYLOADCOMPRESSOR, "identity").
+			bytes()
+		payload := buildNewcArchive(testCpioFile{name: "./usr/bin/hi", mode: 0o120777, content: strings.Repeat("a", 4097)})
+		pr, err := NewPayloadReader(bytes.NewReader(buildPackageFile(testSignatureHeader(), header, payload)))
+		require.NoError(t, err)
+		_, err = pr.Next()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "link target of /usr/bin/hi exceeds")
+	})
+}
@@ -0,0 +1,314 @@
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// ref. https://www.rfc-editor.org/rfc/rfc9580#section-9.1
+var pgpPubKeyAlgos = map[uint8]string{
+	0x01: "RSA",
+	0x11: "DSA",
+	0x13: "ECDSA",
+	0x16: "EdDSA",
+	0x1b: "Ed25519",
+	0x1c: "Ed448",
+}
+
+// ref. https://www.rfc-editor.org/rfc/rfc9580#section-9.5
+var pgpHashAlgos = map[uint8]string{
+	0x02: "SHA1",
+	0x08: "SHA256",
+	0x09: "SHA384",
+	0x0a: "SHA512",
+	0x0b: "SHA224",
+	0x0c: "SHA3-256",
+	0x0e: "SHA3-512",
+}
+
+const (
+	pgpSignaturePacketTag = 2
+
+	// ref. https://www.rfc-editor.org/rfc/rfc9580#section-5.2.3.7
+	pgpSubpacketCreationTime      = 2
+	pgpSubpacketIssuerKeyID       = 16
+	pgpSubpacketIssuerFingerprint = 33
+)
+
+// parseOpenPGPSignature formats a base64 encoded OpenPGP signature packet of RPMTAG_OPENPGP the same way as RPMTAG_PGP.
+// v3, v4 and v6 signature packets are supported.
+func parseOpenPGPSignature(signature string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return "", xerrors.Errorf("invalid OpenPGP signature encoding: %w", err)
+	}
+
+	body, err := pgpPacketBody(data)
+	if err != nil {
+		return "", err
+	}
+	if len(body) < 1 {
+		return "", xerrors.New("empty OpenPGP signature packet")
+	}
+
+	var pubKeyAlgo, hashAlgo uint8
+	var created uint32
+	var keyID []byte
+
+	switch version := body[0]; version {
+	case 3:
+		// ref. https://www.rfc-editor.org/rfc/rfc9580#section-5.2.2
+		if len(body) < 19 {
+			return "", xerrors.New("short v3 OpenPGP signature packet")
+		}
+		created = binary.BigEndian.Uint32(body[3:7])
+		keyID = body[7:15]
+		pubKeyAlgo, hashAlgo = body[15], body[16]
+	case 4, 6:
+		// ref. https://www.rfc-editor.org/rfc/rfc9580#section-5.2.3
+		lenSize := 2
+		if version == 6 {
+			lenSize = 4
+		}
+		if len(body) < 4 {
+			return "", xerrors.Errorf("short v%d OpenPGP signature packet", version)
+		}
+		pubKeyAlgo, hashAlgo = body[2], body[3]
+
+		rest := body[4:]
+		for i := 0; i < 2; i++ {
+			if len(rest) < lenSize {
+				return "", xerrors.Errorf("short v%d OpenPGP signature packet", version)
+			}
+			var n int
+			if lenSize == 2 {
+				n = int(binary.BigEndian.Uint16(rest))
+			} else {
+				n = int(binary.BigEndian.Uint32(rest))
+			}
+			rest = rest[lenSize:]
+			if n < 0 || n > len(rest) {
+				return "", xerrors.New("invalid OpenPGP subpacket area length")
+			}
+
+			err = pgpSubpackets(rest[:n], func(typ uint8, value []byte) {
+				switch typ {
+				case pgpSubpacketCreationTime:
+					if len(value) == 4 && created == 0 {
+						created = binary.BigEndian.Uint32(value)
+					}
+				case pgpSubpacketIssuerKeyID:
+					if len(value) == 8 && keyID == nil {
+						keyID = value
+					}
+				case pgpSubpacketIssuerFingerprint:
+					// v4 key IDs are the low 64 bits of the fingerprint, v6 key IDs the high 64 bits
+					switch {
+					case len(value) == 21 && value[0] == 4:
+						keyID = value[13:]
+					case len(value) == 33 && value[0] == 6:
+						keyID = value[1:9]
+					}
+				}
+			})
+			if err != nil {
+				return "", err
+			}
+			rest = rest[n:]
+		}
+	default:
+		return "", xerrors.Errorf("unsupported OpenPGP signature version: %d", version)
+	}
+
+	date := time.Unix(int64(created), 0).UTC().Format("Mon Jan _2 15:04:05 2006")
+	return fmt.Sprintf("%s/%s, %s, Key ID %x", pgpPubKeyAlgos[pubKeyAlgo], pgpHashAlgos[hashAlgo], date, keyID), nil
+}
+
+// ref. https://www.rfc-editor.org/rfc/rfc9580#section-4.2
+func pgpPacketBody(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0]&0x80 == 0 {
+		return nil, xerrors.New("invalid OpenPGP packet header")
+	}
+
+	var tag uint8
+	var length, offset int
+	if data[0]&0x40 != 0 {
+		// new format
+		tag = data[0] & 0x3f
+		switch o := data[1]; {
+		case o < 192:
+			length, offset = int(o), 2
+		case o < 224:
+			if len(data) < 3 {
+				return nil, xerrors.New("invalid OpenPGP packet length")
+			}
+			length, offset = (int(o)-192)<<8+int(data[2])+192, 3
+		case o == 255:
+			if len(data) < 6 {
+				return nil, xerrors.New("invalid OpenPGP packet length")
+			}
+			length, offset = int(binary.BigEndian.Uint32(data[2:6])), 6
+		default:
+			return nil, xerrors.New("partial OpenPGP packet lengths are not supported")
+		}
+	} else {
+		// old format
+		tag = (data[0] >> 2) & 0x0f
+		switch data[0] & 0x03 {
+		case 0:
+			length, offset = int(data[1]), 2
+		case 1:
+			if len(data) < 3 {
+				return nil, xerrors.New("invalid OpenPGP packet length")
+			}
+			length, offset = int(binary.BigEndian.Uint16(data[1:3])), 3
+		case 2:
+			if len(data) < 5 {
+				return nil, xerrors.New("invalid OpenPGP packet length")
+			}
+			length, offset = int(binary.BigEndian.Uint32(data[1:5])), 5
+		default:
+			length, offset = len(data)-1, 1
+		}
+	}
+
+	if tag != pgpSignaturePacketTag {
+		return nil, xerrors.Errorf("unexpected OpenPGP packet tag: %d", tag)
+	}
+	if length < 0 || offset+length > len(data) {
+		return nil, xerrors.New("truncated OpenPGP packet")
+	}
+	return data[offset : offset+length], nil
+}
+
+// ref. https://www.rfc-editor.org/rfc/rfc9580#section-5.2.3.7
+func pgpSubpackets(data []byte, fn func(typ uint8, value []byte)) error {
+	for len(data) > 0 {
+		var length, offset int
+		switch o := data[0]; {
+		case o < 192:
+			length, offset = int(o), 1
+		case o < 255:
+			if len(data) < 2 {
+				return xerrors.New("invalid OpenPGP subpacket length")
+			}
+			length, offset = (int(o)-192)<<8+int(data[1])+192, 2
+		default:
+			if len(data) < 5 {
+				return xerrors.New("invalid OpenPGP subpacket length")
+			}
+			length, offset = int(binary.BigEndian.Uint32(data[1:5])), 5
+		}
+		if length < 1 || offset+length > len(data) {
+			return xerrors.New("truncated OpenPGP subpacket")
+		}
+		// the high bit is the "critical" flag
+		fn(data[offset]&0x7f, data[offset+1:offset+length])
+		data = data[offset+length:]
+	}
+	return nil
+}
+
+// parsePGPSignature formats the binary OpenPGP signature of RPMTAG_PGP
+func parsePGPSignature(data []byte) (string, error) {
+	type pgpSig struct {
+		_          [3]byte
+		Date       int32
+		KeyID      [8]byte
+		PubKeyAlgo uint8
+		HashAlgo   uint8
+	}
+
+	type textSig struct {
+		_          [2]byte
+		PubKeyAlgo uint8
+		HashAlgo   uint8
+		_          [4]byte
+		Date       int32
+		_          [4]byte
+		KeyID      [8]byte
+	}
+
+	type pgp4Sig struct {
+		_          [2]byte
+		PubKeyAlgo uint8
+		HashAlgo   uint8
+		_          [17]byte
+		KeyID      [8]byte
+		_          [2]byte
+		Date       int32
+	}
+
+	var tag, signatureType, version uint8
+	r := bytes.NewReader(data)
+	err := binary.Read(r, binary.BigEndian, &tag)
+	if err != nil {
+		return "", err
+	}
+	err = binary.Read(r, binary.BigEndian, &signatureType)
+	if err != nil {
+		return "", err
+	}
+	err = binary.Read(r, binary.BigEndian, &version)
+	if err != nil {
+		return "", err
+	}
+
+	var pubKeyAlgo, hashAlgo, pkgDate string
+	var keyId [8]byte
+
+	switch signatureType {
+	case 0x01:
+		switch version {
+		case 0x1c:
+			sig := textSig{}
+			err = binary.Read(r, binary.BigEndian, &sig)
+			if err != nil {
+				return "", xerrors.Errorf("invalid PGP signature on decode: %w", err)
+			}
+			pubKeyAlgo = pgpPubKeyAlgos[sig.PubKeyAlgo]
+			hashAlgo = pgpHashAlgos[sig.HashAlgo]
+			pkgDate = time.Unix(int64(sig.Date), 0).UTC().Format("Mon Jan _2 15:04:05 2006")
+			keyId = sig.KeyID
+		default:
+			sig := pgpSig{}
+			err = binary.Read(r, binary.BigEndian, &sig)
+			if err != nil {
+				return "", xerrors.Errorf("invalid PGP signature on decode: %w", err)
+			}
+			pubKeyAlgo = pgpPubKeyAlgos[sig.PubKeyAlgo]
+			hashAlgo = pgpHashAlgos[sig.HashAlgo]
+			pkgDate = time.Unix(int64(sig.Date), 0).UTC().Format("Mon Jan _2 15:04:05 2006")
+			keyId = sig.KeyID
+		}
+	case 0x02:
+		switch version {
+		case 0x33:
+			sig := pgp4Sig{}
+			err = binary.Read(r, binary.BigEndian, &sig)
+			if err != nil {
+				return "", xerrors.Errorf("invalid PGP signature on decode: %w", err)
+			}
+			pubKeyAlgo = pgpPubKeyAlgos[sig.PubKeyAlgo]
+			hashAlgo = pgpHashAlgos[sig.HashAlgo]
+			pkgDate = time.Unix(int64(sig.Date), 0).UTC().Format("Mon Jan _2 15:04:05 2006")
+			keyId = sig.KeyID
+		default:
+			sig := pgpSig{}
+			err = binary.Read(r, binary.BigEndian, &sig)
+			if err != nil {
+				return "", xerrors.Errorf("invalid PGP signature on decode: %w", err)
+			}
+			pubKeyAlgo = pgpPubKeyAlgos[sig.PubKeyAlgo]
+			hashAlgo = pgpHashAlgos[sig.HashAlgo]
+			pkgDate = time.Unix(int64(sig.Date), 0).UTC().Format("Mon Jan _2 15:04:05 2006")
+			keyId = sig.KeyID
+		}
+	}
+	return fmt.Sprintf("%s/%s, %s, Key ID %x", pubKeyAlgo, hashAlgo, pkgDate, keyId), nil
+}
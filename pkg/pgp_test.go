@@ -0,0 +1,97 @@
+package rpmdb
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSubpacket(typ uint8, value ...byte) []byte {
+	return append([]byte{byte(len(value) + 1), typ}, value...)
+}
+
+func testSignaturePacket(body []byte) string {
+	packet := append([]byte{0xc0 | pgpSignaturePacketTag, byte(len(body))}, body...)
+	return base64.StdEncoding.EncodeToString(packet)
+}
+
+func TestParseOpenPGPSignature(t *testing.T) {
+	created := []byte{0x5f, 0x04, 0x9d, 0xf8} // Tue Jul  7 16:08:24 2020
+	v4Fingerprint := append([]byte{4}, make([]byte, 12)...)
+	v4Fingerprint = append(v4Fingerprint, 0x05, 0xb5, 0x55, 0xb3, 0x84, 0x83, 0xc6, 0x5d)
+	v6Fingerprint := append([]byte{6, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}, make([]byte, 24)...)
+
+	v4Hashed := append(testSubpacket(pgpSubpacketIssuerFingerprint, v4Fingerprint...), testSubpacket(pgpSubpacketCreationTime, created...)...)
+	v4 := []byte{4, 0x00, 0x01, 0x08, 0x00, byte(len(v4Hashed))}
+	v4 = append(v4, v4Hashed...)
+	v4 = append(v4, 0x00, 0x00, 0xab, 0xcd)
+
+	v6Hashed := append(testSubpacket(pgpSubpacketCreationTime, created...), testSubpacket(0x80|pgpSubpacketIssuerFingerprint, v6Fingerprint...)...)
+	v6 := []byte{6, 0x00, 0x1b, 0x0a}
+	v6 = append(v6, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(v6[4:], uint32(len(v6Hashed)))
+	v6 = append(v6, v6Hashed...)
+	v6 = append(v6, 0, 0, 0, 0, 0xab, 0xcd)
+
+	v3 := []byte{3, 5, 0x00}
+	v3 = append(v3, created...)
+	v3 = append(v3, 0x05, 0xb5, 0x55, 0xb3, 0x84, 0x83, 0xc6, 0x5d, 0x01, 0x02, 0xab, 0xcd)
+
+	tests := []struct {
+		name      string
+		signature string
+		want      string
+		wantErr   string
+	}{
+		{
+			name:      "v3 signature",
+			signature: testSignaturePacket(v3),
+			want:      "RSA/SHA1, Tue Jul  7 16:08:24 2020, Key ID 05b555b38483c65d",
+		},
+		{
+			name:      "v4 signature",
+			signature: testSignaturePacket(v4),
+			want:      "RSA/SHA256, Tue Jul  7 16:08:24 2020, Key ID 05b555b38483c65d",
+		},
+		{
+			name:      "v6 signature",
+			signature: testSignaturePacket(v6),
+			want:      "Ed25519/SHA512, Tue Jul  7 16:08:24 2020, Key ID 1122334455667788",
+		},
+		{
+			name:      "old format packet header",
+			signature: base64.StdEncoding.EncodeToString(append([]byte{0x88, byte(len(v4))}, v4...)),
+			want:      "RSA/SHA256, Tue Jul  7 16:08:24 2020, Key ID 05b555b38483c65d",
+		},
+		{
+			name:      "unsupported version",
+			signature: testSignaturePacket([]byte{5, 0, 1, 8}),
+			wantErr:   "unsupported OpenPGP signature version",
+		},
+		{
+			name:      "not a signature packet",
+			signature: base64.StdEncoding.EncodeToString([]byte{0xc6, 0x01, 0x04}),
+			wantErr:   "unexpected OpenPGP packet tag",
+		},
+		{
+			name:      "truncated",
+			signature: base64.StdEncoding.EncodeToString([]byte{0xc2, 0x10, 0x04}),
+			wantErr:   "truncated OpenPGP packet",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOpenPGPSignature(tt.signature)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
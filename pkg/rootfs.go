@@ -0,0 +1,168 @@
+package rpmdb
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// LstatFS is a file system that can stat symbolic links without following them
+type LstatFS interface {
+	fs.FS
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// ReadLinkFS is a file system that can read the target of symbolic links
+type ReadLinkFS interface {
+	fs.FS
+	ReadLink(name string) (string, error)
+}
+
+type dirFS string
+
+// DirFS returns a file system for the tree of files rooted at dir, which also implements
+// LstatFS and ReadLinkFS. Unlike os.DirFS, symbolic links are resolved inside dir as if it
+// were the root directory, so that the links of an extracted image never lead to the files
+// of the host.
+func DirFS(dir string) fs.FS {
+	return dirFS(dir)
+}
+
+// resolve returns the path on the host of name, whose symbolic links are resolved like in a
+// chroot to dir: absolute targets start at dir and ".." stops at dir. The last component is
+// only resolved if follow is set.
+func (dir dirFS) resolve(op, name string, follow bool) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	var resolved []string
+	pending := strings.Split(name, "/")
+	for links := 0; len(pending) > 0; {
+		component := pending[0]
+		pending = pending[1:]
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			continue
+		}
+
+		next := append(resolved[:len(resolved):len(resolved)], component)
+		if len(pending) == 0 && !follow {
+			resolved = next
+			break
+		}
+		info, err := os.Lstat(dir.hostPath(next))
+		if err != nil {
+			return "", &fs.PathError{Op: op, Path: name, Err: underlyingError(err)}
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &fs.PathError{Op: op, Path: name, Err: errTooManyLinks}
+		}
+		target, err := os.Readlink(dir.hostPath(next))
+		if err != nil {
+			return "", &fs.PathError{Op: op, Path: name, Err: underlyingError(err)}
+		}
+		target = filepath.ToSlash(target)
+		if path.IsAbs(target) {
+			resolved = nil
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return dir.hostPath(resolved), nil
+}
+
+// hostPath returns the path on the host of the components of a path inside dir
+func (dir dirFS) hostPath(components []string) string {
+	return filepath.Join(append([]string{string(dir)}, components...)...)
+}
+
+// underlyingError returns the error of an *fs.PathError, which is reported with the name in dir instead
+func underlyingError(err error) error {
+	var pathErr *fs.PathError
+	if xerrors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
+
+var errTooManyLinks = xerrors.New("too many levels of symbolic links")
+
+func (dir dirFS) Open(name string) (fs.File, error) {
+	fullname, err := dir.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fullname)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: underlyingError(err)}
+	}
+	return f, nil
+}
+
+func (dir dirFS) Stat(name string) (fs.FileInfo, error) {
+	fullname, err := dir.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	// the last component isn't a symbolic link anymore
+	info, err := os.Lstat(fullname)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: underlyingError(err)}
+	}
+	return info, nil
+}
+
+func (dir dirFS) Lstat(name string) (fs.FileInfo, error) {
+	fullname, err := dir.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(fullname)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: underlyingError(err)}
+	}
+	return info, nil
+}
+
+func (dir dirFS) ReadLink(name string) (string, error) {
+	fullname, err := dir.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	target, err := os.Readlink(fullname)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: underlyingError(err)}
+	}
+	return target, nil
+}
+
+func lstat(fsys fs.FS, name string) (fs.FileInfo, error) {
+	if lfs, ok := fsys.(LstatFS); ok {
+		return lfs.Lstat(name)
+	}
+	return fs.Stat(fsys, name)
+}
+
+// fsPath converts an absolute path of an installed file to a path of an fs.FS
+func fsPath(name string) string {
+	name = path.Clean("/" + name)[1:]
+	if name == "" {
+		return "."
+	}
+	return name
+}
@@ -4,18 +4,104 @@ import (
 	"context"
 	"github.com/jfrog/go-rpmdb/pkg/bdb"
 	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"github.com/jfrog/go-rpmdb/pkg/lmdb"
 	"github.com/jfrog/go-rpmdb/pkg/ndb"
 	"github.com/jfrog/go-rpmdb/pkg/sqlite3"
 	"golang.org/x/xerrors"
+	"sync"
+	"time"
 )
 
 type RpmDB struct {
 	db dbi.RpmDBInterface
+	// decodeWorkers is the number of goroutines decoding headers, see WithDecodeWorkers
+	decodeWorkers int
+	// lock is set by WithLock
+	lock *dbLock
+
+	mu        sync.Mutex
+	pathIndex *PathIndex
+	// pathIndexVersion is the version of the database the path index was built from
+	pathIndexVersion uint64
+}
+
+// Option configures Open
+type Option func(*options)
+
+type options struct {
+	sqlDriver     string
+	mmap          bool
+	decodeWorkers int
+	lock          bool
+	lockTimeout   time.Duration
+	lockFile      string
+}
+
+// WithSQLDriver reads SQLite rpmdbs through database/sql with the named driver, e.g. "sqlite"
+// of github.com/glebarez/go-sqlite, instead of the built-in reader of the SQLite file format
+func WithSQLDriver(driverName string) Option {
+	return func(o *options) {
+		o.sqlDriver = driverName
+	}
+}
+
+// WithMmap maps Berkeley DB, NDB and LMDB databases into memory instead of reading them with
+// system calls. The database must not be truncated while it's open.
+func WithMmap() Option {
+	return func(o *options) {
+		o.mmap = true
+	}
+}
+
+// WithDecodeWorkers decodes the headers of ListPackages with up to n goroutines, which
+// pays off for databases with thousands of packages. The order of the packages is kept.
+func WithDecodeWorkers(n int) Option {
+	return func(o *options) {
+		o.decodeWorkers = n
+	}
+}
+
+// WithLock takes a shared lock on the .rpm.lock of rpm while reading the database, like rpm
+// does for queries, so that a running transaction isn't read half written. It waits up to
+// timeout for the transaction to finish and fails with ErrLocked then. The database is
+// reopened when it was modified since it was opened, and read again when it's modified
+// while reading it, by an rpm which doesn't take the lock.
+func WithLock(timeout time.Duration) Option {
+	return func(o *options) {
+		o.lock = true
+		o.lockTimeout = timeout
+	}
+}
+
+// WithLockFile locks path instead of .rpm.lock in the directory of the database with
+// WithLock, e.g. for an rpm configured with another %_rpmlock_path
+func WithLockFile(path string) Option {
+	return func(o *options) {
+		o.lockFile = path
+	}
 }
 
-func Open(path string) (*RpmDB, error) {
+func Open(path string, opts ...Option) (*RpmDB, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	openDB := open
+	if o.lock {
+		openDB = openLocked
+	}
+	d, err := openDB(path, o)
+	if err != nil {
+		return nil, err
+	}
+	d.decodeWorkers = o.decodeWorkers
+	return d, nil
+}
+
+func open(path string, o options) (*RpmDB, error) {
 	// SQLite3 Open() returns nil, nil in case of DB format other than SQLite3
-	sqldb, err := sqlite3.Open(path)
+	sqldb, err := openSQLite3(path, o.sqlDriver)
 	if err != nil && !xerrors.Is(err, sqlite3.ErrorInvalidSQLite3) {
 		return nil, err
 	}
@@ -23,17 +109,37 @@ func Open(path string) (*RpmDB, error) {
 		return &RpmDB{db: sqldb}, nil
 	}
 
+	openFile := dbi.OpenFile
+	if o.mmap {
+		openFile = dbi.OpenMmap
+	}
+	file, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+
 	// NDB Open() returns nil, nil in case of DB format other than NDB
-	ndbh, err := ndb.Open(path)
+	ndbh, err := ndb.OpenFile(file)
 	if err != nil && !xerrors.Is(err, ndb.ErrorInvalidNDB) {
+		file.Close()
 		return nil, err
 	}
 	if ndbh != nil {
 		return &RpmDB{db: ndbh}, nil
 	}
 
-	odb, err := bdb.Open(path)
+	lmdbh, err := lmdb.OpenFile(file)
+	if err != nil && !xerrors.Is(err, lmdb.ErrorInvalidLMDB) {
+		file.Close()
+		return nil, err
+	}
+	if lmdbh != nil {
+		return &RpmDB{db: lmdbh}, nil
+	}
+
+	odb, err := bdb.OpenFile(file)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
@@ -43,18 +149,46 @@ func Open(path string) (*RpmDB, error) {
 
 }
 
+func openSQLite3(path, driverName string) (dbi.RpmDBInterface, error) {
+	if driverName != "" {
+		db, err := sqlite3.OpenDriver(path, driverName)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+
+	db, err := sqlite3.OpenNative(path)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// PackageWithContext returns the first package with the name in the order of ListPackages.
+// Names installed more than once, e.g. multilib packages or several kernels, are looked up
+// by reading all packages, since the indexes order packages by instance number instead, and so are
+// names missing from the index, which may be out of date with Packages.
 func (d *RpmDB) PackageWithContext(ctx context.Context, name string) (*PackageInfo, error) {
-	pkgs, err := d.ListPackagesWithContext(ctx)
+	var pkgs []*PackageInfo
+	err := d.locked(ctx, func(db dbi.RpmDBInterface) (err error) {
+		if r, ok := db.(dbi.IndexReader); ok {
+			pkgs, err = lookupIndex(ctx, r, IndexName, []byte(name))
+			if (err == nil && len(pkgs) == 1) || ctx.Err() != nil {
+				return err
+			}
+		}
+		pkgs, err = scanIndex(ctx, db, IndexName, []byte(name))
+		return err
+	})
 	if err != nil {
 		return nil, xerrors.Errorf("unable to list packages: %w", err)
 	}
 
-	for _, pkg := range pkgs {
-		if pkg.Name == name {
-			return pkg, nil
-		}
+	if len(pkgs) == 0 {
+		return nil, xerrors.Errorf("%s is not installed: %w", name, dbi.ErrPackageNotFound)
 	}
-	return nil, xerrors.Errorf("%s is not installed", name)
+	return pkgs[0], nil
 }
 
 func (d *RpmDB) Package(name string) (*PackageInfo, error) {
@@ -62,20 +196,43 @@ func (d *RpmDB) Package(name string) (*PackageInfo, error) {
 }
 
 func (d *RpmDB) ListPackagesWithContext(ctx context.Context) ([]*PackageInfo, error) {
+	return d.ListPackagesWithOptions(ctx, DecodeOptions{})
+}
+
+// ListPackagesWithOptions lists the packages with the fields selected by opts
+func (d *RpmDB) ListPackagesWithOptions(ctx context.Context, opts DecodeOptions) ([]*PackageInfo, error) {
 	var pkgList []*PackageInfo
+	err := d.locked(ctx, func(db dbi.RpmDBInterface) (err error) {
+		pkgList, err = listPackages(ctx, db, d.decodeWorkers, opts)
+		return err
+	})
+	return pkgList, err
+}
+
+func listPackages(ctx context.Context, db dbi.RpmDBInterface, workers int, opts DecodeOptions) ([]*PackageInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	entries := db.Read(ctx)
+	defer func() {
+		// stop the backend when returning early and wait for it
+		cancel()
+		for range entries {
+		}
+	}()
 
-	for entry := range d.db.Read(ctx) {
+	if workers > 1 {
+		return decodePackages(ctx, entries, workers, opts)
+	}
+
+	var pkgList []*PackageInfo
+	dec := newPackageDecoder(opts)
+	for entry := range entries {
 		if entry.Err != nil {
 			return nil, entry.Err
 		}
 
-		indexEntries, err := headerImport(entry.Value)
+		pkg, err := dec.decode(entry.Value, entry.HeaderNum)
 		if err != nil {
-			return nil, xerrors.Errorf("error during importing header: %w", err)
-		}
-		pkg, err := getNEVRA(indexEntries)
-		if err != nil {
-			return nil, xerrors.Errorf("invalid package info: %w", err)
+			return nil, err
 		}
 		pkgList = append(pkgList, pkg)
 	}
@@ -86,3 +243,82 @@ func (d *RpmDB) ListPackagesWithContext(ctx context.Context) ([]*PackageInfo, er
 func (d *RpmDB) ListPackages() ([]*PackageInfo, error) {
 	return d.ListPackagesWithContext(context.TODO())
 }
+
+// PackageByInstance returns the package with the header instance number, see PackageInfo.DBInstance.
+// Backends which can't read a single header are scanned.
+func (d *RpmDB) PackageByInstance(ctx context.Context, instance uint32) (*PackageInfo, error) {
+	var pkg *PackageInfo
+	err := d.locked(ctx, func(db dbi.RpmDBInterface) (err error) {
+		pkg, err = packageByInstance(ctx, db, instance)
+		return err
+	})
+	return pkg, err
+}
+
+func packageByInstance(ctx context.Context, db dbi.RpmDBInterface, instance uint32) (*PackageInfo, error) {
+	if r, ok := db.(dbi.IndexReader); ok {
+		blob, err := r.ReadHeader(ctx, instance)
+		if err != nil {
+			return nil, xerrors.Errorf("unable to read package %d: %w", instance, err)
+		}
+		return newPackageDecoder(DecodeOptions{}).decode(blob, instance)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	entries := db.Read(ctx)
+	defer func() {
+		cancel()
+		for range entries {
+		}
+	}()
+	for entry := range entries {
+		if entry.Err != nil {
+			return nil, entry.Err
+		}
+		if entry.HeaderNum == instance {
+			return newPackageDecoder(DecodeOptions{}).decode(entry.Value, instance)
+		}
+	}
+	return nil, xerrors.Errorf("no package with instance %d: %w", instance, dbi.ErrHeaderNotFound)
+}
+
+// PathIndexWithContext returns the index of installed file paths.
+// It's built on the first call and reused for bulk lookups until the database is modified.
+func (d *RpmDB) PathIndexWithContext(ctx context.Context) (*PathIndex, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	v, err := d.dbVersion()
+	if err != nil {
+		return nil, err
+	}
+	if d.pathIndex != nil && d.pathIndexVersion == v {
+		return d.pathIndex, nil
+	}
+
+	pkgs, err := d.ListPackagesWithContext(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("unable to list packages: %w", err)
+	}
+	idx, err := NewPathIndex(pkgs)
+	if err != nil {
+		return nil, xerrors.Errorf("unable to index installed files: %w", err)
+	}
+	d.pathIndex, d.pathIndexVersion = idx, v
+	return idx, nil
+}
+
+// WhoOwns returns all packages owning the path, like rpm -qf.
+// Shared directories are usually owned by more than one package.
+func (d *RpmDB) WhoOwns(ctx context.Context, path string) ([]*PackageInfo, error) {
+	idx, err := d.PathIndexWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := idx.WhoOwns(path)
+	if len(pkgs) == 0 {
+		return nil, xerrors.Errorf("file %s is not owned by any package: %w", path, dbi.ErrPackageNotFound)
+	}
+	return pkgs, nil
+}
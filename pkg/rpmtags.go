@@ -36,10 +36,48 @@ const (
 	RPMTAG_SUMMARY        = 1004 /* s */
 	RPMTAG_PGP            = 259  /* b */
 
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.17.0-release/lib/rpmtag.h
+	RPMTAG_VERITYSIGNATURES    = 276  /* s[] */
+	RPMTAG_VERITYSIGNATUREALGO = 277  /* i */
+	RPMTAG_FILESIGNATURES      = 5090 /* s[] */
+	RPMTAG_FILESIGNATURELENGTH = 5091 /* i */
+
+	// file and payload tags used to unpack package payloads
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmtag.h
+	RPMTAG_FILEMTIMES        = 1034 /* i[] */
+	RPMTAG_FILELINKTOS       = 1036 /* s[] */
+	RPMTAG_FILEINODES        = 1096 /* i[] */
+	RPMTAG_PAYLOADFORMAT     = 1124 /* s */
+	RPMTAG_PAYLOADCOMPRESSOR = 1125 /* s */
+	RPMTAG_PAYLOADFLAGS      = 1126 /* s */
+	RPMTAG_LONGFILESIZES     = 5008 /* l[] */
+
+	// file list of legacy packages, rpm converts it to RPMTAG_BASENAMES, RPMTAG_DIRNAMES and RPMTAG_DIRINDEXES
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmtag.h
+	RPMTAG_OLDFILENAMES = 1027 /* s[] (obsolete) */
+
+	// file tags used to verify installed files
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmtag.h
+	RPMTAG_FILESTATES = 1029 /* c[] */
+	RPMTAG_FILERDEVS  = 1033 /* h[] */
+	RPMTAG_FILECAPS   = 5010 /* s[] */
+
+	// install-time tags
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmtag.h
+	RPMTAG_INSTPREFIXES = 1099 /* s[] */
+	RPMTAG_INSTALLCOLOR = 1127 /* i */
+
 	// rpmTag_enhances
 	// https://github.com/rpm-software-management/rpm/blob/rpm-4.16.0-release/lib/rpmtag.h#L375
 	RPMTAG_MODULARITYLABEL = 5096
 
+	// rpm v6 format
+	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-6.0.0-release/include/rpm/rpmtag.h
+	RPMTAG_OPENPGP         = 278  /* s[] */
+	RPMTAG_RPMFORMAT       = 5114 /* i */
+	RPMTAG_FILEDIGESTALGOS = 5121 /* i[] */
+	RPMTAG_FILEALTDIGESTS  = 5122 /* s[] */
+
 	// rpmTagType_e
 	// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmtag.h#L431
 	RPM_MIN_TYPE          = 0
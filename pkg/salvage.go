@@ -0,0 +1,94 @@
+package rpmdb
+
+import (
+	"context"
+
+	"github.com/jfrog/go-rpmdb/pkg/bdb"
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"github.com/jfrog/go-rpmdb/pkg/lmdb"
+	"github.com/jfrog/go-rpmdb/pkg/ndb"
+	"github.com/jfrog/go-rpmdb/pkg/sqlite3"
+	"golang.org/x/xerrors"
+)
+
+// Diagnostic describes a record which was skipped by SalvagePackagesWithContext
+type Diagnostic = dbi.Diagnostic
+
+// SalvageReport holds the packages which could be decoded from a corrupted rpmdb
+// and the records which couldn't
+type SalvageReport struct {
+	Packages    []*PackageInfo
+	Diagnostics []*Diagnostic
+}
+
+// SalvagePackagesWithContext lists the packages like ListPackagesWithContext, but skips the
+// records which can't be read or decoded instead of failing. Berkeley DB databases are also
+// searched for headers which the hash table doesn't refer to anymore, their DBInstance is 0.
+func (d *RpmDB) SalvagePackagesWithContext(ctx context.Context) (*SalvageReport, error) {
+	var report *SalvageReport
+	err := d.locked(ctx, func(db dbi.RpmDBInterface) (err error) {
+		report, err = salvagePackages(ctx, db)
+		return err
+	})
+	return report, err
+}
+
+func salvagePackages(ctx context.Context, db dbi.RpmDBInterface) (*SalvageReport, error) {
+	var entries <-chan dbi.Entry
+	if s, ok := db.(dbi.Salvager); ok {
+		entries = s.Salvage(ctx)
+	} else {
+		entries = db.Read(ctx)
+	}
+
+	report := &SalvageReport{}
+	dec := newPackageDecoder(DecodeOptions{})
+	for entry := range entries {
+		if entry.Err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			var diag *Diagnostic
+			if !xerrors.As(entry.Err, &diag) {
+				// the backend stops reading, keep what has been read so far
+				diag = diagnostic(db, entry.HeaderNum, entry.Err)
+			}
+			report.Diagnostics = append(report.Diagnostics, diag)
+			continue
+		}
+
+		pkg, err := dec.decode(entry.Value, entry.HeaderNum)
+		if err != nil {
+			report.Diagnostics = append(report.Diagnostics, diagnostic(db, entry.HeaderNum, err))
+			continue
+		}
+		report.Packages = append(report.Packages, pkg)
+	}
+	return report, nil
+}
+
+func (d *RpmDB) SalvagePackages() (*SalvageReport, error) {
+	return d.SalvagePackagesWithContext(context.TODO())
+}
+
+// diagnostic describes a record without known location
+func diagnostic(db dbi.RpmDBInterface, hnum uint32, err error) *Diagnostic {
+	var backend string
+	switch db.(type) {
+	case *bdb.BerkeleyDB:
+		backend = "bdb"
+	case *ndb.RpmNDB:
+		backend = "ndb"
+	case *lmdb.RpmLMDB:
+		backend = "lmdb"
+	case *sqlite3.SQLite3, *sqlite3.Native:
+		backend = "sqlite"
+	}
+	return &Diagnostic{
+		Backend:   backend,
+		Slot:      -1,
+		HeaderNum: hnum,
+		Offset:    -1,
+		Err:       err,
+	}
+}
@@ -0,0 +1,208 @@
+package rpmdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/jfrog/go-rpmdb/pkg/ndb"
+)
+
+func TestRpmDB_SalvagePackages_BerkeleyDB(t *testing.T) {
+	hnum := func(n uint32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, n)
+		return b
+	}
+	const pageSize = 1024
+	path := filepath.Join(t.TempDir(), "Packages")
+	createBDBHash(t, path, pageSize,
+		testBDBPair{key: hnum(0), value: hnum(5)},
+		testBDBPair{key: hnum(1), value: testIndexedHeader("bash", "5.2", 1000, "bash"), offPage: true},
+		testBDBPair{key: hnum(2), value: []byte("garbage")},
+		testBDBPair{key: hnum(4), value: testIndexedHeader("kernel", "6.1", 1000, "kernel"), offPage: true},
+	)
+
+	// break the item of hnum 4, which orphans its overflow page
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	itemOffset := binary.LittleEndian.Uint16(data[pageSize+26+2*7:])
+	data[pageSize+int(itemOffset)] = 0x63
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ListPackages()
+	require.Error(t, err)
+
+	report, err := db.SalvagePackages()
+	require.NoError(t, err)
+
+	var names []string
+	var instances []uint32
+	for _, pkg := range report.Packages {
+		names = append(names, pkg.Name)
+		instances = append(instances, pkg.DBInstance)
+	}
+	assert.Equal(t, []string{"bash", "kernel"}, names)
+	assert.Equal(t, []uint32{1, 0}, instances)
+
+	require.Len(t, report.Diagnostics, 2)
+	assert.Equal(t, &Diagnostic{
+		Backend:   "bdb",
+		Slot:      -1,
+		HeaderNum: 2,
+		Offset:    -1,
+		Err:       report.Diagnostics[0].Err,
+	}, report.Diagnostics[0])
+	assert.Contains(t, report.Diagnostics[0].Error(), "error during importing header")
+	assert.Equal(t, &Diagnostic{
+		Backend:   "bdb",
+		PageNo:    1,
+		Slot:      7,
+		HeaderNum: 4,
+		Offset:    pageSize + int64(itemOffset),
+		Err:       report.Diagnostics[1].Err,
+	}, report.Diagnostics[1])
+	assert.Contains(t, report.Diagnostics[1].Error(), "unsupported item type: 99")
+}
+
+func TestRpmDB_SalvagePackages_NDB(t *testing.T) {
+	data, err := os.ReadFile("testdata/sle15-bci/Packages.db")
+	require.NoError(t, err)
+
+	db, err := Open("testdata/sle15-bci/Packages.db")
+	require.NoError(t, err)
+	pkgs, err := db.ListPackages()
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// the slots follow the 32 bytes NDB header, break the magic of the first used slot
+	// and the blob of the second one
+	var used []int
+	for i := 0; len(used) < 2; i++ {
+		if binary.LittleEndian.Uint32(data[32+16*i+4:]) != 0 {
+			used = append(used, i)
+		}
+	}
+	copy(data[32+16*used[0]:], "XXXX")
+	secondHnum := binary.LittleEndian.Uint32(data[32+16*used[1]+4:])
+	blobOffset := int64(binary.LittleEndian.Uint32(data[32+16*used[1]+8:])) * 16
+	copy(data[blobOffset:], "XXXX")
+
+	path := filepath.Join(t.TempDir(), "Packages.db")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	db, err = Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ListPackages()
+	require.Error(t, err)
+
+	report, err := db.SalvagePackagesWithContext(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, report.Packages, len(pkgs)-2)
+
+	require.Len(t, report.Diagnostics, 2)
+	assert.Equal(t, "ndb", report.Diagnostics[0].Backend)
+	assert.Equal(t, used[0], report.Diagnostics[0].Slot)
+	assert.Equal(t, int64(32+16*used[0]), report.Diagnostics[0].Offset)
+	assert.True(t, xerrors.Is(report.Diagnostics[0], ndb.ErrorBadSlotMagic))
+	assert.Equal(t, used[1], report.Diagnostics[1].Slot)
+	assert.Equal(t, secondHnum, report.Diagnostics[1].HeaderNum)
+	assert.Equal(t, blobOffset, report.Diagnostics[1].Offset)
+	assert.True(t, xerrors.Is(report.Diagnostics[1], ndb.ErrorBlobMagic))
+}
+
+func TestRpmDB_SalvagePackages_SQLite3(t *testing.T) {
+	db, err := Open(createSQLite3DB(t,
+		testIndexedHeader("bash", "5.2", 1000, "bash"),
+		[]byte("garbage"),
+		testIndexedHeader("kernel", "6.1", 1000, "kernel"),
+	))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ListPackages()
+	require.Error(t, err)
+
+	report, err := db.SalvagePackages()
+	require.NoError(t, err)
+	require.Len(t, report.Packages, 2)
+	assert.Equal(t, "bash", report.Packages[0].Name)
+	assert.Equal(t, "kernel", report.Packages[1].Name)
+	assert.Equal(t, uint32(3), report.Packages[1].DBInstance)
+
+	require.Len(t, report.Diagnostics, 1)
+	assert.Equal(t, "sqlite", report.Diagnostics[0].Backend)
+	assert.Equal(t, uint32(2), report.Diagnostics[0].HeaderNum)
+}
+
+func TestRpmDB_SalvagePackages_SQLite3Pages(t *testing.T) {
+	var headers [][]byte
+	for i := 0; i < 300; i++ {
+		headers = append(headers, testIndexedHeader(fmt.Sprintf("pkg%d", i), "1.0", 1000, fmt.Sprintf("pkg%d", i)))
+	}
+	path := createSQLite3DB(t, headers...)
+
+	// Packages is rooted at page 2 and sqlite_sequence at page 3, the following leaf
+	// pages hold the rows. Break the type of the first one and the size of the first
+	// cell of the second one.
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	const pageSize = 4096
+	var leaves []int
+	for pgno := 4; pgno*pageSize <= len(data) && len(leaves) < 2; pgno++ {
+		if data[(pgno-1)*pageSize] == 0x0d {
+			leaves = append(leaves, pgno)
+		}
+	}
+	require.Len(t, leaves, 2)
+	first := (leaves[0] - 1) * pageSize
+	skipped := int(binary.BigEndian.Uint16(data[first+3:]))
+	data[first] = 0x42
+	second := (leaves[1] - 1) * pageSize
+	cellOffset := int(binary.BigEndian.Uint16(data[second+8:]))
+	copy(data[second+cellOffset:], bytes.Repeat([]byte{0xff}, 9))
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ListPackages()
+	require.Error(t, err)
+
+	report, err := db.SalvagePackages()
+	require.NoError(t, err)
+	assert.Len(t, report.Packages, len(headers)-skipped-1)
+
+	require.Len(t, report.Diagnostics, 2)
+	assert.Equal(t, &Diagnostic{
+		Backend: "sqlite",
+		PageNo:  uint32(leaves[0]),
+		Slot:    -1,
+		Offset:  int64(first),
+		Err:     report.Diagnostics[0].Err,
+	}, report.Diagnostics[0])
+	assert.Contains(t, report.Diagnostics[0].Error(), "invalid b-tree page: type 66")
+	assert.Equal(t, &Diagnostic{
+		Backend: "sqlite",
+		PageNo:  uint32(leaves[1]),
+		Slot:    0,
+		Offset:  int64(second + cellOffset),
+		Err:     report.Diagnostics[1].Err,
+	}, report.Diagnostics[1])
+	assert.Contains(t, report.Diagnostics[1].Error(), "exceeds the database")
+}
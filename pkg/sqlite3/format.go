@@ -0,0 +1,774 @@
+package sqlite3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"os"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"golang.org/x/xerrors"
+)
+
+/* A reader of the SQLite file format limited to what rpm needs: table and index b-trees, overflow
+   pages, records and the write-ahead log. It's documented here:
+
+   https://www.sqlite.org/fileformat.html
+
+   The database is an array of pages, the first one starts with the 100 bytes "Database
+   Header" holding the page size and the number of pages. Every table is a b-tree keyed by
+   the rowid, the root page of each table is recorded in the sqlite_master table, whose
+   root is the first page. Rows are "Records": a header of the serial types of the columns
+   followed by their values. Large records continue on a chain of overflow pages.
+
+   In WAL mode, committed pages which weren't checkpointed yet live in the -wal file next
+   to the database and take precedence over the pages of the database.
+*/
+
+const (
+	dbHeaderSize = 100
+
+	// b-tree page types
+	interiorIndexPage = 2
+	interiorTablePage = 5
+	leafIndexPage     = 10
+	leafTablePage     = 13
+
+	// the maximum depth of b-trees, to stop on cycles in corrupted databases
+	maxTreeDepth = 32
+
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+	walMagicLE         = 0x377f0682
+	walMagicBE         = 0x377f0683
+)
+
+// the header of a rollback journal which wasn't committed
+var journalMagic = []byte{0xd9, 0xd5, 0x05, 0xf9, 0x20, 0xa1, 0x63, 0xd7}
+
+// pager reads the pages of a database, preferring the committed frames of its WAL
+type pager struct {
+	file     *os.File
+	pageSize int
+	// usable is the page size without the reserved bytes at the end of each page
+	usable int
+	nPages uint32
+
+	wal *os.File
+	// walFrames maps page numbers to the offset of their last committed frame in the WAL
+	walFrames map[uint32]int64
+}
+
+func openPager(path string) (*pager, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := newPager(file, path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func newPager(file *os.File, path string) (*pager, error) {
+	hdr := make([]byte, dbHeaderSize)
+	if _, err := file.ReadAt(hdr, 0); err != nil {
+		if xerrors.Is(err, io.EOF) {
+			return nil, ErrorInvalidSQLite3
+		}
+		return nil, xerrors.Errorf("failed to read database header: %w", err)
+	}
+	if !bytes.Equal(hdr[:len(SQLite3_HeaderMagic)], SQLite3_HeaderMagic) {
+		return nil, ErrorInvalidSQLite3
+	}
+
+	pageSize := int(binary.BigEndian.Uint16(hdr[16:]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, xerrors.Errorf("invalid page size: %d", pageSize)
+	}
+	usable := pageSize - int(hdr[20])
+	if usable < 480 {
+		return nil, xerrors.Errorf("invalid reserved space: %d", hdr[20])
+	}
+	// rpm creates UTF-8 databases, 0 is a database without any table yet
+	if encoding := binary.BigEndian.Uint32(hdr[56:]); encoding > 1 {
+		return nil, xerrors.Errorf("unsupported text encoding: %d", encoding)
+	}
+
+	p := &pager{
+		file:     file,
+		pageSize: pageSize,
+		usable:   usable,
+	}
+
+	// the size in the header is only valid if it was written by the same version as the change counter
+	p.nPages = binary.BigEndian.Uint32(hdr[28:])
+	if p.nPages == 0 || binary.BigEndian.Uint32(hdr[24:]) != binary.BigEndian.Uint32(hdr[92:]) {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, xerrors.Errorf("failed to stat database: %w", err)
+		}
+		p.nPages = uint32(info.Size() / int64(pageSize))
+	}
+
+	if err := checkJournal(path + "-journal"); err != nil {
+		return nil, err
+	}
+	if err := p.openWAL(path + "-wal"); err != nil {
+		return nil, xerrors.Errorf("%s: %v: %w", path, err, ErrorWALNotApplied)
+	}
+	return p, nil
+}
+
+// checkJournal fails on a hot rollback journal, the database may contain uncommitted pages then
+func checkJournal(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return xerrors.Errorf("%s: %v: %w", path, err, ErrorWALNotApplied)
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(journalMagic))
+	if _, err = io.ReadFull(file, magic); err != nil {
+		// an empty or truncated journal is not hot
+		return nil
+	}
+	if bytes.Equal(magic, journalMagic) {
+		return xerrors.Errorf("%s: uncommitted transaction: %w", path, ErrorWALNotApplied)
+	}
+	return nil
+}
+
+// fileVersion hashes the file change counter and the size of the database header, and the
+// header and the size of the WAL. Committed transactions change the counter in rollback
+// journal mode, and append frames to the WAL or restart it with new salts in WAL mode.
+// ref. https://www.sqlite.org/fileformat.html#file_change_counter
+func fileVersion(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	hdr := make([]byte, dbHeaderSize)
+	if _, err = file.ReadAt(hdr, 0); err != nil {
+		return 0, xerrors.Errorf("failed to read database header: %w", err)
+	}
+	h := fnv.New64a()
+	h.Write(hdr[24:32])
+
+	wal, err := os.Open(path + "-wal")
+	if os.IsNotExist(err) {
+		return h.Sum64(), nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer wal.Close()
+
+	info, err := wal.Stat()
+	if err != nil {
+		return 0, err
+	}
+	walHdr := make([]byte, walHeaderSize)
+	n, _ := wal.ReadAt(walHdr, 0)
+	h.Write(walHdr[:n])
+	binary.Write(h, binary.BigEndian, info.Size())
+	return h.Sum64(), nil
+}
+
+// openWAL indexes the committed frames of the WAL. Like SQLite, a WAL with an invalid
+// header is ignored, and the frames after the first invalid one are ignored.
+// ref. https://www.sqlite.org/fileformat.html#the_write_ahead_log
+func (p *pager) openWAL(path string) error {
+	wal, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	info, err := wal.Stat()
+	if err != nil {
+		wal.Close()
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		wal.Close()
+		return xerrors.Errorf("%s is not a regular file", path)
+	}
+
+	hdr := make([]byte, walHeaderSize)
+	if _, err = wal.ReadAt(hdr, 0); err != nil {
+		// an empty WAL has no frames
+		wal.Close()
+		return nil
+	}
+
+	var order binary.ByteOrder
+	switch binary.BigEndian.Uint32(hdr) {
+	case walMagicLE:
+		order = binary.LittleEndian
+	case walMagicBE:
+		order = binary.BigEndian
+	default:
+		wal.Close()
+		return nil
+	}
+	s0, s1 := walChecksum(order, hdr[:24], 0, 0)
+	if int(binary.BigEndian.Uint32(hdr[8:])) != p.pageSize ||
+		s0 != binary.BigEndian.Uint32(hdr[24:]) || s1 != binary.BigEndian.Uint32(hdr[28:]) {
+		wal.Close()
+		return nil
+	}
+	salt := hdr[16:24]
+
+	frames := make(map[uint32]int64)
+	pending := make(map[uint32]int64)
+	frame := make([]byte, walFrameHeaderSize+p.pageSize)
+	for offset := int64(walHeaderSize); offset+int64(len(frame)) <= info.Size(); offset += int64(len(frame)) {
+		if _, err = wal.ReadAt(frame, offset); err != nil {
+			wal.Close()
+			return xerrors.Errorf("failed to read WAL frame: %w", err)
+		}
+		if !bytes.Equal(frame[8:16], salt) {
+			break
+		}
+		s0, s1 = walChecksum(order, frame[:8], s0, s1)
+		s0, s1 = walChecksum(order, frame[walFrameHeaderSize:], s0, s1)
+		if s0 != binary.BigEndian.Uint32(frame[16:]) || s1 != binary.BigEndian.Uint32(frame[20:]) {
+			break
+		}
+
+		pending[binary.BigEndian.Uint32(frame)] = offset + walFrameHeaderSize
+		// a commit frame records the size of the database after the transaction
+		if size := binary.BigEndian.Uint32(frame[4:]); size != 0 {
+			for pgno, off := range pending {
+				frames[pgno] = off
+			}
+			pending = make(map[uint32]int64)
+			p.nPages = size
+		}
+	}
+
+	if len(frames) == 0 {
+		wal.Close()
+		return nil
+	}
+	p.wal, p.walFrames = wal, frames
+	return nil
+}
+
+func walChecksum(order binary.ByteOrder, data []byte, s0, s1 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(data); i += 8 {
+		s0 += order.Uint32(data[i:]) + s1
+		s1 += order.Uint32(data[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+func (p *pager) close() error {
+	if p.wal != nil {
+		p.wal.Close()
+	}
+	return p.file.Close()
+}
+
+func (p *pager) page(pgno uint32) ([]byte, error) {
+	if pgno == 0 || pgno > p.nPages {
+		return nil, corruptPage(pgno, "out of range: %d pages", p.nPages)
+	}
+
+	data := make([]byte, p.pageSize)
+	var err error
+	if offset, ok := p.walFrames[pgno]; ok {
+		_, err = p.wal.ReadAt(data, offset)
+	} else {
+		_, err = p.file.ReadAt(data, int64(pgno-1)*int64(p.pageSize))
+	}
+	if xerrors.Is(err, io.EOF) {
+		return nil, corruptPage(pgno, "truncated: %w", err)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read page %d: %w", pgno, err)
+	}
+	return data[:p.usable], nil
+}
+
+// corruptPage returns an error about the content of page pgno
+func corruptPage(pgno uint32, format string, args ...interface{}) error {
+	return &dbi.ErrCorruptPage{PageNo: pgno, Err: xerrors.Errorf(format, args...)}
+}
+
+// btreePage is a page of a b-tree
+type btreePage struct {
+	pgno  uint32
+	data  []byte
+	typ   byte
+	cells []int
+	// right is the right-most child of interior pages
+	right uint32
+}
+
+func (p *pager) btreePage(pgno uint32) (*btreePage, error) {
+	data, err := p.page(pgno)
+	if err != nil {
+		return nil, err
+	}
+
+	// the database header precedes the b-tree page header on the first page
+	offset := 0
+	if pgno == 1 {
+		offset = dbHeaderSize
+	}
+	page := &btreePage{pgno: pgno, data: data, typ: data[offset]}
+
+	headerSize := 8
+	switch page.typ {
+	case interiorIndexPage, interiorTablePage:
+		headerSize = 12
+		page.right = binary.BigEndian.Uint32(data[offset+8:])
+	case leafIndexPage, leafTablePage:
+	default:
+		return nil, corruptPage(pgno, "invalid b-tree page: type %d", page.typ)
+	}
+
+	nCells := int(binary.BigEndian.Uint16(data[offset+3:]))
+	cellPointers := offset + headerSize
+	if cellPointers+2*nCells > len(data) {
+		return nil, corruptPage(pgno, "invalid b-tree page: %d cells", nCells)
+	}
+	page.cells = make([]int, nCells)
+	for i := range page.cells {
+		page.cells[i] = int(binary.BigEndian.Uint16(data[cellPointers+2*i:]))
+		if page.cells[i] < cellPointers+2*nCells || page.cells[i] >= len(data) {
+			return nil, corruptPage(pgno, "invalid b-tree page: cell offset %d", page.cells[i])
+		}
+	}
+	return page, nil
+}
+
+// interiorCell returns the left child and the rowid of a cell of an interior table page
+func (page *btreePage) interiorCell(i int) (uint32, int64, error) {
+	cell := page.data[page.cells[i]:]
+	if len(cell) < 5 {
+		return 0, 0, corruptPage(page.pgno, "invalid b-tree page: short cell %d", i)
+	}
+	rowid, n := readVarint(cell[4:])
+	if n == 0 {
+		return 0, 0, corruptPage(page.pgno, "invalid b-tree page: short cell %d", i)
+	}
+	return binary.BigEndian.Uint32(cell), int64(rowid), nil
+}
+
+// leafCell returns the rowid and the record of a cell of a leaf table page
+func (p *pager) leafCell(page *btreePage, i int) (int64, []byte, error) {
+	cell := page.data[page.cells[i]:]
+	size, n := readVarint(cell)
+	if n == 0 {
+		return 0, nil, corruptPage(page.pgno, "invalid b-tree page: short cell %d", i)
+	}
+	cell = cell[n:]
+	rowid, n := readVarint(cell)
+	if n == 0 {
+		return 0, nil, corruptPage(page.pgno, "invalid b-tree page: short cell %d", i)
+	}
+
+	payload, err := p.payload(page, i, cell[n:], size, p.usable-35)
+	if err != nil {
+		return 0, nil, err
+	}
+	return int64(rowid), payload, nil
+}
+
+// indexCell returns the left child, zero on leaf pages, and the record of a cell of an
+// index page
+func (p *pager) indexCell(page *btreePage, i int) (uint32, []byte, error) {
+	cell := page.data[page.cells[i]:]
+	var child uint32
+	if page.typ == interiorIndexPage {
+		if len(cell) < 4 {
+			return 0, nil, corruptPage(page.pgno, "invalid b-tree page: short cell %d", i)
+		}
+		child, cell = binary.BigEndian.Uint32(cell), cell[4:]
+	}
+	size, n := readVarint(cell)
+	if n == 0 {
+		return 0, nil, corruptPage(page.pgno, "invalid b-tree page: short cell %d", i)
+	}
+
+	payload, err := p.payload(page, i, cell[n:], size, (p.usable-12)*64/255-23)
+	if err != nil {
+		return 0, nil, err
+	}
+	return child, payload, nil
+}
+
+// payload returns the payload of cell i starting at cell, reading its overflow pages
+func (p *pager) payload(page *btreePage, i int, cell []byte, size uint64, maxLocal int) ([]byte, error) {
+	// a corrupted size mustn't make us allocate more than the database
+	if size > uint64(p.nPages)*uint64(p.pageSize) {
+		return nil, corruptPage(page.pgno, "invalid b-tree page: cell %d exceeds the database: %d bytes", i, size)
+	}
+	local := p.localSize(size, maxLocal)
+	if uint64(local) > size || local > len(cell) {
+		return nil, corruptPage(page.pgno, "invalid b-tree page: cell %d exceeds the page", i)
+	}
+	payload := append(make([]byte, 0, size), cell[:local]...)
+	if uint64(local) == size {
+		return payload, nil
+	}
+
+	if local+4 > len(cell) {
+		return nil, corruptPage(page.pgno, "invalid b-tree page: cell %d exceeds the page", i)
+	}
+	payload, err := p.overflow(payload, binary.BigEndian.Uint32(cell[local:]), size)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid overflow of cell %d on page %d: %w", i, page.pgno, err)
+	}
+	return payload, nil
+}
+
+// localSize returns the number of payload bytes stored on the page of a cell, maxLocal
+// depends on the type of the b-tree
+func (p *pager) localSize(size uint64, maxLocal int) int {
+	if size <= uint64(maxLocal) {
+		return int(size)
+	}
+	minLocal := uint64((p.usable-12)*32/255 - 23)
+	local := minLocal + (size-minLocal)%uint64(p.usable-4)
+	if local > uint64(maxLocal) {
+		local = minLocal
+	}
+	return int(local)
+}
+
+// overflow appends the content of a chain of overflow pages to payload up to size
+func (p *pager) overflow(payload []byte, pgno uint32, size uint64) ([]byte, error) {
+	for visited := uint32(0); uint64(len(payload)) < size; visited++ {
+		if pgno == 0 || visited > p.nPages {
+			return nil, xerrors.Errorf("overflow chain ends at %d of %d bytes", len(payload), size)
+		}
+		data, err := p.page(pgno)
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(len(data) - 4)
+		if rest := size - uint64(len(payload)); rest < n {
+			n = rest
+		}
+		payload = append(payload, data[4:4+n]...)
+		pgno = binary.BigEndian.Uint32(data)
+	}
+	return payload, nil
+}
+
+// walkTable calls fn with the rowid and the record of every row of a table in rowid order
+func (p *pager) walkTable(root uint32, fn func(rowid int64, record []byte) error) error {
+	return p.salvageTable(root, fn, func(_ uint32, _ int, _ int64, err error) error {
+		return err
+	})
+}
+
+// salvageTable walks a table like walkTable, but calls skip with the page number, the cell
+// (-1 for whole pages), the file offset and the error of the pages and cells which can't be
+// read. The walk continues with the next cell or page if skip returns nil.
+func (p *pager) salvageTable(root uint32, fn func(rowid int64, record []byte) error,
+	skip func(pgno uint32, cell int, offset int64, err error) error) error {
+	visited := make(map[uint32]struct{})
+	var walk func(pgno uint32, depth int) error
+	walk = func(pgno uint32, depth int) error {
+		if _, ok := visited[pgno]; ok || depth > maxTreeDepth {
+			return skip(pgno, -1, p.fileOffset(pgno, 0), corruptPage(pgno, "cycle in b-tree"))
+		}
+		visited[pgno] = struct{}{}
+
+		page, err := p.btreePage(pgno)
+		if err != nil {
+			return skip(pgno, -1, p.fileOffset(pgno, 0), err)
+		}
+		switch page.typ {
+		case interiorTablePage:
+			for i := range page.cells {
+				child, _, err := page.interiorCell(i)
+				if err != nil {
+					if err = skip(pgno, i, p.fileOffset(pgno, page.cells[i]), err); err != nil {
+						return err
+					}
+					continue
+				}
+				if err = walk(child, depth+1); err != nil {
+					return err
+				}
+			}
+			return walk(page.right, depth+1)
+		case leafTablePage:
+			for i := range page.cells {
+				rowid, record, err := p.leafCell(page, i)
+				if err != nil {
+					if err = skip(pgno, i, p.fileOffset(pgno, page.cells[i]), err); err != nil {
+						return err
+					}
+					continue
+				}
+				if err = fn(rowid, record); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return skip(pgno, -1, p.fileOffset(pgno, 0), corruptPage(pgno, "unexpected page type in table b-tree: %d", page.typ))
+	}
+	return walk(root, 0)
+}
+
+// fileOffset returns the offset of a byte of a page in the database file, -1 if the page is
+// read from the WAL or doesn't exist
+func (p *pager) fileOffset(pgno uint32, offset int) int64 {
+	if _, ok := p.walFrames[pgno]; ok || pgno == 0 || pgno > p.nPages {
+		return -1
+	}
+	return int64(pgno-1)*int64(p.pageSize) + int64(offset)
+}
+
+// findRow returns the record of the row with the rowid, nil if there is none
+func (p *pager) findRow(root uint32, rowid int64) ([]byte, error) {
+	pgno := root
+	for depth := 0; depth <= maxTreeDepth; depth++ {
+		page, err := p.btreePage(pgno)
+		if err != nil {
+			return nil, err
+		}
+		switch page.typ {
+		case interiorTablePage:
+			// the left child of a cell holds the rowids up to the key of the cell
+			next := page.right
+			for i := range page.cells {
+				child, key, err := page.interiorCell(i)
+				if err != nil {
+					return nil, err
+				}
+				if rowid <= key {
+					next = child
+					break
+				}
+			}
+			pgno = next
+		case leafTablePage:
+			for i := range page.cells {
+				id, record, err := p.leafCell(page, i)
+				if err != nil {
+					return nil, err
+				}
+				if id == rowid {
+					return record, nil
+				}
+			}
+			return nil, nil
+		default:
+			return nil, corruptPage(pgno, "unexpected page type in table b-tree: %d", page.typ)
+		}
+	}
+	return nil, xerrors.Errorf("b-tree exceeds depth %d", maxTreeDepth)
+}
+
+// searchIndex calls fn with the records of an index b-tree whose first column equals key,
+// in index order. Unlike table b-trees, the cells of interior pages are entries as well.
+func (p *pager) searchIndex(root uint32, key column, fn func(record []byte) error) error {
+	// search returns true once a greater entry was found
+	var search func(pgno uint32, depth int) (bool, error)
+	search = func(pgno uint32, depth int) (bool, error) {
+		if depth > maxTreeDepth {
+			return false, corruptPage(pgno, "cycle in b-tree")
+		}
+		page, err := p.btreePage(pgno)
+		if err != nil {
+			return false, err
+		}
+		if page.typ != interiorIndexPage && page.typ != leafIndexPage {
+			return false, corruptPage(pgno, "unexpected page type in index b-tree: %d", page.typ)
+		}
+
+		// the first cell not less than key, entries equal to key may precede it in its left child
+		lo, hi := 0, len(page.cells)
+		for lo < hi {
+			mid := (lo + hi) / 2
+			_, record, err := p.indexCell(page, mid)
+			if err != nil {
+				return false, err
+			}
+			c, err := compareFirstColumn(record, key)
+			if err != nil {
+				return false, corruptPage(pgno, "invalid record in cell %d: %w", mid, err)
+			}
+			if c < 0 {
+				lo = mid + 1
+			} else {
+				hi = mid
+			}
+		}
+
+		for i := lo; i < len(page.cells); i++ {
+			child, record, err := p.indexCell(page, i)
+			if err != nil {
+				return false, err
+			}
+			if page.typ == interiorIndexPage {
+				if done, err := search(child, depth+1); done || err != nil {
+					return done, err
+				}
+			}
+			c, err := compareFirstColumn(record, key)
+			if err != nil {
+				return false, corruptPage(pgno, "invalid record in cell %d: %w", i, err)
+			}
+			if c > 0 {
+				return true, nil
+			}
+			if err = fn(record); err != nil {
+				return false, err
+			}
+		}
+		if page.typ == interiorIndexPage {
+			return search(page.right, depth+1)
+		}
+		return false, nil
+	}
+	_, err := search(root, 0)
+	return err
+}
+
+// compareFirstColumn compares the first column of a record with key like SQLite does with
+// the BINARY collation: NULL, numeric values, TEXT and BLOB values sort in this order, text
+// and blobs compare byte-wise. key must be TEXT or BLOB.
+// ref. https://www.sqlite.org/datatype3.html#sort_order
+func compareFirstColumn(record []byte, key column) (int, error) {
+	columns, err := parseRecord(record)
+	if err != nil {
+		return 0, err
+	}
+	if len(columns) == 0 {
+		return 0, xerrors.New("empty record")
+	}
+	if c := columns[0].sortClass() - key.sortClass(); c != 0 {
+		return c, nil
+	}
+	return bytes.Compare(columns[0].data, key.data), nil
+}
+
+// column is a value of a record
+type column struct {
+	// serialType is the type and the size of the value
+	// ref. https://www.sqlite.org/fileformat.html#record_format
+	serialType uint64
+	data       []byte
+}
+
+func (c column) isNull() bool {
+	return c.serialType == 0
+}
+
+func (c column) isText() bool {
+	return c.serialType >= 13 && c.serialType%2 == 1
+}
+
+func (c column) isBlob() bool {
+	return c.serialType >= 12 && c.serialType%2 == 0
+}
+
+// sortClass orders the storage classes of values: NULL, numeric, TEXT then BLOB
+func (c column) sortClass() int {
+	switch {
+	case c.isNull():
+		return 0
+	case c.isText():
+		return 2
+	case c.isBlob():
+		return 3
+	}
+	return 1
+}
+
+// int returns the value of an integer column
+func (c column) int() (int64, error) {
+	switch c.serialType {
+	case 1, 2, 3, 4, 5, 6:
+		// big endian two's complement
+		v := int64(int8(c.data[0]))
+		for _, b := range c.data[1:] {
+			v = v<<8 | int64(b)
+		}
+		return v, nil
+	case 8:
+		return 0, nil
+	case 9:
+		return 1, nil
+	}
+	return 0, xerrors.Errorf("not an integer: serial type %d", c.serialType)
+}
+
+// parseRecord returns the columns of a record
+func parseRecord(record []byte) ([]column, error) {
+	headerSize, n := readVarint(record)
+	if n == 0 || headerSize > uint64(len(record)) || headerSize < uint64(n) {
+		return nil, xerrors.Errorf("invalid record header size: %d", headerSize)
+	}
+
+	header := record[n:headerSize]
+	body := record[headerSize:]
+	var columns []column
+	for len(header) > 0 {
+		serialType, n := readVarint(header)
+		if n == 0 {
+			return nil, xerrors.New("truncated record header")
+		}
+		header = header[n:]
+
+		var size uint64
+		switch {
+		case serialType <= 4:
+			size = serialType
+		case serialType == 5:
+			size = 6
+		case serialType == 6, serialType == 7:
+			size = 8
+		case serialType == 8, serialType == 9:
+			size = 0
+		case serialType >= 12:
+			size = (serialType - 12) / 2
+		default:
+			return nil, xerrors.Errorf("reserved serial type: %d", serialType)
+		}
+		if size > uint64(len(body)) {
+			return nil, xerrors.Errorf("column exceeds record: %d bytes", size)
+		}
+		columns = append(columns, column{serialType: serialType, data: body[:size]})
+		body = body[size:]
+	}
+	return columns, nil
+}
+
+// readVarint returns a variable-length integer and its size, which is 0 if b is too short
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	if len(b) < 9 {
+		return 0, 0
+	}
+	return v<<8 | uint64(b[8]), 9
+}
@@ -0,0 +1,280 @@
+package sqlite3
+
+import (
+	"bytes"
+	"context"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"golang.org/x/xerrors"
+)
+
+// Native reads rpmdb.sqlite with the built-in reader of the SQLite file format, so that
+// no database/sql driver has to be registered. The source files are never modified.
+type Native struct {
+	pager *pager
+	// tables maps the table and index names to their root pages
+	tables map[string]uint32
+}
+
+// OpenNative opens rpmdb.sqlite without database/sql.
+// It returns ErrorInvalidSQLite3 for files of other formats.
+func OpenNative(path string) (*Native, error) {
+	p, err := openPager(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, err := readSchema(p)
+	if err != nil {
+		p.close()
+		return nil, xerrors.Errorf("failed to read schema: %w", err)
+	}
+	if _, ok := tables["Packages"]; !ok {
+		p.close()
+		return nil, xerrors.New("no Packages table")
+	}
+
+	return &Native{
+		pager:  p,
+		tables: tables,
+	}, nil
+}
+
+// readSchema returns the root pages of the tables and indexes in sqlite_master, which is rooted at
+// the first page
+// ref. https://www.sqlite.org/schematab.html
+func readSchema(p *pager) (map[string]uint32, error) {
+	tables := make(map[string]uint32)
+	err := p.walkTable(1, func(_ int64, record []byte) error {
+		// type, name, tbl_name, rootpage, sql
+		columns, err := parseRecord(record)
+		if err != nil {
+			return err
+		}
+		if len(columns) < 4 || string(columns[0].data) != "table" && string(columns[0].data) != "index" {
+			return nil
+		}
+		root, err := columns[3].int()
+		if err != nil {
+			return xerrors.Errorf("invalid root page of %s: %w", columns[1].data, err)
+		}
+		tables[string(columns[1].data)] = uint32(root)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// packageBlob returns the header blob of a row of the Packages table, whose
+// hnum is an alias of the rowid
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.16.0-release/lib/backend/sqlite.c
+func packageBlob(record []byte) ([]byte, error) {
+	columns, err := parseRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) < 2 || !columns[1].isBlob() && !columns[1].isText() {
+		return nil, xerrors.New("no blob column")
+	}
+	return columns[1].data, nil
+}
+
+func (db *Native) Read(ctx context.Context) <-chan dbi.Entry {
+	entries := make(chan dbi.Entry)
+
+	go func() {
+		defer close(entries)
+
+		err := db.pager.walkTable(db.tables["Packages"], func(rowid int64, record []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			blob, err := packageBlob(record)
+			if err != nil {
+				return xerrors.Errorf("invalid package %d: %w", rowid, err)
+			}
+			entries <- dbi.Entry{
+				Value:     blob,
+				HeaderNum: uint32(rowid),
+			}
+			return nil
+		})
+		if err != nil {
+			entries <- dbi.Entry{
+				Err: xerrors.Errorf("failed to read Packages: %w", err),
+			}
+		}
+	}()
+
+	return entries
+}
+
+// Salvage reads the packages like Read, but skips the pages, cells and rows of the Packages
+// table which can't be read
+func (db *Native) Salvage(ctx context.Context) <-chan dbi.Entry {
+	entries := make(chan dbi.Entry)
+
+	go func() {
+		defer close(entries)
+
+		diagnose := func(pgno uint32, cell int, hnum uint32, offset int64, err error) {
+			entries <- dbi.Entry{
+				HeaderNum: hnum,
+				Err: &dbi.Diagnostic{
+					Backend:   "sqlite",
+					PageNo:    pgno,
+					Slot:      cell,
+					HeaderNum: hnum,
+					Offset:    offset,
+					Err:       err,
+				},
+			}
+		}
+
+		err := db.pager.salvageTable(db.tables["Packages"], func(rowid int64, record []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			blob, err := packageBlob(record)
+			if err != nil {
+				diagnose(0, -1, uint32(rowid), -1, xerrors.Errorf("invalid package %d: %w", rowid, err))
+				return nil
+			}
+			entries <- dbi.Entry{
+				Value:     blob,
+				HeaderNum: uint32(rowid),
+			}
+			return nil
+		}, func(pgno uint32, cell int, offset int64, err error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			diagnose(pgno, cell, 0, offset, err)
+			return nil
+		})
+		if err != nil {
+			entries <- dbi.Entry{
+				Err: xerrors.Errorf("failed to read Packages: %w", err),
+			}
+		}
+	}()
+
+	return entries
+}
+
+// ReadIndex returns the items of a key from the index table of the same name. The rows are
+// found through the <index>_key_idx index rpm creates on the key column, tables without it
+// are scanned.
+func (db *Native) ReadIndex(ctx context.Context, index dbi.Index, key []byte) ([]dbi.IndexItem, error) {
+	if index.Tag() == 0 {
+		return nil, xerrors.Errorf("unknown index %q: %w", index, dbi.ErrIndexNotFound)
+	}
+	root, ok := db.tables[string(index)]
+	if !ok {
+		return nil, xerrors.Errorf("no %s table: %w", index, dbi.ErrIndexNotFound)
+	}
+
+	var items []dbi.IndexItem
+	collect := func(rowid int64, record []byte) error {
+		// key, hnum, idx
+		columns, err := parseRecord(record)
+		if err != nil {
+			return xerrors.Errorf("invalid row %d: %w", rowid, err)
+		}
+		if len(columns) < 3 || !bytes.Equal(columns[0].data, key) {
+			return nil
+		}
+		// string keys are stored as TEXT, which never compares equal to a BLOB
+		if columns[0].isBlob() != index.Binary() {
+			return nil
+		}
+		hnum, err := columns[1].int()
+		if err != nil {
+			return xerrors.Errorf("invalid hnum of row %d: %w", rowid, err)
+		}
+		idx, err := columns[2].int()
+		if err != nil {
+			return xerrors.Errorf("invalid idx of row %d: %w", rowid, err)
+		}
+		items = append(items, dbi.IndexItem{HeaderNum: uint32(hnum), TagNum: uint32(idx)})
+		return nil
+	}
+
+	keyIndex, ok := db.tables[string(index)+"_key_idx"]
+	if !ok {
+		err := db.pager.walkTable(root, func(rowid int64, record []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return collect(rowid, record)
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read %s table: %w", index, err)
+		}
+		return items, nil
+	}
+
+	keyColumn := column{serialType: 13 + 2*uint64(len(key)), data: key}
+	if index.Binary() {
+		keyColumn.serialType--
+	}
+	err := db.pager.searchIndex(keyIndex, keyColumn, func(entry []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// key, rowid
+		columns, err := parseRecord(entry)
+		if err != nil {
+			return err
+		}
+		if len(columns) < 2 {
+			return xerrors.New("no rowid in index entry")
+		}
+		rowid, err := columns[len(columns)-1].int()
+		if err != nil {
+			return xerrors.Errorf("invalid rowid in index entry: %w", err)
+		}
+		record, err := db.pager.findRow(root, rowid)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return xerrors.Errorf("no row %d", rowid)
+		}
+		return collect(rowid, record)
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %s_key_idx index: %w", index, err)
+	}
+	return items, nil
+}
+
+// ReadHeader returns the header blob of a row of the Packages table
+func (db *Native) ReadHeader(ctx context.Context, hnum uint32) ([]byte, error) {
+	record, err := db.pager.findRow(db.tables["Packages"], int64(hnum))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read package %d: %w", hnum, err)
+	}
+	if record == nil {
+		return nil, xerrors.Errorf("no package with hnum %d: %w", hnum, dbi.ErrHeaderNotFound)
+	}
+	blob, err := packageBlob(record)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid package %d: %w", hnum, err)
+	}
+	return blob, nil
+}
+
+// Version returns a hash of the file change counter and the WAL header, SQLite changes
+// either of them with every transaction. It's what PRAGMA data_version detects, but
+// across processes.
+func (db *Native) Version() (uint64, error) {
+	return fileVersion(db.pager.file.Name())
+}
+
+// Close closes the database and its WAL
+func (db *Native) Close() error {
+	return db.pager.close()
+}
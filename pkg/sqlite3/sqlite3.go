@@ -5,7 +5,10 @@ import (
 	"context"
 	"database/sql"
 	"encoding/binary"
+	"io"
+	"net/url"
 	"os"
+	"path/filepath"
 
 	dbi "github.com/jfrog/go-rpmdb/pkg/db"
 	"golang.org/x/xerrors"
@@ -13,15 +16,34 @@ import (
 
 type SQLite3 struct {
 	*sql.DB
+
+	// tempDir holds a private copy of the database, if its WAL or journal had to be applied
+	tempDir string
+	// path is the source database, see Version
+	path string
 }
 
 var (
 	// https://www.sqlite.org/fileformat.html
 	SQLite3_HeaderMagic = []byte("SQLite format 3\x00")
-	ErrorInvalidSQLite3 = xerrors.Errorf("invalid or unsupported SQLite3 format")
+	ErrorInvalidSQLite3 = xerrors.Errorf("invalid or unsupported SQLite3 format: %w", dbi.ErrNotRPMDB)
+	// ErrorWALNotApplied is returned when the WAL or the rollback journal next to the
+	// database can't be applied without modifying the source files
+	ErrorWALNotApplied = xerrors.New("failed to apply the SQLite WAL read-only")
 )
 
+// the files SQLite keeps next to the database with changes which aren't in the database yet
+// ref. https://www.sqlite.org/tempfiles.html
+var pendingSuffixes = []string{"-wal", "-journal"}
+
+// Open opens rpmdb.sqlite through database/sql with the "sqlite" driver, which has to be
+// registered, e.g. by importing github.com/glebarez/go-sqlite
 func Open(path string) (*SQLite3, error) {
+	return OpenDriver(path, "sqlite")
+}
+
+// OpenDriver opens rpmdb.sqlite through database/sql with the named driver
+func OpenDriver(path, driverName string) (*SQLite3, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -30,6 +52,9 @@ func Open(path string) (*SQLite3, error) {
 
 	b := make([]byte, 16)
 	if err = binary.Read(file, binary.LittleEndian, b); err != nil {
+		if xerrors.Is(err, io.EOF) || xerrors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrorInvalidSQLite3
+		}
 		return nil, xerrors.Errorf("binary read error: %w", err)
 	}
 
@@ -37,12 +62,129 @@ func Open(path string) (*SQLite3, error) {
 		return nil, ErrorInvalidSQLite3
 	}
 
-	db, err := sql.Open("sqlite", path)
+	pending, err := hasPendingChanges(path)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		db, err := openCopy(path, driverName)
+		if err != nil {
+			return nil, err
+		}
+		db.path = path
+		return db, nil
+	}
+
+	// an immutable database is read without locking and without creating -shm or -journal files
+	// ref. https://www.sqlite.org/uri.html#uriimmutable
+	db, err := sql.Open(driverName, uri(path, "mode=ro&immutable=1"))
 	if err != nil {
 		return nil, xerrors.Errorf("failed to open sqlite3: %w", err)
 	}
 
-	return &SQLite3{db}, nil
+	return &SQLite3{DB: db, path: path}, nil
+}
+
+// hasPendingChanges reports whether there is a non-empty WAL or rollback journal next to path
+func hasPendingChanges(path string) (bool, error) {
+	for _, suffix := range pendingSuffixes {
+		info, err := os.Stat(path + suffix)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return false, xerrors.Errorf("failed to stat %s: %w", path+suffix, err)
+		}
+		if info.Size() > 0 || !info.Mode().IsRegular() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// openCopy copies the database with its WAL or journal to a temporary directory and
+// lets SQLite apply them there, so that the source files are never modified
+func openCopy(path, driverName string) (*SQLite3, error) {
+	tempDir, err := os.MkdirTemp("", "rpmdb-sqlite")
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	db, err := applyPendingChanges(path, filepath.Join(tempDir, filepath.Base(path)), driverName)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return nil, xerrors.Errorf("%s: %v: %w", path, err, ErrorWALNotApplied)
+	}
+	return &SQLite3{DB: db, tempDir: tempDir}, nil
+}
+
+func applyPendingChanges(path, copyPath, driverName string) (*sql.DB, error) {
+	if err := copyFile(path, copyPath); err != nil {
+		return nil, err
+	}
+	for _, suffix := range pendingSuffixes {
+		if _, err := os.Lstat(path + suffix); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyFile(path+suffix, copyPath+suffix); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := sql.Open(driverName, uri(copyPath, "mode=rw"))
+	if err != nil {
+		return nil, err
+	}
+	// the WAL and the journal are applied by the first read
+	var count int
+	if err = db.QueryRow("SELECT count(*) FROM sqlite_master").Scan(&count); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return xerrors.Errorf("failed to copy %s: %w", src, err)
+	}
+	return out.Close()
+}
+
+// uri returns the SQLite URI filename of path with the query parameters
+// ref. https://www.sqlite.org/uri.html
+func uri(path, query string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path), RawQuery: query}
+	return u.String()
+}
+
+// Version returns the version of the source database, see Native.Version
+func (db *SQLite3) Version() (uint64, error) {
+	return fileVersion(db.path)
+}
+
+// Close closes the database and removes the copy of it, if any
+func (db *SQLite3) Close() error {
+	err := db.DB.Close()
+	if db.tempDir != "" {
+		if rerr := os.RemoveAll(db.tempDir); err == nil {
+			err = rerr
+		}
+	}
+	return err
 }
 
 func (db *SQLite3) Read(ctx context.Context) <-chan dbi.Entry {
@@ -51,39 +193,137 @@ func (db *SQLite3) Read(ctx context.Context) <-chan dbi.Entry {
 	go func() {
 		defer close(entries)
 
-		rows, err := db.QueryContext(ctx, "SELECT blob FROM Packages")
+		rows, err := db.QueryContext(ctx, "SELECT hnum, blob FROM Packages")
 		if err != nil {
 			entries <- dbi.Entry{
 				Err: xerrors.Errorf("failed to SELECT query: %w", err),
 			}
+			return
 		}
-		if err := db.Close(); err != nil {
+		defer rows.Close()
+
+		for rows.Next() {
+			var hnum uint32
+			var blob string
+			if err := rows.Scan(&hnum, &blob); err != nil {
+				entries <- dbi.Entry{
+					Err: xerrors.Errorf("failed to Scan Row: %w", err),
+				}
+				continue
+			}
+
 			entries <- dbi.Entry{
-				Err: xerrors.Errorf("failed to close DB: %w", err),
+				Value:     []byte(blob),
+				HeaderNum: hnum,
+				Err:       nil,
 			}
 		}
+		if err := rows.Err(); err != nil {
+			entries <- dbi.Entry{
+				Err: xerrors.Errorf("failed to iterate rows: %w", err),
+			}
+		}
+	}()
+
+	return entries
+}
+
+// Salvage reads the packages like Read, but skips the rows which can't be read
+func (db *SQLite3) Salvage(ctx context.Context) <-chan dbi.Entry {
+	entries := make(chan dbi.Entry)
+
+	go func() {
+		defer close(entries)
 
-		if rows == nil {
+		rows, err := db.QueryContext(ctx, "SELECT hnum, blob FROM Packages")
+		if err != nil {
 			entries <- dbi.Entry{
-				Err: xerrors.Errorf("query failed to return rows: %w", err),
+				Err: xerrors.Errorf("failed to SELECT query: %w", err),
 			}
 			return
 		}
+		defer rows.Close()
 
-		for rows.Next() {
-			var blob string
-			if err := rows.Scan(&blob); err != nil {
+		for row := 0; rows.Next(); row++ {
+			var hnum sql.NullInt64
+			var blob []byte
+			if err := rows.Scan(&hnum, &blob); err != nil {
 				entries <- dbi.Entry{
-					Err: xerrors.Errorf("failed to Scan Row: %w", err),
+					HeaderNum: uint32(hnum.Int64),
+					Err: &dbi.Diagnostic{
+						Backend:   "sqlite",
+						Slot:      row,
+						HeaderNum: uint32(hnum.Int64),
+						Offset:    -1,
+						Err:       xerrors.Errorf("failed to Scan Row: %w", err),
+					},
 				}
+				continue
 			}
 
 			entries <- dbi.Entry{
-				Value: []byte(blob),
-				Err:   nil,
+				Value:     blob,
+				HeaderNum: uint32(hnum.Int64),
+			}
+		}
+		if err := rows.Err(); err != nil {
+			entries <- dbi.Entry{
+				Err: xerrors.Errorf("failed to iterate rows: %w", err),
 			}
 		}
 	}()
 
 	return entries
 }
+
+// ReadIndex returns the items of a key from the index table of the same name
+func (db *SQLite3) ReadIndex(ctx context.Context, index dbi.Index, key []byte) ([]dbi.IndexItem, error) {
+	// the table name can't be a query parameter, only accept known indexes
+	if index.Tag() == 0 {
+		return nil, xerrors.Errorf("unknown index %q: %w", index, dbi.ErrIndexNotFound)
+	}
+
+	var name string
+	err := db.QueryRowContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", string(index)).Scan(&name)
+	if xerrors.Is(err, sql.ErrNoRows) {
+		return nil, xerrors.Errorf("no %s table: %w", index, dbi.ErrIndexNotFound)
+	} else if err != nil {
+		return nil, xerrors.Errorf("failed to look up %s table: %w", index, err)
+	}
+
+	// string keys are stored as TEXT, which never compares equal to a BLOB
+	var arg interface{} = string(key)
+	if index.Binary() {
+		arg = key
+	}
+	rows, err := db.QueryContext(ctx, "SELECT hnum, idx FROM '"+name+"' WHERE key = ?", arg)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to query %s table: %w", index, err)
+	}
+	defer rows.Close()
+
+	var items []dbi.IndexItem
+	for rows.Next() {
+		var item dbi.IndexItem
+		if err := rows.Scan(&item.HeaderNum, &item.TagNum); err != nil {
+			return nil, xerrors.Errorf("failed to scan %s row: %w", index, err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to iterate %s rows: %w", index, err)
+	}
+	return items, nil
+}
+
+// ReadHeader returns the header blob of a row of the Packages table
+func (db *SQLite3) ReadHeader(ctx context.Context, hnum uint32) ([]byte, error) {
+	var blob []byte
+	err := db.QueryRowContext(ctx, "SELECT blob FROM Packages WHERE hnum = ?", hnum).Scan(&blob)
+	if xerrors.Is(err, sql.ErrNoRows) {
+		return nil, xerrors.Errorf("no package with hnum %d: %w", hnum, dbi.ErrHeaderNotFound)
+	} else if err != nil {
+		return nil, xerrors.Errorf("failed to SELECT package %d: %w", hnum, err)
+	}
+	return blob, nil
+}
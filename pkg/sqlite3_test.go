@@ -0,0 +1,260 @@
+package rpmdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"github.com/jfrog/go-rpmdb/pkg/sqlite3"
+)
+
+// snapshotDir returns the content of the files in dir
+func snapshotDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			files[entry.Name()] = "dir"
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		require.NoError(t, err)
+		files[entry.Name()] = string(data)
+	}
+	return files
+}
+
+// createSQLite3WAL writes an rpmdb.sqlite whose packages are only committed to its WAL
+func createSQLite3WAL(t *testing.T, blobs ...[]byte) string {
+	t.Helper()
+
+	src := filepath.Join(t.TempDir(), "rpmdb.sqlite")
+	db, err := sql.Open("sqlite", src)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, query := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA wal_autocheckpoint=0",
+		"CREATE TABLE IF NOT EXISTS 'Packages' (hnum INTEGER PRIMARY KEY AUTOINCREMENT, blob BLOB NOT NULL)",
+	} {
+		_, err = db.Exec(query)
+		require.NoError(t, err)
+	}
+	for _, blob := range blobs {
+		_, err = db.Exec("INSERT INTO Packages (blob) VALUES (?)", blob)
+		require.NoError(t, err)
+	}
+
+	// copy the files while the database is open, closing it would checkpoint the WAL
+	dir := t.TempDir()
+	for _, name := range []string{"rpmdb.sqlite", "rpmdb.sqlite-wal"} {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(src), name))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0444))
+	}
+	return filepath.Join(dir, "rpmdb.sqlite")
+}
+
+func TestSQLite3ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/cbl-mariner-2.0/rpmdb.sqlite")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "rpmdb.sqlite"), data, 0444))
+
+	walPath := createSQLite3WAL(t,
+		testIndexedHeader("bash", "5.2", 1000, "bash"),
+		testIndexedHeader("kernel", "6.1", 1000, "kernel"),
+	)
+
+	tests := []struct {
+		name      string
+		path      string
+		wantCount int
+	}{
+		{
+			name:      "immutable",
+			path:      filepath.Join(dir, "rpmdb.sqlite"),
+			wantCount: 129,
+		},
+		{
+			name:      "committed to WAL",
+			path:      walPath,
+			wantCount: 2,
+		},
+	}
+	for _, tt := range tests {
+		for _, driver := range []string{"", "sqlite"} {
+			t.Run(tt.name+"/"+driver, func(t *testing.T) {
+				before := snapshotDir(t, filepath.Dir(tt.path))
+
+				db, err := Open(tt.path, WithSQLDriver(driver))
+				require.NoError(t, err)
+				pkgs, err := db.ListPackages()
+				require.NoError(t, err)
+				assert.Len(t, pkgs, tt.wantCount)
+
+				_, err = db.PackagesByName(context.Background(), pkgs[0].Name)
+				require.NoError(t, err)
+				require.NoError(t, db.Close())
+
+				// no -shm or -journal files and no checkpoint
+				assert.Equal(t, before, snapshotDir(t, filepath.Dir(tt.path)))
+			})
+		}
+	}
+}
+
+func TestSQLite3WALNotApplied(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/cbl-mariner-2.0/rpmdb.sqlite")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "rpmdb.sqlite"), data, 0444))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "rpmdb.sqlite-wal"), 0755))
+
+	for _, driver := range []string{"", "sqlite"} {
+		_, err = Open(filepath.Join(dir, "rpmdb.sqlite"), WithSQLDriver(driver))
+		require.Error(t, err)
+		assert.True(t, xerrors.Is(err, sqlite3.ErrorWALNotApplied), err.Error())
+	}
+}
+
+func TestSQLite3Native(t *testing.T) {
+	// enough rows for interior pages and headers spanning overflow pages
+	var headers [][]byte
+	for i := 0; i < 300; i++ {
+		provides := []string{fmt.Sprintf("pkg%d", i)}
+		for j := 0; j < i%40*10; j++ {
+			provides = append(provides, fmt.Sprintf("pkg%d-capability-%d", i, j))
+		}
+		headers = append(headers, testIndexedHeader(fmt.Sprintf("pkg%d", i), "1.0", 1000, provides...))
+	}
+	synthetic := createSQLite3DB(t, headers...)
+	addSQLite3Index(t, synthetic, IndexName,
+		testIndexRow{key: "pkg7", hnum: 8},
+		testIndexRow{key: "pkg250", hnum: 251},
+	)
+
+	tests := []struct {
+		name string
+		file string
+		// lookups maps names to the expected instances
+		lookups map[string][]uint32
+	}{
+		{
+			name:    "CBL-Mariner 2.0",
+			file:    "testdata/cbl-mariner-2.0/rpmdb.sqlite",
+			lookups: map[string][]uint32{"bash": {29}, "curl": {48}, "missing": nil},
+		},
+		{
+			name:    "synthetic",
+			file:    synthetic,
+			lookups: map[string][]uint32{"pkg7": {8}, "pkg250": {251}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			native, err := Open(tt.file)
+			require.NoError(t, err)
+			defer native.Close()
+			require.IsType(t, &sqlite3.Native{}, native.db)
+
+			driver, err := Open(tt.file, WithSQLDriver("sqlite"))
+			require.NoError(t, err)
+			defer driver.Close()
+			require.IsType(t, &sqlite3.SQLite3{}, driver.db)
+
+			want, err := driver.ListPackages()
+			require.NoError(t, err)
+			got, err := native.ListPackages()
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+
+			ctx := context.Background()
+			for _, pkg := range want {
+				byInstance, err := native.PackageByInstance(ctx, pkg.DBInstance)
+				require.NoError(t, err)
+				assert.Equal(t, pkg, byInstance)
+			}
+			_, err = native.PackageByInstance(ctx, 100000)
+			assert.True(t, xerrors.Is(err, dbi.ErrHeaderNotFound))
+
+			for name, wantInstances := range tt.lookups {
+				items, err := native.db.(dbi.IndexReader).ReadIndex(ctx, IndexName, []byte(name))
+				require.NoError(t, err)
+				var instances []uint32
+				for _, item := range items {
+					instances = append(instances, item.HeaderNum)
+				}
+				assert.Equal(t, wantInstances, instances, name)
+			}
+
+			// every key of every index, found through the key indexes or by scanning
+			// the tables without one
+			for _, index := range []Index{IndexName, IndexProvidename, IndexRequirename, IndexBasenames,
+				IndexDirnames, IndexSha1header, IndexSigmd5, IndexInstalltid} {
+				keys := [][]byte{[]byte("missing")}
+				rows, err := driver.db.(*sqlite3.SQLite3).QueryContext(ctx, "SELECT DISTINCT key FROM '"+string(index)+"'")
+				if err == nil {
+					for rows.Next() {
+						var key []byte
+						require.NoError(t, rows.Scan(&key))
+						keys = append(keys, key)
+					}
+					require.NoError(t, rows.Err())
+					rows.Close()
+				}
+
+				for _, key := range keys {
+					wantItems, wantErr := driver.db.(dbi.IndexReader).ReadIndex(ctx, index, key)
+					gotItems, gotErr := native.db.(dbi.IndexReader).ReadIndex(ctx, index, key)
+					if wantErr != nil {
+						assert.True(t, xerrors.Is(wantErr, dbi.ErrIndexNotFound), wantErr.Error())
+						assert.True(t, xerrors.Is(gotErr, dbi.ErrIndexNotFound), "%s: %v", index, gotErr)
+						continue
+					}
+					require.NoError(t, gotErr, "%s %q", index, key)
+					assert.Equal(t, wantItems, gotItems, "%s %q", index, key)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLite3DriverScanError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpmdb.sqlite")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	// a table without the column types of rpm accepts an hnum which can't be scanned
+	_, err = db.Exec("CREATE TABLE 'Packages' (hnum, blob)")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO Packages (hnum, blob) VALUES ('one', ?), (2, ?)",
+		testIndexedHeader("bash", "5.2", 1000), testIndexedHeader("zsh", "5.9", 1000))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	driver, err := sqlite3.OpenDriver(path, "sqlite")
+	require.NoError(t, err)
+	defer driver.Close()
+
+	var entries []dbi.Entry
+	for entry := range driver.Read(context.Background()) {
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 2)
+	assert.Error(t, entries[0].Err)
+	require.NoError(t, entries[1].Err)
+	assert.Equal(t, uint32(2), entries[1].HeaderNum)
+}
@@ -0,0 +1,13 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package rpmdb
+
+import "io/fs"
+
+func fileOwner(fi fs.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
+
+func fileRdev(fi fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
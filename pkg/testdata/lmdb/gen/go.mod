@@ -0,0 +1,12 @@
+module github.com/jfrog/go-rpmdb/pkg/testdata/lmdb/gen
+
+go 1.18
+
+replace github.com/jfrog/go-rpmdb => ../../../..
+
+require (
+	github.com/bmatsuo/lmdb-go v1.8.0
+	github.com/jfrog/go-rpmdb v0.0.0-00010101000000-000000000000
+)
+
+require golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
@@ -0,0 +1,155 @@
+// Command gen writes the LMDB test fixtures with liblmdb.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+	dbi "github.com/jfrog/go-rpmdb/pkg/db"
+	"github.com/jfrog/go-rpmdb/pkg/ndb"
+)
+
+// header encodes an rpm header with an immutable region like rpm writes it
+func header(name string, provides []string) []byte {
+	type entry struct {
+		tag, typ, count uint32
+		data            []byte
+	}
+	str := func(s string) []byte { return append([]byte(s), 0) }
+	var prov []byte
+	for _, p := range provides {
+		prov = append(prov, str(p)...)
+	}
+	tid := make([]byte, 4)
+	binary.BigEndian.PutUint32(tid, 1000)
+	entries := []entry{
+		{1000, 6, 1, str(name)},
+		{1001, 6, 1, str("1.0")},
+		{1002, 6, 1, str("1")},
+		{1047, 8, uint32(len(provides)), prov},
+		{1128, 4, 1, tid},
+	}
+
+	var data []byte
+	var infos []byte
+	put := func(tag, typ uint32, offset int32, count uint32) {
+		b := make([]byte, 16)
+		binary.BigEndian.PutUint32(b, tag)
+		binary.BigEndian.PutUint32(b[4:], typ)
+		binary.BigEndian.PutUint32(b[8:], uint32(offset))
+		binary.BigEndian.PutUint32(b[12:], count)
+		infos = append(infos, b...)
+	}
+	for _, e := range entries {
+		if e.typ == 4 {
+			for len(data)%4 != 0 {
+				data = append(data, 0)
+			}
+		}
+		put(e.tag, e.typ, int32(len(data)), e.count)
+		data = append(data, e.data...)
+	}
+	il := len(entries) + 1
+	regionInfos := infos
+	infos = nil
+	put(63, 7, int32(len(data)), 16)
+	trailerInfo := append([]byte{}, infos...)
+	infos = nil
+	put(63, 7, int32(-il*16), 16)
+	data = append(data, infos...)
+	infos = append(trailerInfo, regionInfos...)
+
+	blob := make([]byte, 8)
+	binary.BigEndian.PutUint32(blob, uint32(il))
+	binary.BigEndian.PutUint32(blob[4:], uint32(len(data)))
+	return append(append(blob, infos...), data...)
+}
+
+// gen writes the LMDB fixtures with liblmdb, which needs cgo:
+//
+//	go run . -ndb ../../sle15-bci/Packages.db <dir>   # testdata/lmdb/data.mdb
+//	go run . -n 400 -integerkey <dir>                 # testdata/lmdb-integerkey/data.mdb
+//
+// The headers of an NDB Packages.db, or n generated headers, are put to the Packages
+// database of the environment in dir like rpm's lmdb backend does.
+func main() {
+	src := flag.String("ndb", "", "NDB Packages.db to convert")
+	n := flag.Int("n", 0, "number of generated headers")
+	integerKey := flag.Bool("integerkey", false, "create Packages with MDB_INTEGERKEY")
+	flag.Parse()
+	dir := flag.Arg(0)
+
+	var entries []dbi.Entry
+	if *src != "" {
+		db, err := ndb.Open(*src)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for entry := range db.Read(context.Background()) {
+			if entry.Err != nil {
+				log.Fatal(entry.Err)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	for i := 1; i <= *n; i++ {
+		provides := []string{fmt.Sprintf("pkg%d", i)}
+		// some of the headers exceed half a page and go to overflow pages
+		for j := 0; i%50 == 0 && j < 300; j++ {
+			provides = append(provides, fmt.Sprintf("pkg%d-capability-%d", i, j))
+		}
+		entries = append(entries, dbi.Entry{Value: header(fmt.Sprintf("pkg%d", i), provides), HeaderNum: uint32(i)})
+	}
+
+	env, err := lmdb.NewEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer env.Close()
+	env.SetMaxDBs(32)
+	env.SetMapSize(64 << 20)
+	if err := env.Open(dir, 0, 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	key := func(n uint32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, n)
+		return b
+	}
+	flags := uint(lmdb.Create)
+	if *integerKey {
+		flags |= 0x08 // MDB_INTEGERKEY
+	}
+	err = env.Update(func(txn *lmdb.Txn) error {
+		packages, err := txn.OpenDBI("Packages", flags)
+		if err != nil {
+			return err
+		}
+		var max uint32
+		for _, entry := range entries {
+			if err := txn.Put(packages, key(entry.HeaderNum), entry.Value, 0); err != nil {
+				return err
+			}
+			if entry.HeaderNum > max {
+				max = entry.HeaderNum
+			}
+		}
+		if err := txn.Put(packages, key(0), key(max+1), 0); err != nil {
+			return err
+		}
+		stat, err := txn.Stat(packages)
+		if err != nil {
+			return err
+		}
+		log.Printf("%+v", *stat)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,153 @@
+package rpmdb
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// DefaultUnownedExcludes are the paths skipped by FindUnownedFiles when no excludes are given.
+// They hold virtual file systems, runtime state and caches which are never owned by packages.
+var DefaultUnownedExcludes = []string{
+	"/dev",
+	"/proc",
+	"/run",
+	"/sys",
+	"/tmp",
+	"/var/cache",
+	"/var/lib/rpm",
+	"/var/log",
+	"/var/run",
+	"/var/tmp",
+}
+
+// UnownedFilesOptions configures FindUnownedFiles
+type UnownedFilesOptions struct {
+	// Excludes are absolute paths which are skipped including everything below them.
+	// DefaultUnownedExcludes is used if nil.
+	Excludes []string
+}
+
+// UnownedFile is a file of the root file system which isn't owned by any package
+type UnownedFile struct {
+	Path string
+	Mode fs.FileMode
+	// Size is the size of regular files, 0 otherwise
+	Size int64
+}
+
+// UnownedDir aggregates the unowned files directly within a directory
+type UnownedDir struct {
+	Path  string
+	Files int
+	Size  int64
+}
+
+// UnownedReport is the result of FindUnownedFiles
+type UnownedReport struct {
+	// Files are the unowned regular files, symbolic links and directories in lexical order
+	Files []UnownedFile
+	// Dirs are the directories containing unowned files, sorted by path
+	Dirs []UnownedDir
+
+	TotalFiles int
+	TotalSize  int64
+}
+
+// FindUnownedFiles walks root and reports every regular file, symbolic link and directory
+// which isn't owned by a package of the index. Symbolic links are not followed.
+func FindUnownedFiles(root fs.FS, idx *PathIndex, opts UnownedFilesOptions) (*UnownedReport, error) {
+	if opts.Excludes == nil {
+		opts.Excludes = DefaultUnownedExcludes
+	}
+	excludes := make([]string, len(opts.Excludes))
+	for i, exclude := range opts.Excludes {
+		excludes[i] = path.Clean("/" + exclude)
+	}
+
+	report := &UnownedReport{}
+	dirs := make(map[string]*UnownedDir)
+
+	err := fs.WalkDir(root, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+
+		filePath := "/" + name
+		if isExcluded(filePath, excludes) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		mode := d.Type()
+		if !mode.IsRegular() && !mode.IsDir() && mode&fs.ModeSymlink == 0 {
+			return nil
+		}
+		if len(idx.WhoOwns(filePath)) > 0 {
+			return nil
+		}
+
+		file := UnownedFile{Path: filePath, Mode: mode}
+		if mode.IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return xerrors.Errorf("failed to stat %s: %w", filePath, err)
+			}
+			file.Mode = info.Mode()
+			file.Size = info.Size()
+		}
+		report.Files = append(report.Files, file)
+		report.TotalFiles++
+		report.TotalSize += file.Size
+
+		parent := path.Dir(filePath)
+		dir, ok := dirs[parent]
+		if !ok {
+			dir = &UnownedDir{Path: parent}
+			dirs[parent] = dir
+		}
+		dir.Files++
+		dir.Size += file.Size
+
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to walk root file system: %w", err)
+	}
+
+	for _, dir := range dirs {
+		report.Dirs = append(report.Dirs, *dir)
+	}
+	sort.Slice(report.Dirs, func(i, j int) bool {
+		return report.Dirs[i].Path < report.Dirs[j].Path
+	})
+
+	return report, nil
+}
+
+func isExcluded(filePath string, excludes []string) bool {
+	for _, exclude := range excludes {
+		if exclude == "/" || filePath == exclude || strings.HasPrefix(filePath, exclude+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// UnownedFilesWithContext reports the files of root which aren't owned by any installed package
+func (d *RpmDB) UnownedFilesWithContext(ctx context.Context, root fs.FS, opts UnownedFilesOptions) (*UnownedReport, error) {
+	idx, err := d.PathIndexWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return FindUnownedFiles(root, idx, opts)
+}
@@ -0,0 +1,85 @@
+package rpmdb
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindUnownedFiles(t *testing.T) {
+	root := fstest.MapFS{
+		"usr/bin/bash":             {Data: make([]byte, 100), Mode: 0o755},
+		"usr/local/bin/installer":  {Data: make([]byte, 40), Mode: 0o755},
+		"usr/local/bin/helper":     {Data: make([]byte, 2), Mode: 0o755},
+		"usr/local/bin/hl":         {Data: []byte("helper"), Mode: fs.ModeSymlink},
+		"opt/tool/bin/tool":        {Data: make([]byte, 7), Mode: 0o755},
+		"etc/bashrc":               {Data: make([]byte, 3), Mode: 0o644},
+		"tmp/build/artifact":       {Data: make([]byte, 1000), Mode: 0o644},
+		"var/cache/dnf/repomd.xml": {Data: make([]byte, 1000), Mode: 0o644},
+	}
+
+	idx, err := NewPathIndex([]*PackageInfo{
+		testPackageFiles("filesystem", map[string]string{
+			"/usr":           "",
+			"/usr/bin":       "",
+			"/usr/local":     "",
+			"/usr/local/bin": "",
+			"/etc":           "",
+			"/opt":           "",
+			"/tmp":           "",
+			"/var":           "",
+		}),
+		testPackageFiles("bash", map[string]string{
+			"/usr/bin/bash": "",
+			"/etc/bashrc":   "",
+		}),
+	})
+	require.NoError(t, err)
+
+	t.Run("default excludes", func(t *testing.T) {
+		report, err := FindUnownedFiles(root, idx, UnownedFilesOptions{})
+		require.NoError(t, err)
+
+		var paths []string
+		for _, f := range report.Files {
+			paths = append(paths, f.Path)
+		}
+		assert.Equal(t, []string{
+			"/opt/tool",
+			"/opt/tool/bin",
+			"/opt/tool/bin/tool",
+			"/usr/local/bin/helper",
+			"/usr/local/bin/hl",
+			"/usr/local/bin/installer",
+		}, paths)
+		assert.Equal(t, []UnownedDir{
+			{Path: "/opt", Files: 1},
+			{Path: "/opt/tool", Files: 1},
+			{Path: "/opt/tool/bin", Files: 1, Size: 7},
+			{Path: "/usr/local/bin", Files: 3, Size: 42},
+		}, report.Dirs)
+		assert.Equal(t, 6, report.TotalFiles)
+		assert.Equal(t, int64(49), report.TotalSize)
+	})
+
+	t.Run("custom excludes", func(t *testing.T) {
+		report, err := FindUnownedFiles(root, idx, UnownedFilesOptions{Excludes: []string{"opt", "/usr/local"}})
+		require.NoError(t, err)
+
+		var paths []string
+		for _, f := range report.Files {
+			paths = append(paths, f.Path)
+		}
+		assert.Equal(t, []string{
+			"/tmp/build",
+			"/tmp/build/artifact",
+			"/var/cache",
+			"/var/cache/dnf",
+			"/var/cache/dnf/repomd.xml",
+		}, paths)
+		assert.Equal(t, int64(2000), report.TotalSize)
+	})
+}
@@ -0,0 +1,371 @@
+package rpmdb
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// VerifyAttrs is a set of file attributes checked by VerifyPackage
+type VerifyAttrs uint32
+
+// source: https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/rpmvf.h
+const (
+	RPMVERIFY_NONE       VerifyAttrs = 0
+	RPMVERIFY_FILEDIGEST VerifyAttrs = 1 << 0  /*!< from %verify(filedigest) */
+	RPMVERIFY_FILESIZE   VerifyAttrs = 1 << 1  /*!< from %verify(size) */
+	RPMVERIFY_LINKTO     VerifyAttrs = 1 << 2  /*!< from %verify(link) */
+	RPMVERIFY_USER       VerifyAttrs = 1 << 3  /*!< from %verify(user) */
+	RPMVERIFY_GROUP      VerifyAttrs = 1 << 4  /*!< from %verify(group) */
+	RPMVERIFY_MTIME      VerifyAttrs = 1 << 5  /*!< from %verify(mtime) */
+	RPMVERIFY_MODE       VerifyAttrs = 1 << 6  /*!< from %verify(mode) */
+	RPMVERIFY_RDEV       VerifyAttrs = 1 << 7  /*!< from %verify(rdev) */
+	RPMVERIFY_CAPS       VerifyAttrs = 1 << 8  /*!< from %verify(caps) */
+	RPMVERIFY_LSTATFAIL  VerifyAttrs = 1 << 30 /*!< lstat failed */
+
+	RPMVERIFY_ALL = RPMVERIFY_FILEDIGEST | RPMVERIFY_FILESIZE | RPMVERIFY_LINKTO | RPMVERIFY_USER |
+		RPMVERIFY_GROUP | RPMVERIFY_MTIME | RPMVERIFY_MODE | RPMVERIFY_RDEV | RPMVERIFY_CAPS
+)
+
+// the columns of rpm -V output
+var verifyFormatOrder = []struct {
+	attr VerifyAttrs
+	char byte
+}{
+	{RPMVERIFY_FILESIZE, 'S'},
+	{RPMVERIFY_MODE, 'M'},
+	{RPMVERIFY_FILEDIGEST, '5'},
+	{RPMVERIFY_RDEV, 'D'},
+	{RPMVERIFY_LINKTO, 'L'},
+	{RPMVERIFY_USER, 'U'},
+	{RPMVERIFY_GROUP, 'G'},
+	{RPMVERIFY_MTIME, 'T'},
+	{RPMVERIFY_CAPS, 'P'},
+}
+
+// FileVerifyResult is the result of verifying an installed file against the package header
+type FileVerifyResult struct {
+	Path  string
+	Flags FileFlags
+	// Failed holds the attributes which differ from the header, or RPMVERIFY_LSTATFAIL if the file is missing
+	Failed VerifyAttrs
+	// Unverified holds the attributes which could not be checked
+	Unverified VerifyAttrs
+	// Err is the error which prevented a check, e.g. the file could not be read
+	Err error
+}
+
+// Missing reports whether the file does not exist
+func (r FileVerifyResult) Missing() bool {
+	return r.Failed&RPMVERIFY_LSTATFAIL != 0
+}
+
+// ConfigModified reports whether the content of a %config file differs from the packaged one
+func (r FileVerifyResult) ConfigModified() bool {
+	return int32(r.Flags)&RPMFILE_CONFIG != 0 && r.Failed&(RPMVERIFY_FILEDIGEST|RPMVERIFY_FILESIZE) != 0
+}
+
+// OK reports whether the file matches the header. Missing %ghost and %config(missingok) files
+// and content changes of %config(noreplace) files are expected and not reported as failures.
+func (r FileVerifyResult) OK() bool {
+	if r.Err != nil {
+		return false
+	}
+	if r.Missing() {
+		return int32(r.Flags)&(RPMFILE_MISSINGOK|RPMFILE_GHOST) != 0
+	}
+	failed := r.Failed
+	if int32(r.Flags)&RPMFILE_NOREPLACE != 0 {
+		failed &^= RPMVERIFY_FILEDIGEST | RPMVERIFY_FILESIZE | RPMVERIFY_MTIME
+	}
+	return failed == 0
+}
+
+// String formats the result like rpm -V, e.g. "S.5....T.  c /etc/hosts"
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/verify.c
+func (r FileVerifyResult) String() string {
+	if r.Missing() {
+		return fmt.Sprintf("missing   %c %s", r.attrFormat(), r.Path)
+	}
+
+	var b strings.Builder
+	for _, f := range verifyFormatOrder {
+		switch {
+		case r.Failed&f.attr != 0:
+			b.WriteByte(f.char)
+		case r.Unverified&f.attr != 0:
+			b.WriteByte('?')
+		default:
+			b.WriteByte('.')
+		}
+	}
+	return fmt.Sprintf("%s  %c %s", b.String(), r.attrFormat(), r.Path)
+}
+
+func (r FileVerifyResult) attrFormat() byte {
+	flags := int32(r.Flags)
+	switch {
+	case flags&RPMFILE_DOC != 0:
+		return 'd'
+	case flags&RPMFILE_CONFIG != 0:
+		return 'c'
+	case flags&RPMFILE_LICENSE != 0:
+		return 'l'
+	case flags&RPMFILE_README != 0:
+		return 'r'
+	case flags&RPMFILE_GHOST != 0:
+		return 'g'
+	case flags&RPMFILE_ARTIFACT != 0:
+		return 'a'
+	}
+	return ' '
+}
+
+// VerifyPackage checks the installed files of a package under root like rpm -V.
+// Symbolic links are only checked if root implements LstatFS and ReadLinkFS, owners are
+// resolved through etc/passwd and etc/group of root. Files which were not installed
+// (see RPMTAG_FILESTATES) are skipped.
+// ref. https://github.com/rpm-software-management/rpm/blob/rpm-4.14.3-release/lib/verify.c
+func VerifyPackage(root fs.FS, pkg *PackageInfo) ([]FileVerifyResult, error) {
+	files, err := pkg.InstalledFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	v := &verifier{
+		root:   root,
+		pkg:    pkg,
+		users:  readIDNames(root, "etc/passwd"),
+		groups: readIDNames(root, "etc/group"),
+	}
+
+	var results []FileVerifyResult
+	for i, file := range files {
+		result, ok := v.verifyFile(i, file)
+		if !ok {
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+type verifier struct {
+	root   fs.FS
+	pkg    *PackageInfo
+	users  map[uint32]string
+	groups map[uint32]string
+}
+
+func (v *verifier) verifyFile(i int, file FileInfo) (FileVerifyResult, bool) {
+	result := FileVerifyResult{Path: file.Path, Flags: file.Flags}
+	attrs := RPMVERIFY_ALL
+
+	switch file.State {
+	case RPMFILE_STATE_NETSHARED, RPMFILE_STATE_NOTINSTALLED:
+		return result, false
+	case RPMFILE_STATE_REPLACED:
+		// for replaced files only the existence can be verified
+		attrs = RPMVERIFY_NONE
+	case RPMFILE_STATE_WRONGCOLOR:
+		// files with the wrong color share some attributes with the actually installed file
+		attrs &^= RPMVERIFY_FILEDIGEST | RPMVERIFY_FILESIZE | RPMVERIFY_MTIME | RPMVERIFY_RDEV
+	}
+
+	name := fsPath(file.Path)
+	fi, err := lstat(v.root, name)
+	if err != nil {
+		result.Failed |= RPMVERIFY_LSTATFAIL
+		if !xerrors.Is(err, fs.ErrNotExist) {
+			result.Err = err
+		}
+		return result, true
+	}
+
+	// not all attributes of non-regular files can be verified
+	fileMode := unixFileMode(uint32(file.Mode))
+	switch {
+	case fi.Mode().IsDir():
+		attrs &^= RPMVERIFY_FILEDIGEST | RPMVERIFY_FILESIZE | RPMVERIFY_MTIME | RPMVERIFY_LINKTO | RPMVERIFY_CAPS
+	case fi.Mode()&fs.ModeSymlink != 0:
+		attrs &^= RPMVERIFY_FILEDIGEST | RPMVERIFY_FILESIZE | RPMVERIFY_MTIME | RPMVERIFY_MODE | RPMVERIFY_CAPS
+	case fi.Mode()&(fs.ModeNamedPipe|fs.ModeDevice) != 0:
+		attrs &^= RPMVERIFY_FILEDIGEST | RPMVERIFY_FILESIZE | RPMVERIFY_MTIME | RPMVERIFY_LINKTO | RPMVERIFY_CAPS
+	default:
+		attrs &^= RPMVERIFY_LINKTO | RPMVERIFY_RDEV
+	}
+	// symbolic links are followed without LstatFS
+	if _, ok := v.root.(LstatFS); !ok && fileMode&fs.ModeSymlink != 0 {
+		result.Unverified |= RPMVERIFY_MODE | RPMVERIFY_LINKTO
+		attrs &^= RPMVERIFY_MODE | RPMVERIFY_LINKTO | RPMVERIFY_FILEDIGEST | RPMVERIFY_FILESIZE | RPMVERIFY_MTIME | RPMVERIFY_CAPS
+	}
+	// content checks of %ghost files are meaningless
+	if int32(file.Flags)&RPMFILE_GHOST != 0 {
+		attrs &^= RPMVERIFY_FILEDIGEST | RPMVERIFY_FILESIZE | RPMVERIFY_MTIME | RPMVERIFY_LINKTO
+	}
+
+	if attrs&RPMVERIFY_FILEDIGEST != 0 {
+		v.verifyDigest(&result, file, name)
+	}
+
+	if attrs&RPMVERIFY_LINKTO != 0 {
+		v.verifyLink(&result, i, name)
+	}
+
+	if attrs&RPMVERIFY_FILESIZE != 0 {
+		size := int64(uint32(file.Size))
+		if len(v.pkg.LongFileSizes) > i {
+			size = v.pkg.LongFileSizes[i]
+		}
+		if fi.Size() != size {
+			result.Failed |= RPMVERIFY_FILESIZE
+		}
+	}
+
+	if attrs&RPMVERIFY_MODE != 0 {
+		const mask = fs.ModeType | fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+		mode := fi.Mode() & mask
+		// %ghost files may be created with a different file type at runtime
+		if int32(file.Flags)&RPMFILE_GHOST != 0 {
+			mode, fileMode = mode&^fs.ModeType, fileMode&^fs.ModeType
+		}
+		if mode != fileMode&mask {
+			result.Failed |= RPMVERIFY_MODE
+		}
+	}
+
+	if attrs&RPMVERIFY_RDEV != 0 {
+		wantDevice := fileMode&fs.ModeDevice != 0
+		isDevice := fi.Mode()&fs.ModeDevice != 0
+		switch {
+		case fileMode&(fs.ModeDevice|fs.ModeCharDevice) != fi.Mode()&(fs.ModeDevice|fs.ModeCharDevice):
+			result.Failed |= RPMVERIFY_RDEV
+		case wantDevice && isDevice:
+			var want uint16
+			if len(v.pkg.FileRdevs) > i {
+				want = v.pkg.FileRdevs[i]
+			}
+			if rdev, ok := fileRdev(fi); !ok {
+				result.Unverified |= RPMVERIFY_RDEV
+			} else if uint16(rdev&0xffff) != want {
+				result.Failed |= RPMVERIFY_RDEV
+			}
+		}
+	}
+
+	if attrs&RPMVERIFY_MTIME != 0 && len(v.pkg.FileMTimes) > i {
+		if fi.ModTime().Unix() != int64(uint32(v.pkg.FileMTimes[i])) {
+			result.Failed |= RPMVERIFY_MTIME
+		}
+	}
+
+	if attrs&(RPMVERIFY_USER|RPMVERIFY_GROUP) != 0 {
+		uid, gid, ok := fileOwner(fi)
+		if attrs&RPMVERIFY_USER != 0 {
+			verifyOwner(&result, RPMVERIFY_USER, v.users, uid, ok, file.Username)
+		}
+		if attrs&RPMVERIFY_GROUP != 0 {
+			verifyOwner(&result, RPMVERIFY_GROUP, v.groups, gid, ok, file.Groupname)
+		}
+	}
+
+	// file capabilities are stored in extended attributes which fs.FS can't access
+	if attrs&RPMVERIFY_CAPS != 0 && len(v.pkg.FileCaps) > i && v.pkg.FileCaps[i] != "" {
+		result.Unverified |= RPMVERIFY_CAPS
+	}
+
+	return result, true
+}
+
+func (v *verifier) verifyDigest(result *FileVerifyResult, file FileInfo, name string) {
+	if file.Digest == "" {
+		return
+	}
+
+	algo := v.pkg.FileDigestAlgorithm()
+	if hash, ok := algo.Hash(); !ok || !hash.Available() {
+		result.Unverified |= RPMVERIFY_FILEDIGEST
+		return
+	}
+
+	digest, err := HashFile(v.root, name, algo)
+	if err != nil {
+		result.Unverified |= RPMVERIFY_FILEDIGEST
+		result.Err = err
+		return
+	}
+	if digest != strings.ToLower(file.Digest) {
+		result.Failed |= RPMVERIFY_FILEDIGEST
+	}
+}
+
+func (v *verifier) verifyLink(result *FileVerifyResult, i int, name string) {
+	rlfs, ok := v.root.(ReadLinkFS)
+	if !ok {
+		result.Unverified |= RPMVERIFY_LINKTO
+		return
+	}
+
+	var want string
+	if len(v.pkg.FileLinkTos) > i {
+		want = v.pkg.FileLinkTos[i]
+	}
+	target, err := rlfs.ReadLink(name)
+	if err != nil {
+		result.Unverified |= RPMVERIFY_LINKTO
+		result.Err = xerrors.Errorf("failed to read link %s: %w", result.Path, err)
+		return
+	}
+	if target != want {
+		result.Failed |= RPMVERIFY_LINKTO
+	}
+}
+
+func verifyOwner(result *FileVerifyResult, attr VerifyAttrs, names map[uint32]string, id uint32, ok bool, want string) {
+	if !ok || names == nil {
+		result.Unverified |= attr
+		return
+	}
+	if name, found := names[id]; !found || name != want {
+		result.Failed |= attr
+	}
+}
+
+// readIDNames parses an /etc/passwd or /etc/group style file into a map of id to name.
+// nil is returned when the file can't be read.
+func readIDNames(root fs.FS, name string) map[uint32]string {
+	f, err := root.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	names := make(map[uint32]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		// the first entry wins, as with getpwuid(3)
+		if _, ok := names[uint32(id)]; !ok {
+			names[uint32(id)] = fields[0]
+		}
+	}
+	if scanner.Err() != nil {
+		return nil
+	}
+	return names
+}
@@ -0,0 +1,255 @@
+package rpmdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestVerifyPackage(t *testing.T) {
+	root := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+
+	writeFile := func(name, content string, mode os.FileMode) {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), mode))
+		require.NoError(t, os.Chmod(path, mode))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	writeFile("etc/passwd", fmt.Sprintf("# comment\nroot:x:%d:%d::/root:/bin/sh\n", os.Getuid(), os.Getgid()), 0o644)
+	writeFile("etc/group", fmt.Sprintf("root:x:%d:\n", os.Getgid()), 0o644)
+	writeFile("usr/bin/hello", "hello\n", 0o755)
+	writeFile("usr/bin/modified", "modified\n", 0o755)
+	writeFile("usr/bin/chmod", "chmod\n", 0o600)
+	writeFile("usr/bin/owned", "owned\n", 0o755)
+	writeFile("etc/hello.conf", "changed=true\n", 0o644)
+	writeFile("etc/strict.conf", "changed=true\n", 0o644)
+	require.NoError(t, os.Symlink("hello", filepath.Join(root, "usr/bin/hi")))
+	require.NoError(t, os.Symlink("hello", filepath.Join(root, "usr/bin/relinked")))
+
+	digest := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+
+	type file struct {
+		name, digest, linkTo, user string
+		mode                       uint16
+		size                       int32
+		flags                      int32
+		state                      FileState
+	}
+	files := []file{
+		{name: "hello", digest: digest("hello\n"), mode: s_IFREG | 0o755, size: 6},
+		{name: "modified", digest: digest("original\n"), mode: s_IFREG | 0o755, size: 9},
+		{name: "chmod", digest: digest("chmod\n"), mode: s_IFREG | 0o755, size: 6},
+		{name: "owned", digest: digest("owned\n"), mode: s_IFREG | 0o755, size: 6, user: "nobody"},
+		{name: "hi", linkTo: "hello", mode: s_IFLNK | 0o777, size: 5},
+		{name: "relinked", linkTo: "hi", mode: s_IFLNK | 0o777, size: 2},
+		{name: "missing", digest: digest("missing\n"), mode: s_IFREG | 0o644, size: 8, flags: RPMFILE_DOC},
+		{name: "ghost", mode: s_IFREG | 0o644, flags: RPMFILE_GHOST},
+		{name: "optional", mode: s_IFREG | 0o644, flags: RPMFILE_CONFIG | RPMFILE_MISSINGOK},
+		{name: "excluded", digest: digest("excluded\n"), mode: s_IFREG | 0o644, size: 9, flags: RPMFILE_DOC, state: RPMFILE_STATE_NOTINSTALLED},
+		{name: "hello.conf", digest: digest("changed=false\n"), mode: s_IFREG | 0o644, size: 14, flags: RPMFILE_CONFIG | RPMFILE_NOREPLACE},
+		{name: "strict.conf", digest: digest("changed=false\n"), mode: s_IFREG | 0o644, size: 14, flags: RPMFILE_CONFIG},
+	}
+
+	var baseNames, digests, linkTos, users, groups []string
+	var dirIndexes, sizes, flags, mtimes []int32
+	var modes []uint16
+	var states []byte
+	for _, f := range files {
+		baseNames = append(baseNames, f.name)
+		dirIndex := int32(0)
+		if filepath.Ext(f.name) == ".conf" {
+			dirIndex = 1
+		}
+		dirIndexes = append(dirIndexes, dirIndex)
+		digests = append(digests, f.digest)
+		linkTos = append(linkTos, f.linkTo)
+		user := f.user
+		if user == "" {
+			user = "root"
+		}
+		users = append(users, user)
+		groups = append(groups, "root")
+		sizes = append(sizes, f.size)
+		flags = append(flags, f.flags)
+		mtimes = append(mtimes, int32(mtime.Unix()))
+		modes = append(modes, f.mode)
+		states = append(states, byte(f.state))
+	}
+
+	blob := (&headerBuilder{}).
+		addString(RPMTAG_NAME, "hello").
+		addStringArray(RPMTAG_BASENAMES, baseNames...).
+		addStringArray(RPMTAG_DIRNAMES, "/usr/bin/", "/etc/").
+		addInt32(RPMTAG_DIRINDEXES, dirIndexes...).
+		addStringArray(RPMTAG_FILEDIGESTS, digests...).
+		addInt32(RPMTAG_FILEDIGESTALGO, PGPHASHALGO_SHA256).
+		addStringArray(RPMTAG_FILELINKTOS, linkTos...).
+		addStringArray(RPMTAG_FILEUSERNAME, users...).
+		addStringArray(RPMTAG_FILEGROUPNAME, groups...).
+		addInt32(RPMTAG_FILESIZES, sizes...).
+		addInt32(RPMTAG_FILEFLAGS, flags...).
+		addInt32(RPMTAG_FILEMTIMES, mtimes...).
+		addInt16(RPMTAG_FILEMODES, modes...).
+		add(RPMTAG_FILESTATES, RPM_CHAR_TYPE, uint32(len(states)), states).
+		bytes()
+	indexEntries, err := headerImport(blob)
+	require.NoError(t, err)
+	pkg, err := getNEVRA(indexEntries)
+	require.NoError(t, err)
+
+	results, err := VerifyPackage(DirFS(root), pkg)
+	require.NoError(t, err)
+
+	var got []string
+	var failed []string
+	for _, r := range results {
+		require.NoError(t, r.Err)
+		got = append(got, r.String())
+		if !r.OK() {
+			failed = append(failed, r.Path)
+		}
+	}
+	assert.Equal(t, []string{
+		".........    /usr/bin/hello",
+		"..5......    /usr/bin/modified",
+		".M.......    /usr/bin/chmod",
+		".....U...    /usr/bin/owned",
+		".........    /usr/bin/hi",
+		"....L....    /usr/bin/relinked",
+		"missing   d /usr/bin/missing",
+		"missing   g /usr/bin/ghost",
+		"missing   c /usr/bin/optional",
+		"S.5......  c /etc/hello.conf",
+		"S.5......  c /etc/strict.conf",
+	}, got)
+	assert.Equal(t, []string{
+		"/usr/bin/modified",
+		"/usr/bin/chmod",
+		"/usr/bin/owned",
+		"/usr/bin/relinked",
+		"/usr/bin/missing",
+		"/etc/strict.conf",
+	}, failed)
+
+	assert.True(t, results[9].ConfigModified())
+	assert.False(t, results[1].ConfigModified())
+}
+
+func TestVerifyPackageWithoutLstat(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "target"), nil, 0o644))
+	require.NoError(t, os.Symlink("target", filepath.Join(root, "link")))
+
+	blob := (&headerBuilder{}).
+		addString(RPMTAG_NAME, "link").
+		addStringArray(RPMTAG_BASENAMES, "link").
+		addStringArray(RPMTAG_DIRNAMES, "/").
+		addInt32(RPMTAG_DIRINDEXES, 0).
+		addStringArray(RPMTAG_FILELINKTOS, "target").
+		addInt16(RPMTAG_FILEMODES, s_IFLNK|0o777).
+		bytes()
+	indexEntries, err := headerImport(blob)
+	require.NoError(t, err)
+	pkg, err := getNEVRA(indexEntries)
+	require.NoError(t, err)
+
+	// a plain fs.FS follows symbolic links, owners can't be resolved without etc/passwd
+	results, err := VerifyPackage(struct{ fs.FS }{os.DirFS(root)}, pkg)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, ".?..???..    /link", results[0].String())
+	assert.True(t, results[0].OK())
+}
+
+func TestDigestAlgorithmHash(t *testing.T) {
+	hash, ok := DigestAlgorithm(PGPHASHALGO_SHA256).Hash()
+	require.True(t, ok)
+	assert.Equal(t, 32, hash.Size())
+
+	_, ok = DigestAlgorithm(PGPHASHALGO_MD2).Hash()
+	assert.False(t, ok)
+}
+
+func TestDirFS(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "usr/bin"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "usr/bin/sh"), []byte("sh\n"), 0o755))
+	host := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(host, "secret"), []byte("secret\n"), 0o644))
+
+	// links of an image which lead out of it when resolved on the host
+	require.NoError(t, os.Symlink("/etc", filepath.Join(root, "etc")))
+	require.NoError(t, os.Symlink(host, filepath.Join(root, "host")))
+	require.NoError(t, os.Symlink(strings.Repeat("../", 16)+strings.TrimPrefix(filepath.ToSlash(host), "/"),
+		filepath.Join(root, "usr/host")))
+	// links which stay inside the image
+	require.NoError(t, os.Symlink("/usr/bin", filepath.Join(root, "bin")))
+	require.NoError(t, os.Symlink("../../bin/sh", filepath.Join(root, "usr/bin/bash")))
+	require.NoError(t, os.Symlink("loop", filepath.Join(root, "loop")))
+
+	fsys := DirFS(root)
+
+	// etc -> /etc refers to itself inside the image, as in a chroot
+	_, err := fs.Stat(fsys, "etc/passwd")
+	assert.Error(t, err)
+	_, err = HashFile(fsys, "etc/passwd", PGPHASHALGO_SHA256)
+	assert.Error(t, err)
+	_, err = fs.Stat(fsys, "loop")
+	assert.Error(t, err)
+
+	for _, name := range []string{"host/secret", "usr/host/secret"} {
+		_, err := fs.Stat(fsys, name)
+		assert.True(t, xerrors.Is(err, fs.ErrNotExist), "%s: %v", name, err)
+		_, err = HashFile(fsys, name, PGPHASHALGO_SHA256)
+		assert.True(t, xerrors.Is(err, fs.ErrNotExist), "%s: %v", name, err)
+	}
+
+	sum := sha256.Sum256([]byte("sh\n"))
+	for _, name := range []string{"bin/sh", "usr/bin/bash"} {
+		digest, err := HashFile(fsys, name, PGPHASHALGO_SHA256)
+		require.NoError(t, err, name)
+		assert.Equal(t, hex.EncodeToString(sum[:]), digest, name)
+	}
+
+	info, err := fsys.(LstatFS).Lstat("etc")
+	require.NoError(t, err)
+	assert.Equal(t, fs.ModeSymlink, info.Mode()&fs.ModeSymlink)
+	target, err := fsys.(ReadLinkFS).ReadLink("usr/bin/bash")
+	require.NoError(t, err)
+	assert.Equal(t, "../../bin/sh", target)
+
+	// the host's /etc/passwd isn't verified as the package's file
+	blob := (&headerBuilder{}).
+		addString(RPMTAG_NAME, "setup").
+		addStringArray(RPMTAG_BASENAMES, "passwd").
+		addStringArray(RPMTAG_DIRNAMES, "/etc/").
+		addInt32(RPMTAG_DIRINDEXES, 0).
+		addStringArray(RPMTAG_FILEDIGESTS, hex.EncodeToString(sum[:])).
+		addInt32(RPMTAG_FILEDIGESTALGO, PGPHASHALGO_SHA256).
+		addInt16(RPMTAG_FILEMODES, s_IFREG|0o644).
+		bytes()
+	indexEntries, err := headerImport(blob)
+	require.NoError(t, err)
+	pkg, err := getNEVRA(indexEntries)
+	require.NoError(t, err)
+
+	results, err := VerifyPackage(fsys, pkg)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "missing     /etc/passwd", results[0].String())
+}